@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/backup"
+	"github.com/boringsoft/ha-mi/internal/config"
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+// commands are the subcommands of the binary besides running the server.
+// The maintenance commands work directly on the database and config file
+// and are meant to be run while the server is stopped.
+var commands = map[string]func(args []string) int{
+	"ctl":            runCtl,
+	"migrate":        runMigrate,
+	"create-user":    runCreateUser,
+	"reset-password": runResetPassword,
+	"rotate-secret":  runRotateSecret,
+	"backup":         runBackup,
+	"restore":        runRestore,
+	"config":         runConfig,
+}
+
+// adminCommand parses the flags of a maintenance command, loads the
+// configuration and opens the database before calling run
+func adminCommand(name string, args []string, setup func(fs *flag.FlagSet), run func(cfg *config.Config, configPath string, database *db.DB) error) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
+	if setup != nil {
+		setup(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	database, err := openDatabase(cfg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	if err := run(cfg, *configPath, database); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// openDatabase opens the configured database and brings its schema up to date
+func openDatabase(cfg *config.Config) (*db.DB, error) {
+	database, err := connectDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.Initialize(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("error initializing database: %w", err)
+	}
+
+	return database, nil
+}
+
+// connectDatabase opens the configured database without touching its schema
+func connectDatabase(cfg *config.Config) (*db.DB, error) {
+	// Ensure database directory exists
+	dbDir := filepath.Dir(cfg.Database.Path)
+	if err := os.MkdirAll(dbDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating database directory: %w", err)
+	}
+
+	database, err := db.New(cfg.Database.Driver, cfg.Database.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	return database, nil
+}
+
+// runMigrate applies the pending schema migrations, or lists them with -dry-run
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
+	dryRun := fs.Bool("dry-run", false, "List the pending migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	database, err := connectDatabase(cfg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	version, err := database.SchemaVersion()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Database %s is at schema version %d\n", cfg.Database.Path, version)
+
+	var migrations []db.Migration
+	if *dryRun {
+		migrations, err = database.PendingMigrations()
+	} else {
+		migrations, err = database.Migrate()
+	}
+
+	for _, m := range migrations {
+		if *dryRun {
+			fmt.Printf("Pending %04d_%s\n", m.Version, m.Name)
+		} else {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	if len(migrations) == 0 {
+		fmt.Println("Schema is up to date")
+	}
+	return 0
+}
+
+// runCreateUser adds a user, e.g. a second admin to recover a locked out installation
+func runCreateUser(args []string) int {
+	var username, password, email, role string
+
+	setup := func(fs *flag.FlagSet) {
+		fs.StringVar(&username, "username", "", "Username (required)")
+		fs.StringVar(&password, "password", "", "Password, a random one is generated and printed when empty")
+		fs.StringVar(&email, "email", "", "Email address")
+		fs.StringVar(&role, "role", auth.RoleViewer, "Role name")
+	}
+
+	return adminCommand("create-user", args, setup, func(_ *config.Config, _ string, database *db.DB) error {
+		if username == "" {
+			return errors.New("-username is required")
+		}
+
+		if _, err := auth.NewRoleService(database.DB, sqlstore.New(database.DB).Users).GetRole(role); err != nil {
+			return fmt.Errorf("role %q: %w", role, err)
+		}
+
+		generated := password == ""
+		if generated {
+			var err error
+			if password, err = randomPassword(); err != nil {
+				return err
+			}
+		}
+
+		user, err := auth.NewUserService(sqlstore.New(database.DB).Users).CreateUser(username, password, email, role)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Created user %s (%s) with role %s\n", user.Username, user.ID, user.Role)
+		if generated {
+			fmt.Printf("Password: %s\n", password)
+		}
+		return nil
+	})
+}
+
+// runResetPassword sets a new password for a user, revokes their sessions and clears their lockout
+func runResetPassword(args []string) int {
+	var username, password string
+	var disableMFA bool
+
+	setup := func(fs *flag.FlagSet) {
+		fs.StringVar(&username, "username", "", "Username (required)")
+		fs.StringVar(&password, "password", "", "New password, a random one is generated and printed when empty")
+		fs.BoolVar(&disableMFA, "disable-mfa", false, "Also disable two-factor authentication, e.g. after losing the authenticator")
+	}
+
+	return adminCommand("reset-password", args, setup, func(cfg *config.Config, _ string, database *db.DB) error {
+		if username == "" {
+			return errors.New("-username is required")
+		}
+
+		userService := auth.NewUserService(sqlstore.New(database.DB).Users)
+		user, err := userService.GetUserByUsername(username)
+		if err != nil {
+			return err
+		}
+
+		generated := password == ""
+		if generated {
+			if password, err = randomPassword(); err != nil {
+				return err
+			}
+		}
+
+		if err := userService.SetPassword(user.ID, password); err != nil {
+			return err
+		}
+
+		// Sessions are only revoked in the database, the server loads them on start
+		keyring := auth.NewKeyring(database.DB, cfg.Auth.JWTAlgorithm, cfg.Auth.JWTKeyFile, cfg.Auth.RefreshTokenExpiry.Duration())
+		jwtService := auth.NewJWTService(keyring, cfg.Auth.SecretKey, cfg.Auth.AccessTokenExpiry.Duration(), cfg.Auth.RefreshTokenExpiry.Duration())
+		sessionService := auth.NewSessionService(database.DB, jwtService, userService)
+		if err := sessionService.RevokeAllSessions(user.ID); err != nil {
+			return err
+		}
+
+		loginLimiter := auth.NewLoginLimiter(database.DB, auth.LockoutPolicy{})
+		if err := loginLimiter.Unlock(auth.LockoutScopeUsername, user.Username); err != nil && !errors.Is(err, auth.ErrLockoutNotFound) {
+			return err
+		}
+
+		if disableMFA {
+			if err := auth.NewMFAService(database.DB).Disable(user.ID); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Password of %s has been reset and all sessions revoked\n", user.Username)
+		if generated {
+			fmt.Printf("Password: %s\n", password)
+		}
+		return nil
+	})
+}
+
+// runRotateSecret rotates the JWT signing key or the request signing key
+func runRotateSecret(args []string) int {
+	var target string
+
+	setup := func(fs *flag.FlagSet) {
+		fs.StringVar(&target, "target", "jwt", "Secret to rotate: jwt (signing keyring) or request (request_signing_key in the config file)")
+	}
+
+	return adminCommand("rotate-secret", args, setup, func(cfg *config.Config, configPath string, database *db.DB) error {
+		switch target {
+		case "jwt":
+			keyring := auth.NewKeyring(database.DB, cfg.Auth.JWTAlgorithm, cfg.Auth.JWTKeyFile, cfg.Auth.RefreshTokenExpiry.Duration())
+			if err := keyring.Load(); err != nil {
+				return err
+			}
+
+			key, err := keyring.Rotate()
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("New %s signing key %s is active, previous keys stay valid for verification\n", key.Algorithm, key.ID)
+			return nil
+
+		case "request":
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return fmt.Errorf("failed to generate secret: %w", err)
+			}
+
+			// Rewrite the file as it is, without the environment overrides
+			fileCfg, err := config.LoadFile(configPath)
+			if err != nil {
+				return err
+			}
+			fileCfg.Auth.RequestSigningKey = hex.EncodeToString(secret)
+			if err := config.SaveConfig(configPath, fileCfg); err != nil {
+				return err
+			}
+
+			fmt.Printf("New request signing key written to %s, update your clients:\n%s\n", configPath, fileCfg.Auth.RequestSigningKey)
+			if _, ok := os.LookupEnv(config.EnvPrefix + "AUTH_REQUEST_SIGNING_KEY"); ok {
+				fmt.Printf("Note: %sAUTH_REQUEST_SIGNING_KEY is set and overrides the config file\n", config.EnvPrefix)
+			}
+			return nil
+		}
+
+		return fmt.Errorf("unknown target %q", target)
+	})
+}
+
+// runBackup writes a snapshot of the database. Unlike the other maintenance
+// commands it is safe to run while the server is running.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
+	output := fs.String("o", "", "Backup file, a new snapshot in the configured backup directory when empty")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	// Leave the schema alone, a running server owns it
+	database, err := connectDatabase(cfg)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	path := *output
+	if path == "" {
+		snapshot, err := backup.NewManager(database, cfg.Database.Backup.Dir, cfg.Database.Backup.Retention).Create()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return 1
+		}
+		path = filepath.Join(cfg.Database.Backup.Dir, snapshot.Name)
+	} else if err := database.Backup(path); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Database backed up to %s\n", path)
+	return 0
+}
+
+// runRestore replaces the database with a backup after checking its schema version
+func runRestore(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Println("Usage: ha-mi restore [-config file] <backup file>")
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	version, err := db.Restore(cfg.Database.Driver, cfg.Database.Path, fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Database %s restored from %s at schema version %d\n", cfg.Database.Path, fs.Arg(0), version)
+	if _, err := os.Stat(cfg.Database.Path + db.PreRestoreSuffix); err == nil {
+		fmt.Printf("The previous database was kept at %s\n", cfg.Database.Path+db.PreRestoreSuffix)
+	}
+	return 0
+}
+
+// runConfig runs the config subcommands: validate checks a config file
+// without starting the server and prints every problem found, schema
+// prints the JSON Schema of the config file
+func runConfig(args []string) int {
+	if len(args) == 0 || (args[0] != "validate" && args[0] != "schema") {
+		fmt.Println("Usage: ha-mi config validate [-config file]")
+		fmt.Println("       ha-mi config schema > config.schema.json")
+		return 2
+	}
+
+	if args[0] == "schema" {
+		schema, err := config.SchemaJSON()
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return 1
+		}
+		fmt.Println(string(schema))
+		return 0
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	configPath := fs.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	// Load, unlike LoadConfig, does not create a missing file
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		return 1
+	}
+
+	if err := cfg.Validate(); err != nil {
+		printConfigError(err)
+		return 1
+	}
+	for _, warning := range cfg.Warnings() {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	fmt.Printf("Configuration %s is valid (%s mode)\n", *configPath, cfg.Server.Mode)
+	return 0
+}
+
+// printConfigError prints a validation error one problem per line
+func printConfigError(err error) {
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	for _, problem := range validationErr.Problems {
+		fmt.Println(problem)
+	}
+}
+
+// randomPassword generates a random password for new and reset accounts
+func randomPassword() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
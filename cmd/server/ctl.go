@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/boringsoft/ha-mi/pkg/client"
+)
+
+const ctlUsage = `Usage: ha-mi ctl [flags] <command> [args]
+
+Commands:
+  mappings import [-mode merge|replace] [-dry-run] <file>
+                                           Import a YAML or JSON mapping document
+  mappings export [file]                   Export the mapping document as YAML, or JSON for .json files and -o json
+  logs [-follow] [-action name] [-n count] Show the audit log
+
+Flags:
+`
+
+// Environment variables of ctl. They use their own prefix because HAMI_* is
+// read by the server configuration.
+const (
+	envServer     = "HAMICTL_SERVER"
+	envSigningKey = "HAMICTL_SIGNING_KEY"
+	envAPIKey     = "HAMICTL_API_KEY"
+	envUser       = "HAMICTL_USER"
+	envPassword   = "HAMICTL_PASSWORD"
+)
+
+// ctl holds the options shared by all ctl commands
+type ctl struct {
+	server     string
+	signingKey string
+	apiKey     string
+	username   string
+	password   string
+	otp        string
+	output     string
+	tokenFile  string
+
+	client *client.Client
+}
+
+// runCtl runs the ctl command-line client and returns the process exit code
+func runCtl(args []string) int {
+	c := &ctl{}
+
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fs.StringVar(&c.server, "server", envOr(envServer, "http://localhost:8080"), "Server URL ("+envServer+")")
+	fs.StringVar(&c.signingKey, "signing-key", os.Getenv(envSigningKey), "Request signing key ("+envSigningKey+")")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv(envAPIKey), "API key, used instead of logging in ("+envAPIKey+")")
+	fs.StringVar(&c.username, "user", envOr(envUser, "admin"), "Username ("+envUser+")")
+	fs.StringVar(&c.password, "password", os.Getenv(envPassword), "Password ("+envPassword+")")
+	fs.StringVar(&c.otp, "otp", "", "Two-factor authentication code")
+	fs.StringVar(&c.tokenFile, "token-file", defaultTokenFile(), "File caching the login tokens between runs")
+	c.outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ctlUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := c.run(ctx, fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// run dispatches a ctl command
+func (c *ctl) run(ctx context.Context, args []string) error {
+	command := strings.Join(args[:min(2, len(args))], " ")
+	switch {
+	case command == "mappings import":
+		return c.mappingsImport(ctx, args[2:])
+	case command == "mappings export":
+		return c.mappingsExport(ctx, args[2:])
+	case args[0] == "logs":
+		return c.logs(ctx, args[1:])
+	}
+	return fmt.Errorf("unknown command %q, run 'ha-mi ctl -h' for usage", command)
+}
+
+// outputFlag registers the output format flag on a flag set
+func (c *ctl) outputFlag(fs *flag.FlagSet) {
+	if c.output == "" {
+		c.output = "table"
+	}
+	fs.StringVar(&c.output, "o", c.output, "Output format: table or json")
+}
+
+// parse parses the flags of a leaf command and checks its positional arguments
+func (c *ctl) parse(fs *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	c.outputFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < minArgs || (maxArgs >= 0 && fs.NArg() > maxArgs) {
+		return fmt.Errorf("wrong number of arguments for %s", fs.Name())
+	}
+	if c.output != "table" && c.output != "json" {
+		return fmt.Errorf("unknown output format %q", c.output)
+	}
+	return nil
+}
+
+// connect creates the API client, logging in unless an API key or cached tokens are available
+func (c *ctl) connect(ctx context.Context) error {
+	if c.apiKey != "" {
+		c.client = client.New(c.server, c.signingKey, client.WithAPIKey(c.apiKey))
+		return nil
+	}
+
+	if c.signingKey == "" {
+		return errors.New("a request signing key is required, set -signing-key or " + envSigningKey)
+	}
+
+	c.client = client.New(c.server, c.signingKey, client.WithDevice("ha-mi ctl"))
+
+	// Reuse cached tokens unless they expired and can no longer be refreshed
+	if tokens, ok := c.loadTokens(); ok {
+		c.client.SetTokens(tokens)
+		if time.Until(tokens.ExpiresAt) > time.Minute || c.client.Refresh(ctx) == nil {
+			return nil
+		}
+		c.client.SetTokens(client.Tokens{})
+	}
+
+	if c.password == "" {
+		return errors.New("not logged in, set -password or " + envPassword)
+	}
+
+	err := c.client.Login(ctx, c.username, c.password)
+	if errors.Is(err, client.ErrMFARequired) {
+		if c.otp == "" {
+			return errors.New("two-factor authentication is enabled, pass the code with -otp")
+		}
+		err = c.client.LoginMFA(ctx, c.otp)
+	}
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	c.saveTokens()
+	return nil
+}
+
+// tokenCache is the content of the token file
+type tokenCache struct {
+	Server string        `json:"server"`
+	User   string        `json:"user"`
+	Tokens client.Tokens `json:"tokens"`
+}
+
+// loadTokens reads cached tokens for the current server and user
+func (c *ctl) loadTokens() (client.Tokens, bool) {
+	if c.tokenFile == "" {
+		return client.Tokens{}, false
+	}
+
+	data, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return client.Tokens{}, false
+	}
+
+	var cache tokenCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return client.Tokens{}, false
+	}
+	if cache.Server != c.server || cache.User != c.username || cache.Tokens.RefreshToken == "" {
+		return client.Tokens{}, false
+	}
+	return cache.Tokens, true
+}
+
+// saveTokens caches the current tokens so the next run does not log in again
+func (c *ctl) saveTokens() {
+	if c.tokenFile == "" || c.client == nil || c.apiKey != "" {
+		return
+	}
+
+	data, err := json.Marshal(tokenCache{
+		Server: c.server,
+		User:   c.username,
+		Tokens: c.client.Tokens(),
+	})
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.tokenFile), 0700); err != nil {
+		return
+	}
+	_ = os.WriteFile(c.tokenFile, data, 0600)
+}
+
+// mappingsImport handles "mappings import <file>"
+func (c *ctl) mappingsImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mappings import", flag.ContinueOnError)
+	mode := fs.String("mode", client.ImportMerge, "Import mode: merge or replace")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes the import would make")
+	if err := c.parse(fs, args, 1, 1); err != nil {
+		return err
+	}
+
+	doc, err := readDocument(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	defer c.saveTokens()
+
+	result, err := c.client.Import(ctx, doc, *mode, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		return printJSON(result)
+	}
+
+	if len(result.Changes) == 0 {
+		fmt.Println("No changes")
+		return nil
+	}
+
+	err = printTable([]string{"ACTION", "KIND", "NAME"}, func(row func(...interface{})) {
+		for _, ch := range result.Changes {
+			row(ch.Action, ch.Kind, ch.Name)
+		}
+	})
+	if err == nil && result.DryRun {
+		fmt.Println("Dry run, nothing was changed")
+	}
+	return err
+}
+
+// mappingsExport handles "mappings export [file]"
+func (c *ctl) mappingsExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("mappings export", flag.ContinueOnError)
+	if err := c.parse(fs, args, 0, 1); err != nil {
+		return err
+	}
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	defer c.saveTokens()
+
+	doc, err := c.client.Export(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fs.NArg() == 1:
+		return writeDocument(fs.Arg(0), doc)
+	case c.output == "json":
+		return printJSON(doc)
+	}
+	return yaml.NewEncoder(os.Stdout).Encode(doc)
+}
+
+// logs handles "logs [-follow]"
+func (c *ctl) logs(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("follow", false, "Keep polling for new entries")
+	action := fs.String("action", "", "Only show entries with this action, e.g. login.failure")
+	count := fs.Int("n", 50, "Number of recent entries to show")
+	interval := fs.Duration("interval", 2*time.Second, "Polling interval with -follow")
+	if err := c.parse(fs, args, 0, 0); err != nil {
+		return err
+	}
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+	defer c.saveTokens()
+
+	filter := client.AuditFilter{Action: *action, Limit: *count}
+
+	// JSON output is one entry per line so it can be piped while following
+	encoder := json.NewEncoder(os.Stdout)
+	var w *tabwriter.Writer
+	if c.output == "table" {
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tTIME\tACTION\tUSER\tIP\tDETAIL")
+	}
+
+	for {
+		entries, err := c.client.AuditLogs(ctx, filter)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		for _, e := range entries {
+			if w != nil {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", e.ID, time.Unix(e.CreatedAt, 0).Format(time.DateTime), e.Action, e.Username, e.IP, e.Detail)
+			} else if err := encoder.Encode(e); err != nil {
+				return err
+			}
+			filter.AfterID = e.ID
+		}
+		if w != nil {
+			w.Flush()
+		}
+
+		if !*follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// printJSON prints a value as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// printTable prints rows as an aligned table
+func printTable(headers []string, rows func(row func(...interface{}))) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	rows(func(values ...interface{}) {
+		cells := make([]string, len(values))
+		for i, v := range values {
+			cells[i] = fmt.Sprint(v)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	})
+	return w.Flush()
+}
+
+// readDocument reads a YAML or JSON mapping document, depending on the file extension
+func readDocument(path string) (*client.Document, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc client.Document
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &doc)
+	} else {
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// writeDocument writes a mapping document as JSON or YAML by file extension
+func writeDocument(path string, doc *client.Document) error {
+	var data []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err = json.MarshalIndent(doc, "", "  ")
+		data = append(data, '\n')
+	} else {
+		data, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// defaultTokenFile returns the default location of the ctl token cache
+func defaultTokenFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "ha-mi", "ctl-token.json")
+}
+
+// envOr returns the environment variable or a fallback when it is unset
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
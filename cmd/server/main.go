@@ -3,15 +3,24 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
 
 	"github.com/boringsoft/ha-mi/internal/api"
+	"github.com/boringsoft/ha-mi/internal/auth"
 	"github.com/boringsoft/ha-mi/internal/config"
-	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/logging"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
 )
 
 func main() {
+	// Run a subcommand instead of the server
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to configuration file (supports .yaml, .yml, .json)")
 	flag.Parse()
@@ -23,37 +32,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Ensure database directory exists
-	dbDir := filepath.Dir(cfg.Database.Path)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		fmt.Printf("Error creating database directory: %s\n", err)
+	// Refuse to start with settings the server cannot run with
+	if err := cfg.Validate(); err != nil {
+		fmt.Println("Invalid configuration:")
+		printConfigError(err)
 		os.Exit(1)
 	}
 
-	// Initialize database
-	database, err := db.New(cfg.Database.Path)
+	// Log from here on
+	if err := logging.Setup(cfg.Log.Format, cfg.Log.Level); err != nil {
+		fmt.Printf("Error setting up logging: %s\n", err)
+		os.Exit(1)
+	}
+	for _, warning := range cfg.Warnings() {
+		slog.Warn("Deprecated configuration", "setting", warning.Field, "problem", warning.Message)
+	}
+
+	// Open the database and bring its schema up to date
+	database, err := openDatabase(cfg)
 	if err != nil {
-		fmt.Printf("Error connecting to database: %s\n", err)
+		slog.Error("Error opening database", "error", err)
 		os.Exit(1)
 	}
 	defer database.Close()
 
-	// Initialize database schema
-	if err := database.Initialize(); err != nil {
-		fmt.Printf("Error initializing database: %s\n", err)
+	// Seed the configured user as the first admin
+	if err := auth.NewUserService(sqlstore.New(database.DB).Users).SeedAdmin(cfg.Auth.User, cfg.Auth.Password); err != nil {
+		slog.Error("Error seeding admin user", "error", err)
 		os.Exit(1)
 	}
 
 	// Create and start server
-	server := api.NewServer(cfg, database)
+	server := api.NewServer(config.NewManager(*configPath, cfg), database)
 	if err := server.Start(); err != nil {
-		fmt.Printf("Error starting server: %s\n", err)
+		slog.Error("Error starting server", "error", err)
 		os.Exit(1)
 	}
 
-	fmt.Println("HA-MI server is running...")
-	fmt.Println("Press Ctrl+C to stop")
-
 	// Wait for shutdown signal
 	server.WaitForShutdown()
 }
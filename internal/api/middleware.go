@@ -1,17 +1,134 @@
 package api
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"runtime/debug"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/logging"
+	"github.com/boringsoft/ha-mi/internal/metrics"
 )
 
-// AuthMiddleware authenticates requests using JWT
-func AuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
+// validRequestID matches request IDs accepted from clients and proxies
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware gives every request an ID, taken from the X-Request-ID
+// header when the client or a proxy sent one. The ID is returned in the
+// response header and carried in the request context for logging.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader(logging.RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = logging.NewRequestID()
+		}
+
+		ctx.Set("requestId", requestID)
+		ctx.Header(logging.RequestIDHeader, requestID)
+		ctx.Request = ctx.Request.WithContext(logging.WithRequestID(ctx.Request.Context(), requestID))
+
+		ctx.Next()
+	}
+}
+
+// AccessLogMiddleware logs every request once it has been handled
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", ctx.Request.Method),
+			slog.String("route", ctx.FullPath()),
+			slog.String("path", ctx.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", ctx.ClientIP()),
+			slog.Int("size", ctx.Writer.Size()),
+		}
+		if query := ctx.Request.URL.RawQuery; query != "" {
+			attrs = append(attrs, slog.String("query", logging.RedactQuery(query)))
+		}
+		if userID := ctx.GetString("userId"); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+		if len(ctx.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", ctx.Errors.String()))
+		}
+
+		reqCtx := ctx.Request.Context()
+		logging.FromContext(reqCtx).LogAttrs(reqCtx, level, "HTTP request", attrs...)
+	}
+}
+
+// MetricsMiddleware counts requests and their latency by route and status
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+
+		ctx.Next()
+
+		metrics.ObserveHTTPRequest(ctx.Request.Method, ctx.FullPath(), ctx.Writer.Status(), time.Since(start))
+	}
+}
+
+// RecoveryMiddleware turns panics in handlers into 500 responses and logs them with the stack
+func RecoveryMiddleware() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				logging.FromContext(ctx.Request.Context()).Error("Panic while handling request",
+					"method", ctx.Request.Method,
+					"path", ctx.Request.URL.Path,
+					"panic", err,
+					"stack", string(debug.Stack()),
+				)
+				ctx.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+
+		ctx.Next()
+	}
+}
+
+// AuthMiddleware authenticates requests using a JWT access token or an API key
+func AuthMiddleware(jwtService *auth.JWTService, sessionService *auth.SessionService, apiKeyService *auth.APIKeyService, userService *auth.UserService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		// Authenticate with an API key if one is provided
+		if key := apiKeyFromRequest(ctx); key != "" {
+			apiKey, user, err := apiKeyService.Authenticate(key)
+			if err != nil {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key: " + err.Error()})
+				return
+			}
+
+			// Store user and key information in the context
+			ctx.Set("userId", user.ID)
+			ctx.Set("email", user.Email)
+			ctx.Set("role", user.Role)
+			ctx.Set("apiKeyId", apiKey.ID)
+			ctx.Set("scopes", apiKey.Scopes)
+
+			ctx.Next()
+			return
+		}
+
 		// Get the Authorization header
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
@@ -40,27 +157,88 @@ func AuthMiddleware(jwtService *auth.JWTService) gin.HandlerFunc {
 			return
 		}
 
+		// Reject tokens from revoked sessions
+		if sessionService.IsRevoked(claims.SessionID) {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			return
+		}
+
+		// Take the role from the database rather than the token, so role
+		// changes and deleted users take effect before the token expires
+		user, err := userService.GetUser(claims.UserID)
+		if errors.Is(err, auth.ErrUserNotFound) && claims.Legacy {
+			// Versions before user accounts put a random user ID in the token
+			// and the username of the admin from the config file in email
+			user, err = userService.GetUserByUsername(claims.Email)
+		}
+		if err != nil {
+			if errors.Is(err, auth.ErrUserNotFound) {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User no longer exists"})
+				return
+			}
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user: " + err.Error()})
+			return
+		}
+
 		// Store user information in the context
-		ctx.Set("userId", claims.UserID)
-		ctx.Set("email", claims.Email)
-		ctx.Set("role", claims.Role)
+		ctx.Set("userId", user.ID)
+		ctx.Set("email", user.Email)
+		ctx.Set("role", user.Role)
+		ctx.Set("sessionId", claims.SessionID)
 
 		ctx.Next()
 	}
 }
 
-// SecurityMiddleware validates request security parameters (timestamp, nonce, sign)
-func SecurityMiddleware(nonceService *auth.NonceService, securityService *auth.SecurityService) gin.HandlerFunc {
+// RequireSession rejects requests that are not authenticated with a login session
+func RequireSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, ok := ctx.Get("apiKeyId"); ok {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// SecurityMiddleware validates request security parameters (timestamp, nonce, sign).
+// The X-Sign-Version header selects the signature scheme: version 2 signs the
+// canonical request including method, path and body, version 1 (the default)
+// signs the sorted parameters and is rejected while allowV1 returns false.
+// Version 2 bodies are read before authentication, so bodies larger than
+// maxBodySize bytes are rejected with 413 instead.
+func SecurityMiddleware(nonceService *auth.NonceService, securityService *auth.SecurityService, allowV1 func() bool, maxBodySize func() int) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		// Skip for some endpoints that handle their own security
 		path := ctx.Request.URL.Path
 		if strings.HasSuffix(path, "/auth/login") ||
+			strings.HasSuffix(path, "/auth/login/mfa") ||
 			strings.HasSuffix(path, "/auth/refresh") ||
 			strings.HasSuffix(path, "/auth/nonce") {
 			ctx.Next()
 			return
 		}
 
+		// Skip for API key requests, the key itself authenticates the client
+		if apiKeyFromRequest(ctx) != "" {
+			ctx.Next()
+			return
+		}
+
+		version := ctx.GetHeader("X-Sign-Version")
+		switch version {
+		case "", "1":
+			if !allowV1() {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Signature version 1 is disabled, use X-Sign-Version: 2"})
+				return
+			}
+		case "2":
+		default:
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Unsupported signature version: " + version})
+			return
+		}
+
 		// Get timestamp from query or header
 		timestamp := ctx.Query("timestamp")
 		if timestamp == "" {
@@ -106,6 +284,33 @@ func SecurityMiddleware(nonceService *auth.NonceService, securityService *auth.S
 			return
 		}
 
+		if version == "2" {
+			// Read the body for hashing and restore it for the handlers
+			var body []byte
+			if ctx.Request.Body != nil {
+				var err error
+				body, err = io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, int64(maxBodySize())))
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					ctx.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit)})
+					return
+				}
+				if err != nil {
+					ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+					return
+				}
+				ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			if err := securityService.ValidateSignatureV2(ctx.Request.Method, ctx.Request.URL.Path, ctx.Request.URL.Query(), body, timestamp, nonce, sign); err != nil {
+				ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			ctx.Next()
+			return
+		}
+
 		// Extract parameters for signature validation
 		params := make(map[string]string)
 
@@ -146,3 +351,53 @@ func SecurityMiddleware(nonceService *auth.NonceService, securityService *auth.S
 		ctx.Next()
 	}
 }
+
+// RequirePermission rejects requests whose role does not grant the given permission
+func RequirePermission(roleService *auth.RoleService, permission auth.Permission) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		role := ctx.GetString("role")
+
+		allowed, err := roleService.HasPermission(role, permission)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+			return
+		}
+
+		// API keys are further limited to their scopes
+		if scopes, ok := ctx.Get("scopes"); ok && !hasScope(scopes.([]auth.Permission), permission) {
+			allowed = false
+		}
+
+		if !allowed {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied: " + string(permission) + " required"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// apiKeyFromRequest returns the API key sent in the X-API-Key header or as a
+// bearer token with the API key prefix
+func apiKeyFromRequest(ctx *gin.Context) string {
+	if key := ctx.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+
+	parts := strings.Split(ctx.GetHeader("Authorization"), " ")
+	if len(parts) == 2 && parts[0] == "Bearer" && strings.HasPrefix(parts[1], auth.APIKeyPrefix) {
+		return parts[1]
+	}
+
+	return ""
+}
+
+// hasScope reports whether a permission is among the given scopes
+func hasScope(scopes []auth.Permission, permission auth.Permission) bool {
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store/memstore"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+const testMaxBodySize = 64
+
+// signedRequest describes a request signed as signMethod, signPath, signQuery
+// and signBody, which differ from what is sent when a test tampers with it
+type signedRequest struct {
+	method, path, query, body string
+
+	signMethod, signPath, signQuery, signBody string
+
+	version string
+}
+
+func TestSecurityMiddlewareV2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	security := auth.NewSecurityService("test-signing-key", 300)
+	nonces := auth.NewNonceService(memstore.New().Nonces, time.Minute)
+
+	newRouter := func(allowV1 bool) *gin.Engine {
+		router := gin.New()
+		router.Use(SecurityMiddleware(nonces, security, func() bool { return allowV1 }, func() int { return testMaxBodySize }))
+		router.Any("/api/v1/*path", func(ctx *gin.Context) {
+			// Handlers still see the whole body after it was hashed
+			body, _ := io.ReadAll(ctx.Request.Body)
+			ctx.String(http.StatusOK, string(body))
+		})
+		return router
+	}
+
+	tests := []struct {
+		name    string
+		req     signedRequest
+		allowV1 bool
+		want    int
+	}{
+		{"GET without body", signedRequest{method: "GET", path: "/api/v1/users"}, false, http.StatusOK},
+		{"POST with body", signedRequest{method: "POST", path: "/api/v1/roles", body: `{"name":"viewer"}`}, false, http.StatusOK},
+		{"query", signedRequest{method: "GET", path: "/api/v1/audit", query: "limit=10&action=login"}, false, http.StatusOK},
+		{"body at the limit", signedRequest{method: "POST", path: "/api/v1/import", body: strings.Repeat("x", testMaxBodySize)}, false, http.StatusOK},
+
+		{"tampered method", signedRequest{method: "DELETE", signMethod: "GET", path: "/api/v1/users/1"}, false, http.StatusBadRequest},
+		{"tampered path", signedRequest{method: "DELETE", path: "/api/v1/users/1", signPath: "/api/v1/users/2"}, false, http.StatusBadRequest},
+		{"tampered query", signedRequest{method: "GET", path: "/api/v1/audit", query: "user_id=admin", signQuery: "user_id=guest"}, false, http.StatusBadRequest},
+		{"added query", signedRequest{method: "GET", path: "/api/v1/audit", query: "limit=10&user_id=admin", signQuery: "limit=10"}, false, http.StatusBadRequest},
+		{"tampered body", signedRequest{method: "PUT", path: "/api/v1/roles/viewer", body: `{"permissions":["*"]}`, signBody: `{"permissions":[]}`}, false, http.StatusBadRequest},
+		{"dropped body", signedRequest{method: "PUT", path: "/api/v1/roles/viewer", signBody: `{"permissions":[]}`}, false, http.StatusBadRequest},
+		{"body over the limit", signedRequest{method: "POST", path: "/api/v1/import", body: strings.Repeat("x", testMaxBodySize+1)}, false, http.StatusRequestEntityTooLarge},
+		{"unknown version", signedRequest{method: "GET", path: "/api/v1/users", version: "3"}, true, http.StatusBadRequest},
+
+		{"v1 while disabled", signedRequest{method: "GET", path: "/api/v1/users", version: "1"}, false, http.StatusBadRequest},
+		{"v1 without version header while disabled", signedRequest{method: "GET", path: "/api/v1/users", version: "-"}, false, http.StatusBadRequest},
+		{"v1 while enabled", signedRequest{method: "GET", path: "/api/v1/users", version: "1"}, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newSignedRequest(t, security, nonces, tt.req)
+			w := httptest.NewRecorder()
+			newRouter(tt.allowV1).ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code == http.StatusOK && w.Body.String() != tt.req.body {
+				t.Errorf("handler body = %q, want %q", w.Body.String(), tt.req.body)
+			}
+		})
+	}
+}
+
+func TestSecurityMiddlewareRejectsReplayedNonce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	security := auth.NewSecurityService("test-signing-key", 300)
+	nonces := auth.NewNonceService(memstore.New().Nonces, time.Minute)
+	router := gin.New()
+	router.Use(SecurityMiddleware(nonces, security, func() bool { return false }, func() int { return testMaxBodySize }))
+	router.GET("/api/v1/users", func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+
+	req := newSignedRequest(t, security, nonces, signedRequest{method: "GET", path: "/api/v1/users"})
+	for i, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req.Clone(req.Context()))
+		if w.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
+
+// newSignedRequest builds a request with a fresh nonce, signed over the sign*
+// fields of r where set and over the sent values otherwise
+func newSignedRequest(t *testing.T, security *auth.SecurityService, nonces *auth.NonceService, r signedRequest) *http.Request {
+	t.Helper()
+
+	nonce, err := nonces.GenerateNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	signMethod, signPath, signQuery, signBody := r.method, r.path, r.query, r.body
+	if r.signMethod != "" {
+		signMethod = r.signMethod
+	}
+	if r.signPath != "" {
+		signPath = r.signPath
+	}
+	if r.signQuery != "" {
+		signQuery = r.signQuery
+	}
+	if r.signBody != "" {
+		signBody = r.signBody
+	}
+
+	target := r.path
+	if r.query != "" {
+		target += "?" + r.query
+	}
+	req := httptest.NewRequest(r.method, target, strings.NewReader(r.body))
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Nonce", nonce)
+
+	switch r.version {
+	case "", "2":
+		query, err := url.ParseQuery(signQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Sign-Version", "2")
+		req.Header.Set("X-Sign", security.GenerateSignatureV2(signMethod, signPath, query, []byte(signBody), ts, nonce))
+	default:
+		// Version 1 signs the query parameters and the timestamp and nonce
+		params := map[string]string{"timestamp": ts, "nonce": nonce}
+		query, _ := url.ParseQuery(signQuery)
+		for k, v := range query {
+			params[k] = v[0]
+		}
+		if r.version != "-" {
+			req.Header.Set("X-Sign-Version", r.version)
+		}
+		req.Header.Set("X-Sign", security.GenerateSignature(params))
+	}
+	return req
+}
+
+// TestAuthMiddlewareAcceptsBaselineToken upgrades a database created before
+// versioned migrations and sends a token issued by that version, which names
+// the admin from the config file only by username
+func TestAuthMiddlewareAcceptsBaselineToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	services := newTestAuthServices(t, true)
+	if err := services.users.SeedAdmin("admin", "admin-password"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := services.users.GetUserByUsername("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(services.authMiddleware())
+	router.GET("/api/v1/me", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString("userId"))
+	})
+
+	tests := []struct {
+		name     string
+		username string
+		want     int
+	}{
+		{"admin from the config file", "admin", http.StatusOK},
+		{"unknown username", "someone", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.CustomClaims{
+				UserID: uuid.New().String(),
+				Email:  tt.username,
+				Role:   auth.RoleAdmin,
+				Type:   auth.AccessToken,
+				RegisteredClaims: jwt.RegisteredClaims{
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				},
+			}).SignedString([]byte(testLegacySecretKey))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code == http.StatusOK && w.Body.String() != admin.ID {
+				t.Errorf("userId = %q, want %q", w.Body.String(), admin.ID)
+			}
+		})
+	}
+}
+
+// TestAPIKeyPermissions checks that API keys are accepted without a request
+// signature and are limited to both their scopes and the role of their user
+func TestAPIKeyPermissions(t *testing.T) {
+	services := newTestAuthServices(t, false)
+
+	admin, err := services.users.CreateUser("alice", "alice-password", "", auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, readKey, err := services.apiKeys.CreateAPIKey(admin.ID, "read users", []auth.Permission{auth.PermUsersRead}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key keeps its scopes when its user is demoted, the role still limits it
+	demoted, err := services.users.CreateUser("bob", "bob-password", "", auth.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, demotedKey, err := services.apiKeys.CreateAPIKey(demoted.ID, "write users", []auth.Permission{auth.PermUsersRead, auth.PermUsersWrite}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.users.UpdateUser(demoted.ID, "", auth.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	security := auth.NewSecurityService("test-signing-key", 300)
+	nonces := auth.NewNonceService(memstore.New().Nonces, time.Minute)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SecurityMiddleware(nonces, security, func() bool { return false }, func() int { return testMaxBodySize }))
+	router.Use(services.authMiddleware())
+	ok := func(ctx *gin.Context) { ctx.Status(http.StatusOK) }
+	router.GET("/api/v1/users", RequirePermission(services.roles, auth.PermUsersRead), ok)
+	router.POST("/api/v1/users", RequirePermission(services.roles, auth.PermUsersWrite), ok)
+	router.GET("/api/v1/roles", RequirePermission(services.roles, auth.PermRolesRead), ok)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		header, value string
+		want          int
+	}{
+		{"X-API-Key header", http.MethodGet, "/api/v1/users", "X-API-Key", readKey, http.StatusOK},
+		{"bearer key with the hami_ prefix", http.MethodGet, "/api/v1/users", "Authorization", "Bearer " + readKey, http.StatusOK},
+		{"permission of the role outside the scopes", http.MethodGet, "/api/v1/roles", "X-API-Key", readKey, http.StatusForbidden},
+		{"scope outside the role", http.MethodPost, "/api/v1/users", "X-API-Key", demotedKey, http.StatusForbidden},
+		{"scope outside the role as bearer", http.MethodPost, "/api/v1/users", "Authorization", "Bearer " + demotedKey, http.StatusForbidden},
+		{"unknown key with the prefix", http.MethodGet, "/api/v1/users", "Authorization", "Bearer " + auth.APIKeyPrefix + "unknown", http.StatusUnauthorized},
+		{"bearer key without the prefix needs a signature", http.MethodGet, "/api/v1/users", "Authorization", "Bearer " + strings.TrimPrefix(readKey, auth.APIKeyPrefix), http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(tt.header, tt.value)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+const testLegacySecretKey = "legacy-secret-key-from-config"
+
+// testAuthServices holds the services behind AuthMiddleware
+type testAuthServices struct {
+	users    *auth.UserService
+	roles    *auth.RoleService
+	apiKeys  *auth.APIKeyService
+	jwt      *auth.JWTService
+	sessions *auth.SessionService
+}
+
+// newTestAuthServices opens a migrated pure-Go SQLite database in a temporary
+// directory, created by a version before versioned migrations when baseline is set
+func newTestAuthServices(t *testing.T, baseline bool) *testAuthServices {
+	t.Helper()
+
+	database, err := db.New(db.DriverPureGo, filepath.Join(t.TempDir(), "ha-mi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	// The nonces table is what versions before migrations left behind
+	if baseline {
+		if _, err := database.Exec("CREATE TABLE nonces (nonce TEXT PRIMARY KEY, expires_at INTEGER NOT NULL)"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := auth.NewKeyring(database.DB, auth.AlgorithmHS256, "", time.Hour)
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := auth.NewUserService(sqlstore.New(database.DB).Users)
+	jwtService := auth.NewJWTService(keyring, testLegacySecretKey, time.Hour, 24*time.Hour)
+	return &testAuthServices{
+		users:    users,
+		roles:    auth.NewRoleService(database.DB, sqlstore.New(database.DB).Users),
+		apiKeys:  auth.NewAPIKeyService(database.DB, users),
+		jwt:      jwtService,
+		sessions: auth.NewSessionService(database.DB, jwtService, users),
+	}
+}
+
+func (s *testAuthServices) authMiddleware() gin.HandlerFunc {
+	return AuthMiddleware(s.jwt, s.sessions, s.apiKeys, s.users)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,74 +12,206 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/boringsoft/ha-mi/internal/audit"
 	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/backup"
+	"github.com/boringsoft/ha-mi/internal/catalog"
 	"github.com/boringsoft/ha-mi/internal/config"
 	"github.com/boringsoft/ha-mi/internal/controllers"
 	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/logging"
+	"github.com/boringsoft/ha-mi/internal/metrics"
+	"github.com/boringsoft/ha-mi/internal/store"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
 )
 
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 2 * time.Second
+
 // Server represents the API server
 type Server struct {
-	config          *config.Config
+	configManager   *config.Manager
 	router          *gin.Engine
 	httpServer      *http.Server
+	keyring         *auth.Keyring
 	jwtService      *auth.JWTService
 	nonceService    *auth.NonceService
 	securityService *auth.SecurityService
+	userService     *auth.UserService
+	roleService     *auth.RoleService
+	sessionService  *auth.SessionService
+	apiKeyService   *auth.APIKeyService
+	mfaService      *auth.MFAService
+	loginLimiter    *auth.LoginLimiter
+	auditLogger     *audit.Logger
+	backupManager   *backup.Manager
+	catalogService  *catalog.Service
 	database        *db.DB
+	store           *store.Store
+	stopWatch       chan struct{}
 }
 
-// NewServer creates a new API server
-func NewServer(cfg *config.Config, database *db.DB) *Server {
+// NewServer creates a new API server. Settings read on each use or applied
+// by applyConfig follow reloads of the config file, the rest need a restart.
+func NewServer(configManager *config.Manager, database *db.DB) *Server {
+	cfg := configManager.Current()
+
+	// Create repositories
+	repositories := sqlstore.New(database.DB)
+
 	// Create services
+	keyring := auth.NewKeyring(database.DB, cfg.Auth.JWTAlgorithm, cfg.Auth.JWTKeyFile, cfg.Auth.RefreshTokenExpiry.Duration())
 	jwtService := auth.NewJWTService(
+		keyring,
 		cfg.Auth.SecretKey,
-		cfg.Auth.AccessTokenExpiry,
-		cfg.Auth.RefreshTokenExpiry,
+		cfg.Auth.AccessTokenExpiry.Duration(),
+		cfg.Auth.RefreshTokenExpiry.Duration(),
 	)
-	nonceService := auth.NewNonceService(database.DB, cfg.Auth.NonceExpiry)
-	securityService := auth.NewSecurityService(cfg.Auth.SecretKey, 60) // 60 seconds max diff
+	nonceService := auth.NewNonceService(repositories.Nonces, cfg.Auth.NonceExpiry.Duration())
+	securityService := auth.NewSecurityService(cfg.Auth.SigningKey(), 60) // 60 seconds max diff
+	userService := auth.NewUserService(repositories.Users)
+	roleService := auth.NewRoleService(database.DB, repositories.Users)
+	sessionService := auth.NewSessionService(database.DB, jwtService, userService)
+	apiKeyService := auth.NewAPIKeyService(database.DB, userService)
+	mfaService := auth.NewMFAService(database.DB)
+	loginLimiter := auth.NewLoginLimiter(database.DB, lockoutPolicy(cfg.Auth.Lockout))
+	auditLogger := audit.NewLogger(database.DB)
+	backupManager := backup.NewManager(database, cfg.Database.Backup.Dir, cfg.Database.Backup.Retention)
+	catalogService := catalog.NewService(repositories)
 
 	// Create server
 	server := &Server{
-		config:          cfg,
+		configManager:   configManager,
+		keyring:         keyring,
 		jwtService:      jwtService,
 		nonceService:    nonceService,
 		securityService: securityService,
+		userService:     userService,
+		roleService:     roleService,
+		sessionService:  sessionService,
+		apiKeyService:   apiKeyService,
+		mfaService:      mfaService,
+		loginLimiter:    loginLimiter,
+		auditLogger:     auditLogger,
+		backupManager:   backupManager,
+		catalogService:  catalogService,
 		database:        database,
+		store:           repositories,
 	}
 
 	// Initialize router
 	server.setupRouter()
 
+	// Follow config reloads
+	configManager.Subscribe(server.applyConfig)
+
 	return server
 }
 
+// applyConfig applies a reloaded configuration to the running services
+func (s *Server) applyConfig(old, cfg *config.Config) {
+	if err := logging.SetLevel(cfg.Log.Level); err != nil {
+		slog.Error("Error changing log level", "error", err)
+	}
+	s.jwtService.Reconfigure(cfg.Auth.SecretKey, cfg.Auth.AccessTokenExpiry.Duration(), cfg.Auth.RefreshTokenExpiry.Duration())
+	s.sessionService.SetAccessTokenExpiry(cfg.Auth.AccessTokenExpiry.Duration())
+	s.keyring.SetRetention(cfg.Auth.RefreshTokenExpiry.Duration())
+	s.nonceService.SetExpiry(cfg.Auth.NonceExpiry.Duration())
+	s.securityService.SetSecretKey(cfg.Auth.SigningKey())
+	s.loginLimiter.SetPolicy(lockoutPolicy(cfg.Auth.Lockout))
+
+	if old.Database.Backup != cfg.Database.Backup {
+		backup := cfg.Database.Backup
+		s.backupManager.Reconfigure(backup.Dir, backup.Retention, backup.Interval.Duration())
+	}
+}
+
+// lockoutPolicy converts the lockout configuration
+func lockoutPolicy(cfg config.LockoutConfig) auth.LockoutPolicy {
+	return auth.LockoutPolicy{
+		MaxFailures:  cfg.MaxFailures,
+		Window:       cfg.Window.Duration(),
+		BaseDuration: cfg.BaseDuration.Duration(),
+		MaxDuration:  cfg.MaxDuration.Duration(),
+	}
+}
+
 // setupRouter sets up the HTTP router
 func (s *Server) setupRouter() {
+	// Gin prints its routes and mode warnings in debug mode only
+	if logging.Level() > slog.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
 	// Create router
-	router := gin.Default()
+	router := gin.New()
 
-	// Add middleware
-	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
+	// Add middleware, the request ID first so everything after it can log with it
+	router.Use(RequestIDMiddleware())
+	router.Use(AccessLogMiddleware())
+	router.Use(MetricsMiddleware())
+	router.Use(RecoveryMiddleware())
 	router.Use(corsMiddleware())
 
 	// API routes
 	apiGroup := router.Group("/api/v1")
 
 	// Add security middleware (except for certain routes)
-	apiGroup.Use(SecurityMiddleware(s.nonceService, s.securityService))
+	apiGroup.Use(SecurityMiddleware(s.nonceService, s.securityService, func() bool {
+		return s.configManager.Current().Auth.AllowSignV1
+	}, func() int {
+		return s.configManager.Current().Server.MaxBodySize
+	}))
 
 	// Create controllers
-	authController := controllers.NewAuthController(s.jwtService, s.nonceService, s.securityService, s.config)
+	authController := controllers.NewAuthController(s.jwtService, s.nonceService, s.securityService, s.userService, s.sessionService, s.mfaService, s.loginLimiter, s.auditLogger)
+	userController := controllers.NewUserController(s.userService, s.roleService, s.sessionService, s.mfaService)
+	roleController := controllers.NewRoleController(s.roleService)
+	apiKeyController := controllers.NewAPIKeyController(s.apiKeyService, s.roleService)
+	adminController := controllers.NewAdminController(s.keyring, s.loginLimiter, s.backupManager, s.auditLogger)
+	auditController := controllers.NewAuditController(s.auditLogger)
+	catalogController := controllers.NewCatalogController(s.catalogService, s.auditLogger)
 
 	// Register auth routes (no auth middleware needed)
 	authController.RegisterRoutes(apiGroup)
 
 	// Protected routes (with auth middleware)
 	protectedGroup := apiGroup.Group("")
-	protectedGroup.Use(AuthMiddleware(s.jwtService))
+	protectedGroup.Use(AuthMiddleware(s.jwtService, s.sessionService, s.apiKeyService, s.userService))
+
+	// Register session and API key routes (not usable with API keys)
+	sessionGroup := protectedGroup.Group("")
+	sessionGroup.Use(RequireSession())
+	authController.RegisterProtectedRoutes(sessionGroup)
+	apiKeyController.RegisterRoutes(sessionGroup)
+
+	// Register user and role management routes
+	userController.RegisterRoutes(protectedGroup, s.requirePermission)
+	roleController.RegisterRoutes(protectedGroup, s.requirePermission)
+
+	// Register admin and audit routes
+	adminController.RegisterRoutes(protectedGroup, s.requirePermission)
+	auditController.RegisterRoutes(protectedGroup, s.requirePermission)
+
+	// Register configuration export and import routes
+	catalogController.RegisterRoutes(protectedGroup, s.requirePermission)
+
+	// Publish the public JWT verification keys
+	router.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"keys": s.keyring.JWKS()})
+	})
+
+	// Publish the metrics for Prometheus, enabled or not on every scrape so the setting reloads
+	router.GET("/metrics", func(c *gin.Context) {
+		if !s.configManager.Current().Metrics.Enabled {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Content-Type", metrics.ContentType)
+		if err := metrics.WriteText(c.Writer); err != nil {
+			slog.Error("Error writing metrics", "error", err)
+		}
+	})
 
 	// Add health check endpoint
 	router.GET("/health", func(c *gin.Context) {
@@ -91,23 +224,49 @@ func (s *Server) setupRouter() {
 	s.router = router
 }
 
+// requirePermission returns a middleware that checks the given permission
+func (s *Server) requirePermission(permission auth.Permission) gin.HandlerFunc {
+	return RequirePermission(s.roleService, permission)
+}
+
 // Start starts the API server
 func (s *Server) Start() error {
+	cfg := s.configManager.Current()
+
+	// Load JWT signing keys
+	if err := s.keyring.Load(); err != nil {
+		return fmt.Errorf("error loading signing keys: %w", err)
+	}
+
+	// Restore the revoked session denylist
+	if err := s.sessionService.LoadRevokedSessions(); err != nil {
+		return fmt.Errorf("error loading revoked sessions: %w", err)
+	}
+
+	// Start the scheduled database snapshots
+	if cfg.Database.Backup.Interval > 0 {
+		s.backupManager.Start(cfg.Database.Backup.Interval.Duration())
+	}
+
+	// Reload the config file when it changes
+	s.stopWatch = make(chan struct{})
+	go s.configManager.Watch(configWatchInterval, s.stopWatch)
+
 	// Create HTTP server
 	s.httpServer = &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port),
+		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
 		Handler: s.router,
 	}
 
 	// Start server in a goroutine
 	go func() {
 		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("HTTP server error: %s\n", err)
+			slog.Error("HTTP server error", "error", err)
 			os.Exit(1)
 		}
 	}()
 
-	fmt.Printf("Server started on %s:%d\n", s.config.Server.Host, s.config.Server.Port)
+	slog.Info("Server started", "addr", s.httpServer.Addr, "mode", cfg.Server.Mode)
 
 	return nil
 }
@@ -123,6 +282,10 @@ func (s *Server) Shutdown(timeout time.Duration) error {
 		return fmt.Errorf("error shutting down HTTP server: %w", err)
 	}
 
+	// Stop the config watcher and the scheduled snapshots before the database goes away
+	close(s.stopWatch)
+	s.backupManager.Stop()
+
 	// Close database connection
 	if err := s.database.Close(); err != nil {
 		return fmt.Errorf("error closing database connection: %w", err)
@@ -131,21 +294,33 @@ func (s *Server) Shutdown(timeout time.Duration) error {
 	return nil
 }
 
-// WaitForShutdown waits for shutdown signal
+// WaitForShutdown waits for shutdown signal, reloading the config file on SIGHUP
 func (s *Server) WaitForShutdown() {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
 
-	fmt.Println("Shutting down server...")
+wait:
+	for {
+		select {
+		case <-reload:
+			s.configManager.ReloadAndReport()
+		case <-quit:
+			break wait
+		}
+	}
+
+	slog.Info("Shutting down server")
 
 	// Shutdown with 5s timeout
 	if err := s.Shutdown(5 * time.Second); err != nil {
-		fmt.Printf("Error during shutdown: %s\n", err)
+		slog.Error("Error during shutdown", "error", err)
 	}
 
-	fmt.Println("Server gracefully stopped")
+	slog.Info("Server gracefully stopped")
 }
 
 // corsMiddleware handles CORS
@@ -153,8 +328,8 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Timestamp, X-Nonce, X-Sign")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Timestamp, X-Nonce, X-Sign, X-Sign-Version, X-API-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Retry-After")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
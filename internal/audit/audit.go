@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Audit actions
+const (
+	ActionLoginSuccess   = "login.success"
+	ActionLoginFailure   = "login.failure"
+	ActionLoginLocked    = "login.locked"
+	ActionLockoutCleared = "lockout.cleared"
+	ActionMFAFailure     = "mfa.failure"
+	ActionMFAEnabled     = "mfa.enabled"
+	ActionMFADisabled    = "mfa.disabled"
+	ActionBackupCreated  = "backup.created"
+	ActionConfigImported = "config.imported"
+)
+
+// Entry represents a single audit log entry
+type Entry struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Action    string `json:"action"`
+	Detail    string `json:"detail"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Filter selects audit log entries
+type Filter struct {
+	AfterID int64
+	Action  string
+	UserID  string
+	Limit   int
+}
+
+// Logger records security relevant events in the audit log
+type Logger struct {
+	db *sql.DB
+}
+
+// NewLogger creates a new Logger
+func NewLogger(db *sql.DB) *Logger {
+	return &Logger{
+		db: db,
+	}
+}
+
+// Record stores an audit log entry
+func (l *Logger) Record(entry Entry) error {
+	if entry.CreatedAt == 0 {
+		entry.CreatedAt = time.Now().Unix()
+	}
+
+	_, err := l.db.Exec(
+		"INSERT INTO audit_logs (user_id, username, action, detail, ip, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		entry.UserID, entry.Username, entry.Action, entry.Detail, entry.IP, entry.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store audit log entry: %w", err)
+	}
+
+	return nil
+}
+
+// List returns audit log entries matching the filter, oldest first
+func (l *Logger) List(filter Filter) ([]*Entry, error) {
+	if filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 100
+	}
+
+	query := "SELECT id, user_id, username, action, detail, ip, created_at FROM audit_logs WHERE id > ?"
+	args := []interface{}{filter.AfterID}
+	if filter.Action != "" {
+		query += " AND action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.UserID != "" {
+		query += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+
+	// Without a cursor return the most recent entries
+	if filter.AfterID == 0 {
+		query = "SELECT * FROM (" + query + " ORDER BY id DESC LIMIT ?) ORDER BY id"
+	} else {
+		query += " ORDER BY id LIMIT ?"
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := l.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*Entry{}
+	for rows.Next() {
+		entry := &Entry{}
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Username, &entry.Action, &entry.Detail, &entry.IP, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning audit log entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
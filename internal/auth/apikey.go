@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyPrefix is prepended to every generated API key
+const APIKeyPrefix = "hami_"
+
+// API key errors
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyInvalid  = errors.New("invalid api key")
+	ErrAPIKeyExpired  = errors.New("api key expired")
+)
+
+// APIKey represents a long-lived credential for machine clients
+type APIKey struct {
+	ID         string       `json:"id"`
+	UserID     string       `json:"user_id"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	Scopes     []Permission `json:"scopes"`
+	ExpiresAt  int64        `json:"expires_at,omitempty"`
+	LastUsedAt int64        `json:"last_used_at,omitempty"`
+	CreatedAt  int64        `json:"created_at"`
+}
+
+// APIKeyService handles API key operations. Only a SHA-256 hash of each key
+// is stored; the plaintext is returned once when the key is created.
+type APIKeyService struct {
+	db          *sql.DB
+	userService *UserService
+}
+
+// NewAPIKeyService creates a new APIKeyService
+func NewAPIKeyService(db *sql.DB, userService *UserService) *APIKeyService {
+	return &APIKeyService{
+		db:          db,
+		userService: userService,
+	}
+}
+
+// CreateAPIKey creates a new API key and returns it together with its plaintext.
+// A zero expiresAt creates a key that never expires.
+func (s *APIKeyService) CreateAPIKey(userID, name string, scopes []Permission, expiresAt time.Time) (*APIKey, string, error) {
+	encodedScopes, err := encodePermissions(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Generate random bytes
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	plaintext := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(bytes)
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plaintext[:len(APIKeyPrefix)+8],
+		Scopes:    scopes,
+		CreatedAt: time.Now().Unix(),
+	}
+	if !expiresAt.IsZero() {
+		key.ExpiresAt = expiresAt.Unix()
+	}
+
+	// Store in database
+	_, err = s.db.Exec(
+		"INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.UserID, key.Name, key.Prefix, hashAPIKey(plaintext), encodedScopes, nullableUnix(key.ExpiresAt), key.CreatedAt,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to store api key: %w", err)
+	}
+
+	return key, plaintext, nil
+}
+
+// ListAPIKeys returns the API keys of a user
+func (s *APIKeyService) ListAPIKeys(userID string) ([]*APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// DeleteAPIKey deletes an API key owned by a user
+func (s *APIKeyService) DeleteAPIKey(userID, id string) error {
+	result, err := s.db.Exec("DELETE FROM api_keys WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// Authenticate validates a plaintext API key and returns it with its owner
+func (s *APIKeyService) Authenticate(plaintext string) (*APIKey, *User, error) {
+	if !strings.HasPrefix(plaintext, APIKeyPrefix) {
+		return nil, nil, ErrAPIKeyInvalid
+	}
+
+	row := s.db.QueryRow(`
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys WHERE key_hash = ?
+	`, hashAPIKey(plaintext))
+	key, err := scanAPIKey(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, err
+	}
+
+	// Check if expired
+	now := time.Now().Unix()
+	if key.ExpiresAt != 0 && now > key.ExpiresAt {
+		return nil, nil, ErrAPIKeyExpired
+	}
+
+	// Load the owner so the key follows role changes
+	user, err := s.userService.GetUser(key.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Record usage
+	if _, err := s.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.ID); err != nil {
+		return nil, nil, fmt.Errorf("error updating api key usage: %w", err)
+	}
+	key.LastUsedAt = now
+
+	return key, user, nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey scans an API key row
+func scanAPIKey(row scanner) (*APIKey, error) {
+	key := &APIKey{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullInt64
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &expiresAt, &lastUsedAt, &key.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error scanning api key: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(scopes), &key.Scopes); err != nil {
+		return nil, fmt.Errorf("error decoding api key scopes: %w", err)
+	}
+	key.ExpiresAt = expiresAt.Int64
+	key.LastUsedAt = lastUsedAt.Int64
+
+	return key, nil
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 hash of an API key
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// nullableUnix maps a zero timestamp to NULL
+func nullableUnix(ts int64) interface{} {
+	if ts == 0 {
+		return nil
+	}
+	return ts
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist is an in-memory set of revoked session IDs. An entry only needs to
+// live as long as an access token issued from that session could be valid.
+type Denylist struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+	ttl     time.Duration
+}
+
+// NewDenylist creates a new Denylist whose entries expire after ttl
+func NewDenylist(ttl time.Duration) *Denylist {
+	return &Denylist{
+		entries: make(map[string]time.Time),
+		ttl:     ttl,
+	}
+}
+
+// SetTTL replaces how long new entries are kept
+func (d *Denylist) SetTTL(ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ttl = ttl
+}
+
+// Add adds a session ID revoked at the given time
+func (d *Denylist) Add(sessionID string, revokedAt time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expiresAt := revokedAt.Add(d.ttl)
+	if time.Now().After(expiresAt) {
+		return
+	}
+
+	d.entries[sessionID] = expiresAt
+	d.prune()
+}
+
+// Contains reports whether a session ID is revoked
+func (d *Denylist) Contains(sessionID string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	expiresAt, ok := d.entries[sessionID]
+	return ok && time.Now().Before(expiresAt)
+}
+
+// prune drops expired entries; the caller must hold the write lock
+func (d *Denylist) prune() {
+	now := time.Now()
+	for id, expiresAt := range d.entries {
+		if now.After(expiresAt) {
+			delete(d.entries, id)
+		}
+	}
+}
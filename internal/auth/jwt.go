@@ -3,9 +3,11 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // TokenType represents the type of token
@@ -15,59 +17,143 @@ type TokenType string
 const (
 	AccessToken  TokenType = "access"
 	RefreshToken TokenType = "refresh"
+	MFAToken     TokenType = "mfa"
 )
 
+// ErrLegacyToken is returned for tokens without a key ID once they are no longer accepted
+var ErrLegacyToken = errors.New("tokens without a key ID are no longer accepted")
+
+// mfaTokenExpiry is how long a user has to enter the second factor after the password
+const mfaTokenExpiry = 5 * time.Minute
+
 // CustomClaims represents the JWT token claims
 type CustomClaims struct {
-	UserID string    `json:"userId"`
-	Email  string    `json:"email"`
-	Role   string    `json:"role"`
-	Type   TokenType `json:"type"`
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	Type      TokenType `json:"type"`
+	SessionID string    `json:"sid,omitempty"`
+	// Legacy is set by ValidateToken for tokens signed before the keyring
+	Legacy bool `json:"-"`
 	jwt.RegisteredClaims
 }
 
+// TokenPair holds an access token and the refresh token issued with it
+type TokenPair struct {
+	AccessToken      string
+	RefreshToken     string
+	RefreshID        string
+	RefreshExpiresAt time.Time
+}
+
 // JWTService handles JWT operations
 type JWTService struct {
-	secretKey          string
+	keyring *Keyring
+
+	// mu guards the settings that can change on config reload
+	mu                 sync.RWMutex
+	legacySecretKey    string
 	accessTokenExpiry  time.Duration
 	refreshTokenExpiry time.Duration
 }
 
-// NewJWTService creates a new JWTService
-func NewJWTService(secretKey string, accessExpiry, refreshExpiry time.Duration) *JWTService {
+// NewJWTService creates a new JWTService. Tokens are signed with the active
+// keyring key. Access tokens without a kid header were signed before key
+// rotation existed; they are verified with legacySecretKey, and only for one
+// access token lifetime after the upgrade, by when every genuine one has expired.
+func NewJWTService(keyring *Keyring, legacySecretKey string, accessExpiry, refreshExpiry time.Duration) *JWTService {
 	return &JWTService{
-		secretKey:          secretKey,
+		keyring:            keyring,
+		legacySecretKey:    legacySecretKey,
 		accessTokenExpiry:  accessExpiry,
 		refreshTokenExpiry: refreshExpiry,
 	}
 }
 
-// GenerateTokens generates an access token and a refresh token
-func (s *JWTService) GenerateTokens(userID, email, role string) (accessToken, refreshToken string, err error) {
+// GenerateTokens generates an access token and a refresh token for a session
+func (s *JWTService) GenerateTokens(userID, email, role, sessionID string) (*TokenPair, error) {
 	// Generate access token
-	accessToken, err = s.generateToken(userID, email, role, AccessToken, s.accessTokenExpiry)
+	s.mu.RLock()
+	accessExpiry, refreshExpiry := s.accessTokenExpiry, s.refreshTokenExpiry
+	s.mu.RUnlock()
+
+	accessToken, _, err := s.generateToken(userID, email, role, sessionID, AccessToken, accessExpiry)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate access token: %w", err)
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
 	// Generate refresh token
-	refreshToken, err = s.generateToken(userID, email, role, RefreshToken, s.refreshTokenExpiry)
+	refreshToken, refreshClaims, err := s.generateToken(userID, email, role, sessionID, RefreshToken, refreshExpiry)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
-	return accessToken, refreshToken, nil
+	return &TokenPair{
+		AccessToken:      accessToken,
+		RefreshToken:     refreshToken,
+		RefreshID:        refreshClaims.ID,
+		RefreshExpiresAt: refreshClaims.ExpiresAt.Time,
+	}, nil
+}
+
+// GenerateMFAToken generates a short-lived token proving the password step of a
+// two-factor login succeeded. It is only accepted by the second login step.
+func (s *JWTService) GenerateMFAToken(user *User) (string, error) {
+	token, _, err := s.generateToken(user.ID, user.Email, user.Role, "", MFAToken, mfaTokenExpiry)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+	return token, nil
+}
+
+// AccessTokenExpiry returns the lifetime of access tokens
+func (s *JWTService) AccessTokenExpiry() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.accessTokenExpiry
+}
+
+// Reconfigure replaces the legacy secret key and the token lifetimes. Tokens
+// already issued keep the lifetime they were signed with.
+func (s *JWTService) Reconfigure(legacySecretKey string, accessExpiry, refreshExpiry time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.legacySecretKey = legacySecretKey
+	s.accessTokenExpiry = accessExpiry
+	s.refreshTokenExpiry = refreshExpiry
 }
 
 // ValidateToken validates a JWT token and returns its claims
 func (s *JWTService) ValidateToken(tokenString string) (*CustomClaims, error) {
+	legacy := false
+
 	// Parse the token
 	token, err := jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Tokens without a key ID predate the keyring
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			if !s.acceptsLegacyTokens(time.Now()) {
+				return nil, ErrLegacyToken
+			}
+			legacy = true
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return []byte(s.legacySecretKey), nil
+		}
+
+		// Look up the key by its ID and validate signing method
+		key, ok := s.keyring.Key(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %s", kid)
+		}
+		if token.Method.Alg() != key.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(s.secretKey), nil
+		return key.verifyingKey, nil
 	})
 
 	if err != nil {
@@ -80,46 +166,61 @@ func (s *JWTService) ValidateToken(tokenString string) (*CustomClaims, error) {
 		return nil, errors.New("invalid token claims")
 	}
 
+	// Legacy tokens have no session and cannot be revoked, so they are not
+	// accepted to refresh or to finish a login
+	if legacy && claims.Type != AccessToken {
+		return nil, fmt.Errorf("invalid token: %w", ErrLegacyToken)
+	}
+	claims.Legacy = legacy
+
 	return claims, nil
 }
 
-// RefreshTokens generates new tokens from a valid refresh token
-func (s *JWTService) RefreshTokens(refreshToken string) (accessToken, newRefreshToken string, err error) {
-	// Validate the refresh token
-	claims, err := s.ValidateToken(refreshToken)
-	if err != nil {
-		return "", "", fmt.Errorf("invalid refresh token: %w", err)
-	}
-
-	// Check if it's actually a refresh token
-	if claims.Type != RefreshToken {
-		return "", "", errors.New("token is not a refresh token")
+// acceptsLegacyTokens reports whether tokens without a kid are still accepted
+func (s *JWTService) acceptsLegacyTokens(now time.Time) bool {
+	since := s.keyring.LegacySince()
+	if since.IsZero() {
+		return false
 	}
 
-	// Generate new tokens
-	return s.GenerateTokens(claims.UserID, claims.Email, claims.Role)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return now.Before(since.Add(s.accessTokenExpiry))
 }
 
-// generateToken generates a JWT token
-func (s *JWTService) generateToken(userID, email, role string, tokenType TokenType, expiry time.Duration) (string, error) {
+// generateToken generates a JWT token with a unique ID
+func (s *JWTService) generateToken(userID, email, role, sessionID string, tokenType TokenType, expiry time.Duration) (string, *CustomClaims, error) {
 	// Create claims
+	now := time.Now()
 	claims := &CustomClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		Type:   tokenType,
+		UserID:    userID,
+		Email:     email,
+		Role:      role,
+		Type:      tokenType,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "ha-mi",
 			Subject:   userID,
 		},
 	}
 
 	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	key, err := s.keyring.Active()
+	if err != nil {
+		return "", nil, err
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
 
 	// Sign token
-	return token.SignedString([]byte(s.secretKey))
+	signed, err := token.SignedString(key.signingKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signed, claims, nil
 }
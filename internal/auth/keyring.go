@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keyring errors
+var (
+	ErrNoSigningKey   = errors.New("no active signing key")
+	ErrKeyFileManaged = errors.New("signing key is loaded from a file and cannot be rotated here")
+)
+
+// SigningKey is a JWT signing key identified by its key ID (kid)
+type SigningKey struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	CreatedAt int64  `json:"created_at"`
+	RetiredAt int64  `json:"retired_at,omitempty"`
+	*keyMaterial
+}
+
+// Keyring holds the active JWT signing key and the retired keys that are
+// still accepted for verification. Retired keys are kept for the given
+// retention so tokens signed before a rotation stay valid until they expire.
+//
+// Keys are generated with the configured algorithm and stored in the
+// database, unless keyFile points to a PEM private key on disk, in which
+// case that key is used for signing.
+type Keyring struct {
+	db        *sql.DB
+	algorithm string
+	keyFile   string
+	retention time.Duration
+
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	active *SigningKey
+	// legacySince is when the database was upgraded to the keyring
+	legacySince time.Time
+}
+
+// NewKeyring creates a new Keyring
+func NewKeyring(db *sql.DB, algorithm, keyFile string, retention time.Duration) *Keyring {
+	if algorithm == "" {
+		algorithm = AlgorithmHS256
+	}
+
+	return &Keyring{
+		db:        db,
+		algorithm: algorithm,
+		keyFile:   keyFile,
+		retention: retention,
+		keys:      make(map[string]*SigningKey),
+	}
+}
+
+// Load reads the keys from the database and the key file. A new key is
+// created when there is no active key or the configured algorithm changed.
+func (k *Keyring) Load() error {
+	rows, err := k.db.Query("SELECT kid, algorithm, secret, created_at, retired_at FROM signing_keys ORDER BY created_at")
+	if err != nil {
+		return fmt.Errorf("error querying signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*SigningKey)
+	var active *SigningKey
+	for rows.Next() {
+		key := &SigningKey{}
+		var secret string
+		var retiredAt sql.NullInt64
+		if err := rows.Scan(&key.ID, &key.Algorithm, &secret, &key.CreatedAt, &retiredAt); err != nil {
+			return fmt.Errorf("error scanning signing key: %w", err)
+		}
+
+		if key.keyMaterial, err = decodeKeyMaterial(key.Algorithm, secret); err != nil {
+			return fmt.Errorf("error decoding signing key %s: %w", key.ID, err)
+		}
+		key.RetiredAt = retiredAt.Int64
+
+		keys[key.ID] = key
+		if !retiredAt.Valid {
+			active = key
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying signing keys: %w", err)
+	}
+
+	// A key file takes precedence over the stored keys
+	if k.keyFile != "" {
+		material, algorithm, err := loadKeyFile(k.keyFile)
+		if err != nil {
+			return err
+		}
+
+		kid, err := publicKeyID(material.verifyingKey)
+		if err != nil {
+			return err
+		}
+
+		// The stored key no longer signs, retire it so it expires like a rotated one
+		if active != nil {
+			now := time.Now().Unix()
+			if _, err := k.db.Exec("UPDATE signing_keys SET retired_at = ? WHERE retired_at IS NULL", now); err != nil {
+				return fmt.Errorf("failed to retire signing key: %w", err)
+			}
+			retired := *active
+			retired.RetiredAt = now
+			keys[retired.ID] = &retired
+		}
+
+		active = &SigningKey{
+			ID:          kid,
+			Algorithm:   algorithm,
+			CreatedAt:   time.Now().Unix(),
+			keyMaterial: material,
+		}
+		keys[kid] = active
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.active = active
+	k.mu.Unlock()
+
+	if k.keyFile == "" && (active == nil || active.Algorithm != k.algorithm) {
+		if _, err := k.Rotate(); err != nil {
+			return err
+		}
+	}
+
+	return k.loadLegacySince()
+}
+
+// loadLegacySince reads when the database was upgraded to the keyring
+func (k *Keyring) loadLegacySince() error {
+	var since sql.NullInt64
+	if err := k.db.QueryRow("SELECT MAX(started_at) FROM legacy_token_window").Scan(&since); err != nil {
+		return fmt.Errorf("error querying legacy token window: %w", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.legacySince = time.Time{}
+	if since.Valid {
+		k.legacySince = time.Unix(since.Int64, 0)
+	}
+	return nil
+}
+
+// LegacySince returns when the database was upgraded from a version that
+// signed tokens without a kid, or the zero time if it never held such tokens
+func (k *Keyring) LegacySince() time.Time {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.legacySince
+}
+
+// Active returns the key new tokens are signed with
+func (k *Keyring) Active() (*SigningKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.active == nil {
+		return nil, ErrNoSigningKey
+	}
+	return k.active, nil
+}
+
+// Key returns a key that may be used to verify a token
+func (k *Keyring) Key(kid string) (*SigningKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+	if !ok || k.expired(key, time.Now()) {
+		return nil, false
+	}
+	return key, true
+}
+
+// Keys returns copies of all keys still accepted for verification, newest first
+func (k *Keyring) Keys() []SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]SigningKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		if !k.expired(key, now) {
+			keys = append(keys, *key)
+		}
+	}
+
+	// Sort by creation time, newest first
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt > keys[j].CreatedAt
+	})
+
+	return keys
+}
+
+// JWKS returns the public keys of all asymmetric keys still accepted for verification
+func (k *Keyring) JWKS() []JWK {
+	jwks := []JWK{}
+	for _, key := range k.Keys() {
+		if jwk, ok := publicJWK(&key); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	return jwks
+}
+
+// Rotate creates a new active key and retires the previous one
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	if k.keyFile != "" {
+		return nil, ErrKeyFileManaged
+	}
+
+	// Generate the key
+	material, encoded, err := generateKeyMaterial(k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	now := time.Now()
+	key := &SigningKey{
+		ID:          hex.EncodeToString(kidBytes),
+		Algorithm:   k.algorithm,
+		CreatedAt:   now.Unix(),
+		keyMaterial: material,
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	tx, err := k.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Retire the current key and drop keys past their retention
+	if _, err := tx.Exec("UPDATE signing_keys SET retired_at = ? WHERE retired_at IS NULL", now.Unix()); err != nil {
+		return nil, fmt.Errorf("failed to retire signing key: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM signing_keys WHERE retired_at < ?", now.Add(-k.retention).Unix()); err != nil {
+		return nil, fmt.Errorf("failed to delete expired signing keys: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO signing_keys (kid, algorithm, secret, created_at) VALUES (?, ?, ?, ?)",
+		key.ID, key.Algorithm, encoded, key.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing signing key: %w", err)
+	}
+
+	// Update the in-memory copy
+	for id, old := range k.keys {
+		if old.RetiredAt == 0 {
+			retired := *old
+			retired.RetiredAt = now.Unix()
+			k.keys[id] = &retired
+		}
+		if k.expired(k.keys[id], now) {
+			delete(k.keys, id)
+		}
+	}
+	k.keys[key.ID] = key
+	k.active = key
+
+	return key, nil
+}
+
+// SetRetention replaces how long retired keys stay valid for verification
+func (k *Keyring) SetRetention(retention time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.retention = retention
+}
+
+// expired reports whether a retired key is past its retention
+func (k *Keyring) expired(key *SigningKey, now time.Time) bool {
+	return key.RetiredAt != 0 && now.After(time.Unix(key.RetiredAt, 0).Add(k.retention))
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+const testLegacySecretKey = "legacy-secret-key-from-config"
+
+// baselineSchema is part of the schema of databases created before
+// versioned migrations, which signed tokens with secret_key
+const baselineSchema = `
+	CREATE TABLE nonces (
+		nonce TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE zones (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+`
+
+func TestLegacyTokenWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseline bool
+		want     error
+	}{
+		{"upgraded from baseline", true, nil},
+		{"fresh install", false, ErrLegacyToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := openTestDatabase(t, tt.baseline)
+
+			// Start the server the way main does: migrate, seed the admin, load the keys
+			if err := NewUserService(sqlstore.New(database.DB).Users).SeedAdmin("admin", "admin-password"); err != nil {
+				t.Fatal(err)
+			}
+			keyring := NewKeyring(database.DB, AlgorithmHS256, "", time.Hour)
+			if err := keyring.Load(); err != nil {
+				t.Fatal(err)
+			}
+			jwtService := NewJWTService(keyring, testLegacySecretKey, time.Hour, 24*time.Hour)
+
+			claims, err := jwtService.ValidateToken(baselineToken(t, "admin"))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ValidateToken() error = %v, want %v", err, tt.want)
+			}
+			if err == nil && (!claims.Legacy || claims.Email != "admin") {
+				t.Errorf("claims = %+v, want a legacy token for admin", claims)
+			}
+		})
+	}
+}
+
+func TestLegacyTokenWindowCloses(t *testing.T) {
+	database := openTestDatabase(t, true)
+	keyring := NewKeyring(database.DB, AlgorithmHS256, "", time.Hour)
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One access token lifetime after the upgrade every genuine legacy token has expired
+	if _, err := database.Exec("UPDATE legacy_token_window SET started_at = ?", time.Now().Add(-2*time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	jwtService := NewJWTService(keyring, testLegacySecretKey, time.Hour, 24*time.Hour)
+	if _, err := jwtService.ValidateToken(baselineToken(t, "admin")); !errors.Is(err, ErrLegacyToken) {
+		t.Errorf("ValidateToken() error = %v, want %v", err, ErrLegacyToken)
+	}
+}
+
+func TestKeyFileRetiresStoredKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention time.Duration
+		wantValid bool
+	}{
+		{"within the retention", time.Hour, true},
+		{"past the retention", -time.Second, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := openTestDatabase(t, false)
+
+			stored := NewKeyring(database.DB, AlgorithmHS256, "", tt.retention)
+			if err := stored.Load(); err != nil {
+				t.Fatal(err)
+			}
+			previous, err := stored.Active()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyring := NewKeyring(database.DB, AlgorithmHS256, writeTestKeyFile(t), tt.retention)
+			if err := keyring.Load(); err != nil {
+				t.Fatal(err)
+			}
+
+			active, err := keyring.Active()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active.ID == previous.ID || active.Algorithm != AlgorithmEdDSA {
+				t.Errorf("active key = %s (%s), want the key file", active.ID, active.Algorithm)
+			}
+
+			var retiredAt *int64
+			if err := database.QueryRow("SELECT retired_at FROM signing_keys WHERE kid = ?", previous.ID).Scan(&retiredAt); err != nil {
+				t.Fatal(err)
+			}
+			if retiredAt == nil {
+				t.Error("stored key was not retired")
+			}
+
+			if _, ok := keyring.Key(previous.ID); ok != tt.wantValid {
+				t.Errorf("Key(previous) valid = %v, want %v", ok, tt.wantValid)
+			}
+		})
+	}
+}
+
+// openTestDatabase opens a migrated database in a temporary directory,
+// created by a version before versioned migrations when baseline is set
+func openTestDatabase(t *testing.T, baseline bool) *db.DB {
+	t.Helper()
+
+	database, err := db.New(db.DriverPureGo, filepath.Join(t.TempDir(), "ha-mi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if baseline {
+		if _, err := database.Exec(baselineSchema); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+// baselineToken signs an access token the way versions before the keyring
+// did: with secret_key, without a kid, for a random user ID and with the
+// username in the email claim
+func baselineToken(t *testing.T, username string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &CustomClaims{
+		UserID: uuid.New().String(),
+		Email:  username,
+		Role:   "admin",
+		Type:   AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	signed, err := token.SignedString([]byte(testLegacySecretKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// writeTestKeyFile writes a new Ed25519 private key as PKCS#8 PEM
+func writeTestKeyFile(t *testing.T) string {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// keyMaterial holds the keys used to sign and verify tokens
+type keyMaterial struct {
+	signingKey   interface{}
+	verifyingKey interface{}
+}
+
+// generateKeyMaterial creates a new key for the given algorithm and returns it
+// together with its storage encoding
+func generateKeyMaterial(algorithm string) (*keyMaterial, string, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, "", fmt.Errorf("failed to generate HMAC key: %w", err)
+		}
+		return &keyMaterial{signingKey: secret, verifyingKey: secret}, hex.EncodeToString(secret), nil
+	case AlgorithmRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate RSA key: %w", err)
+		}
+		return encodePrivateKey(privateKey)
+	case AlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		}
+		return encodePrivateKey(privateKey)
+	default:
+		return nil, "", fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+}
+
+// decodeKeyMaterial parses a stored key
+func decodeKeyMaterial(algorithm, encoded string) (*keyMaterial, error) {
+	if algorithm == AlgorithmHS256 {
+		secret, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		return &keyMaterial{signingKey: secret, verifyingKey: secret}, nil
+	}
+
+	material, keyAlgorithm, err := parsePrivateKeyPEM([]byte(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if keyAlgorithm != algorithm {
+		return nil, fmt.Errorf("stored key is %s, expected %s", keyAlgorithm, algorithm)
+	}
+	return material, nil
+}
+
+// loadKeyFile reads a PEM encoded Ed25519 or RSA private key from disk
+func loadKeyFile(path string) (*keyMaterial, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading key file: %w", err)
+	}
+	return parsePrivateKeyPEM(data)
+}
+
+// parsePrivateKeyPEM parses a PKCS#8 or PKCS#1 private key and returns it with its algorithm
+func parsePrivateKeyPEM(data []byte) (*keyMaterial, string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("no PEM block found")
+	}
+
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error parsing private key: %w", err)
+	}
+
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return &keyMaterial{signingKey: key, verifyingKey: &key.PublicKey}, AlgorithmRS256, nil
+	case ed25519.PrivateKey:
+		return &keyMaterial{signingKey: key, verifyingKey: key.Public()}, AlgorithmEdDSA, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+}
+
+// encodePrivateKey encodes an asymmetric private key as PKCS#8 PEM
+func encodePrivateKey(privateKey crypto.Signer) (*keyMaterial, string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return &keyMaterial{signingKey: privateKey, verifyingKey: privateKey.Public()}, string(encoded), nil
+}
+
+// publicKeyID derives a key ID from the SHA-256 hash of a public key
+func publicKeyID(publicKey interface{}) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// publicJWK returns the JWK of an asymmetric key, or false for HMAC keys
+func publicJWK(key *SigningKey) (JWK, bool) {
+	jwk := JWK{
+		KeyID:     key.ID,
+		Algorithm: key.Algorithm,
+		Use:       "sig",
+	}
+
+	switch publicKey := key.verifyingKey.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
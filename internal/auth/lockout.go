@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Lockout scopes
+const (
+	LockoutScopeIP       = "ip"
+	LockoutScopeUsername = "username"
+)
+
+// ErrLockoutNotFound is returned when unlocking a key that is not locked
+var ErrLockoutNotFound = errors.New("lockout not found")
+
+// Lockout represents a locked IP address or username
+type Lockout struct {
+	Scope       string `json:"scope"`
+	Key         string `json:"key"`
+	Level       int    `json:"level"`
+	LockedUntil int64  `json:"locked_until"`
+}
+
+// LockoutPolicy configures the login lockout
+type LockoutPolicy struct {
+	MaxFailures  int
+	Window       time.Duration
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// LoginLimiter counts failed logins per IP address and per username in a
+// sliding window. Once a key reaches the failure limit it is locked, and the
+// lock doubles in length every time the key is locked again.
+type LoginLimiter struct {
+	db *sql.DB
+
+	mu     sync.RWMutex
+	policy LockoutPolicy
+}
+
+// NewLoginLimiter creates a new LoginLimiter
+func NewLoginLimiter(db *sql.DB, policy LockoutPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		db:     db,
+		policy: policy,
+	}
+}
+
+// Check returns how long the IP address or username is still locked, or zero
+func (l *LoginLimiter) Check(ip, username string) (time.Duration, error) {
+	var lockedUntil sql.NullInt64
+	err := l.db.QueryRow(`
+		SELECT MAX(locked_until) FROM login_lockouts
+		WHERE (scope = ? AND key = ?) OR (scope = ? AND key = ?)
+	`, LockoutScopeIP, ip, LockoutScopeUsername, username).Scan(&lockedUntil)
+	if err != nil {
+		return 0, fmt.Errorf("error querying lockouts: %w", err)
+	}
+
+	if !lockedUntil.Valid {
+		return 0, nil
+	}
+
+	remaining := time.Until(time.Unix(lockedUntil.Int64, 0))
+	if remaining <= 0 {
+		return 0, nil
+	}
+
+	return remaining, nil
+}
+
+// RecordFailure records a failed login and returns the lock duration if the
+// IP address or username just got locked
+func (l *LoginLimiter) RecordFailure(ip, username string) (time.Duration, error) {
+	var locked time.Duration
+	for _, k := range []struct{ scope, key string }{
+		{LockoutScopeIP, ip},
+		{LockoutScopeUsername, username},
+	} {
+		d, err := l.recordFailure(k.scope, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if d > locked {
+			locked = d
+		}
+	}
+
+	return locked, nil
+}
+
+// RecordSuccess clears the failures and lock level of a username
+func (l *LoginLimiter) RecordSuccess(username string) error {
+	if _, err := l.db.Exec("DELETE FROM login_failures WHERE scope = ? AND key = ?", LockoutScopeUsername, username); err != nil {
+		return fmt.Errorf("error clearing login failures: %w", err)
+	}
+	if _, err := l.db.Exec("DELETE FROM login_lockouts WHERE scope = ? AND key = ?", LockoutScopeUsername, username); err != nil {
+		return fmt.Errorf("error clearing lockout: %w", err)
+	}
+	return nil
+}
+
+// ListLockouts returns the keys that are currently locked
+func (l *LoginLimiter) ListLockouts() ([]*Lockout, error) {
+	rows, err := l.db.Query(
+		"SELECT scope, key, level, locked_until FROM login_lockouts WHERE locked_until > ? ORDER BY locked_until DESC",
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying lockouts: %w", err)
+	}
+	defer rows.Close()
+
+	lockouts := []*Lockout{}
+	for rows.Next() {
+		lockout := &Lockout{}
+		if err := rows.Scan(&lockout.Scope, &lockout.Key, &lockout.Level, &lockout.LockedUntil); err != nil {
+			return nil, fmt.Errorf("error scanning lockout: %w", err)
+		}
+		lockouts = append(lockouts, lockout)
+	}
+
+	return lockouts, rows.Err()
+}
+
+// Unlock removes the lock and failure history of an IP address or username
+func (l *LoginLimiter) Unlock(scope, key string) error {
+	result, err := l.db.Exec("DELETE FROM login_lockouts WHERE scope = ? AND key = ?", scope, key)
+	if err != nil {
+		return fmt.Errorf("error removing lockout: %w", err)
+	}
+
+	if _, err := l.db.Exec("DELETE FROM login_failures WHERE scope = ? AND key = ?", scope, key); err != nil {
+		return fmt.Errorf("error clearing login failures: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrLockoutNotFound
+	}
+
+	return nil
+}
+
+// Policy returns the current lockout policy
+func (l *LoginLimiter) Policy() LockoutPolicy {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.policy
+}
+
+// SetPolicy replaces the lockout policy. Existing lockouts keep their end time.
+func (l *LoginLimiter) SetPolicy(policy LockoutPolicy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy = policy
+}
+
+// recordFailure records a failure for one key and locks it when the limit is reached
+func (l *LoginLimiter) recordFailure(scope, key string) (time.Duration, error) {
+	now := time.Now()
+	policy := l.Policy()
+
+	tx, err := l.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Drop failures that fell out of the window
+	if _, err := tx.Exec("DELETE FROM login_failures WHERE failed_at < ?", now.Add(-policy.Window).Unix()); err != nil {
+		return 0, fmt.Errorf("error pruning login failures: %w", err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO login_failures (scope, key, failed_at) VALUES (?, ?, ?)", scope, key, now.Unix()); err != nil {
+		return 0, fmt.Errorf("error recording login failure: %w", err)
+	}
+
+	var failures int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM login_failures WHERE scope = ? AND key = ?", scope, key).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("error counting login failures: %w", err)
+	}
+
+	if failures < policy.MaxFailures {
+		return 0, tx.Commit()
+	}
+
+	// Find the previous lock level
+	var level int
+	var lockedUntil int64
+	err = tx.QueryRow("SELECT level, locked_until FROM login_lockouts WHERE scope = ? AND key = ?", scope, key).Scan(&level, &lockedUntil)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error querying lockout: %w", err)
+	}
+	level = nextLockLevel(policy, level, time.Unix(lockedUntil, 0), now)
+
+	duration := lockDuration(policy, level)
+	_, err = tx.Exec(`
+		INSERT INTO login_lockouts (scope, key, level, locked_until) VALUES (?, ?, ?, ?)
+		ON CONFLICT(scope, key) DO UPDATE SET level = excluded.level, locked_until = excluded.locked_until
+	`, scope, key, level, now.Add(duration).Unix())
+	if err != nil {
+		return 0, fmt.Errorf("error storing lockout: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM login_failures WHERE scope = ? AND key = ?", scope, key); err != nil {
+		return 0, fmt.Errorf("error clearing login failures: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing lockout: %w", err)
+	}
+
+	return duration, nil
+}
+
+// nextLockLevel returns the level of a new lock given the previous one. The
+// level starts over once a full window has passed since the last lock ended.
+func nextLockLevel(policy LockoutPolicy, level int, lockedUntil, now time.Time) int {
+	if !now.Before(lockedUntil.Add(policy.Window)) {
+		return 1
+	}
+	return level + 1
+}
+
+// lockDuration returns the exponential lock duration of a policy for a lock level
+func lockDuration(policy LockoutPolicy, level int) time.Duration {
+	duration := policy.BaseDuration
+	for i := 1; i < level && duration < policy.MaxDuration; i++ {
+		duration *= 2
+	}
+	if duration > policy.MaxDuration {
+		duration = policy.MaxDuration
+	}
+	return duration
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	MaxFailures:  2,
+	Window:       15 * time.Minute,
+	BaseDuration: time.Minute,
+	MaxDuration:  time.Hour,
+}
+
+func TestNextLockLevel(t *testing.T) {
+	lockedUntil := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want int
+	}{
+		{"while locked", lockedUntil.Add(-time.Second), 3},
+		{"just before a full window", lockedUntil.Add(testLockoutPolicy.Window - time.Second), 3},
+		{"after exactly a full window", lockedUntil.Add(testLockoutPolicy.Window), 1},
+		{"long after", lockedUntil.Add(24 * time.Hour), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextLockLevel(testLockoutPolicy, 2, lockedUntil, tt.now); got != tt.want {
+				t.Errorf("nextLockLevel() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockDuration(t *testing.T) {
+	for level, want := range map[int]time.Duration{
+		1: time.Minute,
+		2: 2 * time.Minute,
+		3: 4 * time.Minute,
+		7: time.Hour,
+		8: time.Hour,
+	} {
+		if got := lockDuration(testLockoutPolicy, level); got != want {
+			t.Errorf("lockDuration(%d) = %v, want %v", level, got, want)
+		}
+	}
+}
+
+// TestLoginLimiterLevelReset moves the end of the last lock back in time to
+// check that the level only resets once a full window has passed
+func TestLoginLimiterLevelReset(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		endedAgo  time.Duration
+		wantLevel int
+	}{
+		{"within the window", testLockoutPolicy.Window - time.Minute, 2},
+		{"after the window", testLockoutPolicy.Window + time.Minute, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newTestLoginLimiter(t)
+
+			lockUsername(t, limiter, "alice", "192.0.2.")
+			if _, err := limiter.db.Exec(
+				"UPDATE login_lockouts SET locked_until = ? WHERE scope = ? AND key = ?",
+				time.Now().Add(-tt.endedAgo).Unix(), LockoutScopeUsername, "alice",
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			locked := lockUsername(t, limiter, "alice", "198.51.100.")
+			if want := lockDuration(testLockoutPolicy, tt.wantLevel); locked != want {
+				t.Errorf("locked for %v, want %v", locked, want)
+			}
+
+			var level int
+			if err := limiter.db.QueryRow(
+				"SELECT level FROM login_lockouts WHERE scope = ? AND key = ?", LockoutScopeUsername, "alice",
+			).Scan(&level); err != nil {
+				t.Fatal(err)
+			}
+			if level != tt.wantLevel {
+				t.Errorf("level = %d, want %d", level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+// lockUsername records failures from different IP addresses in a network
+// until the username is locked and returns the lock duration, so that only
+// the username reaches the failure limit
+func lockUsername(t *testing.T, limiter *LoginLimiter, username, network string) time.Duration {
+	t.Helper()
+
+	for i := 0; i < testLockoutPolicy.MaxFailures; i++ {
+		locked, err := limiter.RecordFailure(fmt.Sprintf("%s%d", network, i+1), username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked > 0 {
+			return locked
+		}
+	}
+
+	t.Fatalf("%s was not locked after %d failures", username, testLockoutPolicy.MaxFailures)
+	return 0
+}
+
+func newTestLoginLimiter(t *testing.T) *LoginLimiter {
+	t.Helper()
+
+	database, err := db.New(db.DriverPureGo, filepath.Join(t.TempDir(), "ha-mi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return NewLoginLimiter(database.DB, testLockoutPolicy)
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// mfaIssuer is the issuer shown in authenticator apps
+const mfaIssuer = "HA-MI"
+
+// recoveryCodeCount is the number of recovery codes issued on activation
+const recoveryCodeCount = 10
+
+// MFA errors
+var (
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not enrolled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFAInvalidCode    = errors.New("invalid verification code")
+)
+
+// MFAEnrollment holds a newly generated TOTP secret
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFAService handles TOTP two-factor authentication
+type MFAService struct {
+	db *sql.DB
+}
+
+// NewMFAService creates a new MFAService
+func NewMFAService(db *sql.DB) *MFAService {
+	return &MFAService{
+		db: db,
+	}
+}
+
+// Enroll generates a new TOTP secret for a user. The secret only takes
+// effect once it is activated with a valid code.
+func (s *MFAService) Enroll(user *User) (*MFAEnrollment, error) {
+	enabled, err := s.IsEnabled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO user_mfa (user_id, secret, enabled, last_step, created_at) VALUES (?, ?, 0, 0, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+	`, user.ID, secret, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to store totp secret: %w", err)
+	}
+
+	return &MFAEnrollment{
+		Secret: secret,
+		URI:    TOTPURI(mfaIssuer, user.Username, secret),
+	}, nil
+}
+
+// Activate enables two-factor authentication after verifying the first code
+// and returns a fresh set of recovery codes
+func (s *MFAService) Activate(userID, code string) ([]string, error) {
+	var secret string
+	var enabled bool
+	err := s.db.QueryRow("SELECT secret, enabled FROM user_mfa WHERE user_id = ?", userID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, fmt.Errorf("error querying totp secret: %w", err)
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE user_mfa SET enabled = 1, last_step = ? WHERE user_id = ?", step, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing totp activation: %w", err)
+	}
+
+	return codes, nil
+}
+
+// IsEnabled reports whether a user has activated two-factor authentication
+func (s *MFAService) IsEnabled(userID string) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow("SELECT enabled FROM user_mfa WHERE user_id = ?", userID).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("error querying totp status: %w", err)
+	}
+	return enabled, nil
+}
+
+// Verify checks a TOTP code or a recovery code for a user. Each TOTP code and
+// each recovery code can be used only once.
+func (s *MFAService) Verify(userID, code string) error {
+	var secret string
+	var lastStep int64
+	err := s.db.QueryRow("SELECT secret, last_step FROM user_mfa WHERE user_id = ? AND enabled = 1", userID).Scan(&secret, &lastStep)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrMFANotEnrolled
+		}
+		return fmt.Errorf("error querying totp secret: %w", err)
+	}
+
+	// Try the code as a TOTP code first
+	if step, ok := ValidateTOTP(secret, code, time.Now()); ok {
+		result, err := s.db.Exec("UPDATE user_mfa SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+		if err != nil {
+			return fmt.Errorf("failed to record totp use: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrMFAInvalidCode
+		}
+		return nil
+	}
+
+	// Fall back to a recovery code
+	result, err := s.db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().Unix(), userID, hashRecoveryCode(code),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMFAInvalidCode
+	}
+
+	return nil
+}
+
+// Disable removes two-factor authentication and the recovery codes of a user
+func (s *MFAService) Disable(userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM user_mfa WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to remove totp secret: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to remove recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing totp removal: %w", err)
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes generates new recovery codes, replacing the old ones
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to remove recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, 5)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+		}
+
+		code := hex.EncodeToString(bytes)
+		code = code[:5] + "-" + code[5:]
+
+		_, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)",
+			userID, hashRecoveryCode(code), time.Now().Unix(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode returns the hex-encoded SHA-256 hash of a normalized recovery code
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
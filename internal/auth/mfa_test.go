@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 6238 appendix B, SHA-1; six digits are the last six of the eight listed
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix/totpPeriod)); got != tt.want {
+			t.Errorf("totpCode(T=%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, "050471", step, true},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), "050471", step, true},
+		{"previous step", rfc6238Secret, testTOTPCode(t, rfc6238Secret, step-1), step - 1, true},
+		{"next step", rfc6238Secret, testTOTPCode(t, rfc6238Secret, step+1), step + 1, true},
+		{"two steps old", rfc6238Secret, testTOTPCode(t, rfc6238Secret, step-2), 0, false},
+		{"wrong code", rfc6238Secret, "123456", 0, false},
+		{"eight digits", rfc6238Secret, "07081804", 0, false},
+		{"invalid secret", "not base32!", "050471", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := ValidateTOTP(tt.secret, tt.code, now)
+			if gotStep != tt.wantStep || gotOK != tt.wantOK {
+				t.Errorf("ValidateTOTP() = %d, %v, want %d, %v", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestMFAVerifyRejectsReplayedCode(t *testing.T) {
+	mfa, userID, secret, _ := newTestMFA(t)
+
+	// Activation used the current step, the next one is still within the skew
+	step := time.Now().Unix() / totpPeriod
+	next := testTOTPCode(t, secret, step+1)
+
+	if err := mfa.Verify(userID, next); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if err := mfa.Verify(userID, next); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("Verify() of a replayed code error = %v, want %v", err, ErrMFAInvalidCode)
+	}
+
+	// Codes of earlier steps are replays too, even if never used
+	if err := mfa.Verify(userID, testTOTPCode(t, secret, step)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("Verify() of an earlier code error = %v, want %v", err, ErrMFAInvalidCode)
+	}
+}
+
+func TestMFARecoveryCodesAreSingleUse(t *testing.T) {
+	mfa, userID, _, recoveryCodes := newTestMFA(t)
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"first use", recoveryCodes[0], nil},
+		{"second use", recoveryCodes[0], ErrMFAInvalidCode},
+		{"another code, reformatted", strings.ToUpper(strings.ReplaceAll(recoveryCodes[1], "-", "")), nil},
+		{"that code again as issued", recoveryCodes[1], ErrMFAInvalidCode},
+		{"unknown code", "0000-0000", ErrMFAInvalidCode},
+	}
+
+	// Steps run in order, each depends on the ones before
+	for _, tt := range tests {
+		if err := mfa.Verify(userID, tt.code); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+// newTestMFA enrolls and activates two-factor authentication for a new user
+// and returns the service, the user ID, the secret and the recovery codes
+func newTestMFA(t *testing.T) (*MFAService, string, string, []string) {
+	t.Helper()
+
+	database := openTestDatabase(t, false)
+	user, err := NewUserService(sqlstore.New(database.DB).Users).CreateUser("alice", "alice-password", "", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mfa := NewMFAService(database.DB)
+	enrollment, err := mfa.Enroll(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, err := mfa.Activate(user.ID, testTOTPCode(t, enrollment.Secret, time.Now().Unix()/totpPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfa, user.ID, enrollment.Secret, codes
+}
+
+// testTOTPCode returns the code of a secret for a time step
+func testTOTPCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totpCode(key, uint64(step))
+}
@@ -2,27 +2,34 @@ package auth
 
 import (
 	"crypto/rand"
-	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/mattn/go-sqlite3"
+	"github.com/boringsoft/ha-mi/internal/metrics"
+	"github.com/boringsoft/ha-mi/internal/store"
 )
 
 // NonceService handles nonce operations
 type NonceService struct {
-	db          *sql.DB
-	nonceExpiry time.Duration
+	nonces      store.NonceRepository
+	nonceExpiry atomic.Int64 // time.Duration
 }
 
 // NewNonceService creates a new NonceService
-func NewNonceService(db *sql.DB, nonceExpiry time.Duration) *NonceService {
-	return &NonceService{
-		db:          db,
-		nonceExpiry: nonceExpiry,
+func NewNonceService(nonces store.NonceRepository, nonceExpiry time.Duration) *NonceService {
+	s := &NonceService{
+		nonces: nonces,
 	}
+	s.nonceExpiry.Store(int64(nonceExpiry))
+	return s
+}
+
+// SetExpiry replaces the lifetime of newly issued nonces
+func (s *NonceService) SetExpiry(nonceExpiry time.Duration) {
+	s.nonceExpiry.Store(int64(nonceExpiry))
 }
 
 // GenerateNonce generates a random nonce and stores it in the database
@@ -37,52 +44,43 @@ func (s *NonceService) GenerateNonce() (string, error) {
 	nonce := hex.EncodeToString(bytes)
 
 	// Calculate expiry time
-	expiresAt := time.Now().Add(s.nonceExpiry).Unix()
+	expiresAt := time.Now().Add(time.Duration(s.nonceExpiry.Load())).Unix()
 
-	// Store in database
-	_, err := s.db.Exec("INSERT INTO nonces (nonce, expires_at) VALUES (?, ?)", nonce, expiresAt)
-	if err != nil {
-		// Check for unique constraint violation
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+	// Store nonce
+	if err := s.nonces.Create(nonce, expiresAt); err != nil {
+		if errors.Is(err, store.ErrConflict) {
 			return "", errors.New("failed to generate unique nonce, please try again")
 		}
-		return "", fmt.Errorf("failed to store nonce: %w", err)
+		return "", err
 	}
 
+	metrics.NonceIssued()
 	return nonce, nil
 }
 
 // ValidateNonce checks if a nonce is valid and not expired
 func (s *NonceService) ValidateNonce(nonce string) error {
-	// Check if nonce exists and not expired
-	var expiresAt int64
-	err := s.db.QueryRow("SELECT expires_at FROM nonces WHERE nonce = ?", nonce).Scan(&expiresAt)
+	// Take the nonce so it cannot be reused, even by a concurrent request
+	expiresAt, err := s.nonces.Take(nonce)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if errors.Is(err, store.ErrNotFound) {
+			metrics.NonceValidated(metrics.NonceInvalid)
 			return errors.New("invalid nonce")
 		}
-		return fmt.Errorf("error querying nonce: %w", err)
+		return err
 	}
 
 	// Check if expired
 	if time.Now().Unix() > expiresAt {
+		metrics.NonceValidated(metrics.NonceExpired)
 		return errors.New("expired nonce")
 	}
 
-	// Delete nonce to prevent reuse
-	_, err = s.db.Exec("DELETE FROM nonces WHERE nonce = ?", nonce)
-	if err != nil {
-		return fmt.Errorf("error deleting used nonce: %w", err)
-	}
-
+	metrics.NonceValidated(metrics.NonceOK)
 	return nil
 }
 
 // CleanupExpiredNonces removes expired nonces from the database
 func (s *NonceService) CleanupExpiredNonces() error {
-	_, err := s.db.Exec("DELETE FROM nonces WHERE expires_at < ?", time.Now().Unix())
-	if err != nil {
-		return fmt.Errorf("error cleaning up expired nonces: %w", err)
-	}
-	return nil
+	return s.nonces.DeleteExpired(time.Now().Unix())
 }
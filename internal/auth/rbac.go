@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// Permission identifies an action that a role may perform
+type Permission string
+
+// Permissions
+const (
+	PermZonesRead      Permission = "zones:read"
+	PermZonesWrite     Permission = "zones:write"
+	PermMappingsRead   Permission = "mappings:read"
+	PermMappingsWrite  Permission = "mappings:write"
+	PermScenesRead     Permission = "scenes:read"
+	PermScenesWrite    Permission = "scenes:write"
+	PermScenesExecute  Permission = "scenes:execute"
+	PermDevicesControl Permission = "devices:control"
+	PermUsersRead      Permission = "users:read"
+	PermUsersWrite     Permission = "users:write"
+	PermRolesRead      Permission = "roles:read"
+	PermRolesWrite     Permission = "roles:write"
+	PermAuditRead      Permission = "audit:read"
+	PermSystemManage   Permission = "system:manage"
+)
+
+// AllPermissions lists every known permission
+var AllPermissions = []Permission{
+	PermZonesRead,
+	PermZonesWrite,
+	PermMappingsRead,
+	PermMappingsWrite,
+	PermScenesRead,
+	PermScenesWrite,
+	PermScenesExecute,
+	PermDevicesControl,
+	PermUsersRead,
+	PermUsersWrite,
+	PermRolesRead,
+	PermRolesWrite,
+	PermAuditRead,
+	PermSystemManage,
+}
+
+// Built-in roles
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// builtinRoles holds the permissions of the built-in roles
+var builtinRoles = map[string]*Role{
+	RoleAdmin: {
+		Name:        RoleAdmin,
+		Description: "Full access to all resources",
+		Permissions: AllPermissions,
+		BuiltIn:     true,
+	},
+	RoleOperator: {
+		Name:        RoleOperator,
+		Description: "Control devices and run scenes",
+		Permissions: []Permission{
+			PermZonesRead,
+			PermMappingsRead,
+			PermScenesRead,
+			PermScenesExecute,
+			PermDevicesControl,
+		},
+		BuiltIn: true,
+	},
+	RoleViewer: {
+		Name:        RoleViewer,
+		Description: "Read-only access",
+		Permissions: []Permission{
+			PermZonesRead,
+			PermMappingsRead,
+			PermScenesRead,
+		},
+		BuiltIn: true,
+	},
+}
+
+// Role errors
+var (
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrRoleExists        = errors.New("role already exists")
+	ErrRoleBuiltIn       = errors.New("built-in roles cannot be modified")
+	ErrRoleInUse         = errors.New("role is assigned to users")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// Role represents a named set of permissions
+type Role struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions"`
+	BuiltIn     bool         `json:"built_in"`
+}
+
+// RoleService handles role and permission lookups
+type RoleService struct {
+	db    *sql.DB
+	users store.UserRepository
+}
+
+// NewRoleService creates a new RoleService. The user repository is used to
+// check that a role is unassigned before it is deleted.
+func NewRoleService(db *sql.DB, users store.UserRepository) *RoleService {
+	return &RoleService{
+		db:    db,
+		users: users,
+	}
+}
+
+// HasPermission reports whether the given role grants a permission
+func (s *RoleService) HasPermission(roleName string, permission Permission) (bool, error) {
+	role, err := s.GetRole(roleName)
+	if err != nil {
+		if errors.Is(err, ErrRoleNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, p := range role.Permissions {
+		if p == permission {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetRole returns a built-in or custom role by name
+func (s *RoleService) GetRole(name string) (*Role, error) {
+	if role, ok := builtinRoles[name]; ok {
+		return role, nil
+	}
+
+	var description, permissions string
+	err := s.db.QueryRow("SELECT description, permissions FROM roles WHERE name = ?", name).Scan(&description, &permissions)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRoleNotFound
+		}
+		return nil, fmt.Errorf("error querying role: %w", err)
+	}
+
+	return decodeRole(name, description, permissions)
+}
+
+// ListRoles returns the built-in roles followed by all custom roles
+func (s *RoleService) ListRoles() ([]*Role, error) {
+	roles := []*Role{
+		builtinRoles[RoleAdmin],
+		builtinRoles[RoleOperator],
+		builtinRoles[RoleViewer],
+	}
+
+	rows, err := s.db.Query("SELECT name, description, permissions FROM roles ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying roles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name, description, permissions string
+		if err := rows.Scan(&name, &description, &permissions); err != nil {
+			return nil, fmt.Errorf("error scanning role: %w", err)
+		}
+
+		role, err := decodeRole(name, description, permissions)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+// CreateRole creates a custom role
+func (s *RoleService) CreateRole(name, description string, permissions []Permission) (*Role, error) {
+	if _, ok := builtinRoles[name]; ok {
+		return nil, ErrRoleExists
+	}
+
+	encoded, err := encodePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	_, err = s.db.Exec(
+		"INSERT INTO roles (name, description, permissions, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		name, description, encoded, now, now,
+	)
+	if err != nil {
+		// Check for unique constraint violation
+		if db.IsConstraintError(err) {
+			return nil, ErrRoleExists
+		}
+		return nil, fmt.Errorf("failed to store role: %w", err)
+	}
+
+	return &Role{Name: name, Description: description, Permissions: permissions}, nil
+}
+
+// UpdateRole replaces the description and permissions of a custom role
+func (s *RoleService) UpdateRole(name, description string, permissions []Permission) (*Role, error) {
+	if _, ok := builtinRoles[name]; ok {
+		return nil, ErrRoleBuiltIn
+	}
+
+	encoded, err := encodePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE roles SET description = ?, permissions = ?, updated_at = ? WHERE name = ?",
+		description, encoded, time.Now().Unix(), name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrRoleNotFound
+	}
+
+	return &Role{Name: name, Description: description, Permissions: permissions}, nil
+}
+
+// DeleteRole deletes a custom role that is not assigned to any user
+func (s *RoleService) DeleteRole(name string) error {
+	if _, ok := builtinRoles[name]; ok {
+		return ErrRoleBuiltIn
+	}
+
+	count, err := s.users.CountByRole(name)
+	if err != nil {
+		return fmt.Errorf("error counting role users: %w", err)
+	}
+
+	if count > 0 {
+		return ErrRoleInUse
+	}
+
+	result, err := s.db.Exec("DELETE FROM roles WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete role: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrRoleNotFound
+	}
+
+	return nil
+}
+
+// encodePermissions validates and serializes a permission list
+func encodePermissions(permissions []Permission) (string, error) {
+	for _, p := range permissions {
+		if !IsKnownPermission(p) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownPermission, p)
+		}
+	}
+
+	encoded, err := json.Marshal(permissions)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode permissions: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// decodeRole builds a custom role from its stored columns
+func decodeRole(name, description, permissions string) (*Role, error) {
+	role := &Role{
+		Name:        name,
+		Description: description,
+		Permissions: []Permission{},
+	}
+
+	if err := json.Unmarshal([]byte(permissions), &role.Permissions); err != nil {
+		return nil, fmt.Errorf("error decoding permissions of role %s: %w", name, err)
+	}
+
+	return role, nil
+}
+
+// IsKnownPermission reports whether a permission is defined
+func IsKnownPermission(permission Permission) bool {
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
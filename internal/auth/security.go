@@ -10,11 +10,13 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // SecurityService handles request security validation
 type SecurityService struct {
+	mu               sync.RWMutex
 	secretKey        string
 	timestampMaxDiff int64 // Maximum allowed difference in seconds
 }
@@ -27,6 +29,20 @@ func NewSecurityService(secretKey string, timestampMaxDiff int64) *SecurityServi
 	}
 }
 
+// SetSecretKey replaces the request signing key
+func (s *SecurityService) SetSecretKey(secretKey string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secretKey = secretKey
+}
+
+// key returns the request signing key
+func (s *SecurityService) key() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return []byte(s.secretKey)
+}
+
 // ValidateTimestamp validates if the timestamp is within the allowed time window
 func (s *SecurityService) ValidateTimestamp(timestamp string) error {
 	ts, err := strconv.ParseInt(timestamp, 10, 64)
@@ -77,7 +93,7 @@ func (s *SecurityService) GenerateSignature(params map[string]string) string {
 	stringToSign := sb.String()
 
 	// Generate HMAC-SHA256 signature
-	h := hmac.New(sha256.New, []byte(s.secretKey))
+	h := hmac.New(sha256.New, s.key())
 	h.Write([]byte(stringToSign))
 	signature := hex.EncodeToString(h.Sum(nil))
 
@@ -97,6 +113,47 @@ func (s *SecurityService) ValidateSignature(params map[string]string, providedSi
 	return nil
 }
 
+// CanonicalRequest builds the string signed by version 2 signatures:
+// METHOD\nPATH\nsorted-query\nsha256(body)\ntimestamp\nnonce
+func CanonicalRequest(method, path string, query url.Values, body []byte, timestamp, nonce string) string {
+	// Encode the query without the signature, sorted by key
+	q := url.Values{}
+	for k, v := range query {
+		if k != "sign" {
+			q[k] = v
+		}
+	}
+
+	bodyHash := sha256.Sum256(body)
+
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		q.Encode(),
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+	}, "\n")
+}
+
+// GenerateSignatureV2 generates a HMAC-SHA256 signature over the canonical request
+func (s *SecurityService) GenerateSignatureV2(method, path string, query url.Values, body []byte, timestamp, nonce string) string {
+	h := hmac.New(sha256.New, s.key())
+	h.Write([]byte(CanonicalRequest(method, path, query, body, timestamp, nonce)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ValidateSignatureV2 validates a version 2 signature of a request
+func (s *SecurityService) ValidateSignatureV2(method, path string, query url.Values, body []byte, timestamp, nonce, providedSignature string) error {
+	expectedSignature := s.GenerateSignatureV2(method, path, query, body, timestamp, nonce)
+
+	if !hmac.Equal([]byte(providedSignature), []byte(expectedSignature)) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
+
 // ExtractParams extracts parameters from query string and form values
 func ExtractParams(queryString string, formValues url.Values) map[string]string {
 	params := make(map[string]string)
@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session errors
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrRefreshTokenUnknown = errors.New("unknown refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+)
+
+// Session represents a login session and the client it was started from
+type Session struct {
+	ID              string `json:"id"`
+	UserID          string `json:"user_id"`
+	Device          string `json:"device"`
+	UserAgent       string `json:"user_agent"`
+	IP              string `json:"ip"`
+	CreatedAt       int64  `json:"created_at"`
+	LastRefreshedAt int64  `json:"last_refreshed_at"`
+}
+
+// ClientInfo describes the client a session is used from
+type ClientInfo struct {
+	Device    string
+	UserAgent string
+	IP        string
+}
+
+// SessionService tracks login sessions and rotates their refresh tokens.
+// Every refresh token belongs to a session (token family) and may be used
+// only once; presenting a used token again revokes the whole session.
+type SessionService struct {
+	db          *sql.DB
+	jwtService  *JWTService
+	userService *UserService
+	denylist    *Denylist
+}
+
+// NewSessionService creates a new SessionService
+func NewSessionService(db *sql.DB, jwtService *JWTService, userService *UserService) *SessionService {
+	return &SessionService{
+		db:          db,
+		jwtService:  jwtService,
+		userService: userService,
+		denylist:    NewDenylist(jwtService.AccessTokenExpiry()),
+	}
+}
+
+// SetAccessTokenExpiry keeps newly revoked sessions denied for as long as
+// access tokens issued from them may be valid
+func (s *SessionService) SetAccessTokenExpiry(expiry time.Duration) {
+	s.denylist.SetTTL(expiry)
+}
+
+// LoadRevokedSessions fills the denylist with sessions revoked recently enough
+// that their access tokens may still be valid
+func (s *SessionService) LoadRevokedSessions() error {
+	since := time.Now().Add(-s.jwtService.AccessTokenExpiry()).Unix()
+	rows, err := s.db.Query("SELECT id, revoked_at FROM sessions WHERE revoked_at >= ?", since)
+	if err != nil {
+		return fmt.Errorf("error querying revoked sessions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var revokedAt int64
+		if err := rows.Scan(&id, &revokedAt); err != nil {
+			return fmt.Errorf("error scanning revoked session: %w", err)
+		}
+		s.denylist.Add(id, time.Unix(revokedAt, 0))
+	}
+
+	return rows.Err()
+}
+
+// IsRevoked reports whether access tokens of a session must be rejected
+func (s *SessionService) IsRevoked(sessionID string) bool {
+	return sessionID != "" && s.denylist.Contains(sessionID)
+}
+
+// CreateSession starts a new session for a user and issues its first token pair
+func (s *SessionService) CreateSession(user *User, client ClientInfo) (*TokenPair, error) {
+	sessionID := uuid.New().String()
+
+	// Generate tokens
+	pair, err := s.jwtService.GenerateTokens(user.ID, user.Email, user.Role, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	_, err = tx.Exec(
+		"INSERT INTO sessions (id, user_id, device, user_agent, ip, created_at, last_refreshed_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		sessionID, user.ID, client.Device, client.UserAgent, client.IP, now, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store session: %w", err)
+	}
+
+	if err := insertRefreshToken(tx, pair, sessionID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing session: %w", err)
+	}
+
+	return pair, nil
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same session
+func (s *SessionService) Refresh(refreshToken string, client ClientInfo) (*TokenPair, error) {
+	// Validate the refresh token
+	claims, err := s.jwtService.ValidateToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if it's actually a refresh token
+	if claims.Type != RefreshToken {
+		return nil, errors.New("token is not a refresh token")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Look up the token and its session
+	var sessionID, userID string
+	var usedAt, revokedAt sql.NullInt64
+	err = tx.QueryRow(`
+		SELECT r.session_id, s.user_id, r.used_at, s.revoked_at
+		FROM refresh_tokens r JOIN sessions s ON s.id = r.session_id
+		WHERE r.jti = ?
+	`, claims.ID).Scan(&sessionID, &userID, &usedAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRefreshTokenUnknown
+		}
+		return nil, fmt.Errorf("error querying refresh token: %w", err)
+	}
+
+	if revokedAt.Valid {
+		return nil, ErrSessionRevoked
+	}
+
+	now := time.Now().Unix()
+
+	// Mark the token as used; a token that was already used means it leaked
+	result, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE jti = ? AND used_at IS NULL", now, claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token as used: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); usedAt.Valid || n == 0 {
+		if _, err := tx.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ?", now, sessionID); err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("error committing session revocation: %w", err)
+		}
+		s.denylist.Add(sessionID, time.Unix(now, 0))
+		return nil, ErrRefreshTokenReused
+	}
+
+	// Reload the user so role changes and deletions take effect
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate new tokens
+	pair, err := s.jwtService.GenerateTokens(user.ID, user.Email, user.Role, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertRefreshToken(tx, pair, sessionID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE sessions SET user_agent = ?, ip = ?, last_refreshed_at = ? WHERE id = ?",
+		client.UserAgent, client.IP, now, sessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing refresh: %w", err)
+	}
+
+	return pair, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *SessionService) ListSessions(userID string) ([]*Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, device, user_agent, ip, created_at, last_refreshed_at
+		FROM sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND EXISTS (
+			SELECT 1 FROM refresh_tokens r
+			WHERE r.session_id = sessions.id AND r.used_at IS NULL AND r.expires_at > ?
+		)
+		ORDER BY last_refreshed_at DESC
+	`, userID, time.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("error querying sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session := &Session{}
+		err := rows.Scan(&session.ID, &session.UserID, &session.Device, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastRefreshedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning session: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes a single session of a user
+func (s *SessionService) RevokeSession(userID, sessionID string) error {
+	now := time.Now()
+	result, err := s.db.Exec(
+		"UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		now.Unix(), sessionID, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+
+	s.denylist.Add(sessionID, now)
+
+	return nil
+}
+
+// RevokeAllSessions revokes every active session of a user
+func (s *SessionService) RevokeAllSessions(userID string) error {
+	rows, err := s.db.Query("SELECT id FROM sessions WHERE user_id = ? AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("error querying sessions: %w", err)
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error querying sessions: %w", err)
+	}
+
+	now := time.Now()
+	_, err = s.db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now.Unix(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	for _, id := range ids {
+		s.denylist.Add(id, now)
+	}
+
+	return nil
+}
+
+// CleanupExpiredTokens removes refresh tokens that have expired
+func (s *SessionService) CleanupExpiredTokens() error {
+	_, err := s.db.Exec("DELETE FROM refresh_tokens WHERE expires_at < ?", time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("error cleaning up expired refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// insertRefreshToken records a newly issued refresh token
+func insertRefreshToken(tx *sql.Tx, pair *TokenPair, sessionID string) error {
+	_, err := tx.Exec(
+		"INSERT INTO refresh_tokens (jti, session_id, expires_at, created_at) VALUES (?, ?, ?, ?)",
+		pair.RefreshID, sessionID, pair.RefreshExpiresAt.Unix(), time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+func TestSessionRefresh(t *testing.T) {
+	// errAny accepts any error, for failures without a sentinel
+	errAny := errors.New("any error")
+
+	tests := []struct {
+		name string
+		// prepare returns the refresh token to present, given the first token pair
+		prepare     func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string
+		wantErr     error
+		wantRevoked bool
+	}{
+		{
+			name: "first use rotates the token",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				return first.RefreshToken
+			},
+		},
+		{
+			name: "rotated token can be refreshed again",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				return mustRefresh(t, s, first.RefreshToken).RefreshToken
+			},
+		},
+		{
+			name: "reused token revokes the session",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				mustRefresh(t, s, first.RefreshToken)
+				return first.RefreshToken
+			},
+			wantErr:     ErrRefreshTokenReused,
+			wantRevoked: true,
+		},
+		{
+			name: "newest token of a session revoked by reuse",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				second := mustRefresh(t, s, first.RefreshToken)
+				if _, err := s.Refresh(first.RefreshToken, ClientInfo{}); !errors.Is(err, ErrRefreshTokenReused) {
+					t.Fatalf("reuse error = %v, want %v", err, ErrRefreshTokenReused)
+				}
+				return second.RefreshToken
+			},
+			wantErr:     ErrSessionRevoked,
+			wantRevoked: true,
+		},
+		{
+			name: "token of an unknown session",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				pair, err := s.jwtService.GenerateTokens("user", "", RoleAdmin, "no-such-session")
+				if err != nil {
+					t.Fatal(err)
+				}
+				return pair.RefreshToken
+			},
+			wantErr: ErrRefreshTokenUnknown,
+		},
+		{
+			name: "access token",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				return first.AccessToken
+			},
+			wantErr: errAny,
+		},
+		{
+			// A refresh failing after the token was marked used rolls back,
+			// so the token is not consumed and the client may retry it
+			name: "failed refresh keeps the token",
+			prepare: func(t *testing.T, s *SessionService, database *db.DB, first *TokenPair) string {
+				if _, err := database.Exec(`
+					CREATE TRIGGER fail_refresh BEFORE INSERT ON refresh_tokens
+					BEGIN SELECT RAISE(ABORT, 'refresh failed'); END
+				`); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := s.Refresh(first.RefreshToken, ClientInfo{}); err == nil {
+					t.Fatal("Refresh() succeeded despite the failing insert")
+				}
+				if _, err := database.Exec("DROP TRIGGER fail_refresh"); err != nil {
+					t.Fatal(err)
+				}
+				return first.RefreshToken
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, database, user := newTestSessionService(t)
+
+			first, err := s.CreateSession(user, ClientInfo{Device: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := tt.prepare(t, s, database, first)
+
+			pair, err := s.Refresh(token, ClientInfo{IP: "192.0.2.1"})
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("Refresh() succeeded, want an error")
+				}
+			case !errors.Is(err, tt.wantErr):
+				t.Fatalf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (pair.RefreshToken == token || pair.RefreshID == first.RefreshID) {
+				t.Error("Refresh() did not rotate the refresh token")
+			}
+
+			claims, err := s.jwtService.ValidateToken(first.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.IsRevoked(claims.SessionID); got != tt.wantRevoked {
+				t.Errorf("IsRevoked() = %v, want %v", got, tt.wantRevoked)
+			}
+
+			// The revocation is stored, a restarted server denies the session too
+			restarted := NewSessionService(database.DB, s.jwtService, s.userService)
+			if err := restarted.LoadRevokedSessions(); err != nil {
+				t.Fatal(err)
+			}
+			if got := restarted.IsRevoked(claims.SessionID); got != tt.wantRevoked {
+				t.Errorf("IsRevoked() after restart = %v, want %v", got, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+// mustRefresh refreshes a token that is expected to be valid
+func mustRefresh(t *testing.T, s *SessionService, refreshToken string) *TokenPair {
+	t.Helper()
+
+	pair, err := s.Refresh(refreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func newTestSessionService(t *testing.T) (*SessionService, *db.DB, *User) {
+	t.Helper()
+
+	database := openTestDatabase(t, false)
+	userService := NewUserService(sqlstore.New(database.DB).Users)
+	user, err := userService.CreateUser("alice", "alice-password", "", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := NewKeyring(database.DB, AlgorithmHS256, "", time.Hour)
+	if err := keyring.Load(); err != nil {
+		t.Fatal(err)
+	}
+	jwtService := NewJWTService(keyring, testLegacySecretKey, time.Hour, 24*time.Hour)
+
+	return NewSessionService(database.DB, jwtService, userService), database, user
+}
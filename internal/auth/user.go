@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// User errors
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUserExists         = errors.New("username already exists")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLastAdmin          = errors.New("cannot remove the last admin")
+)
+
+// User represents a user account
+type User = store.User
+
+// UserService handles user account operations
+type UserService struct {
+	users store.UserRepository
+}
+
+// NewUserService creates a new UserService
+func NewUserService(users store.UserRepository) *UserService {
+	return &UserService{
+		users: users,
+	}
+}
+
+// CreateUser creates a new user with a bcrypt-hashed password
+func (s *UserService) CreateUser(username, password, email, role string) (*User, error) {
+	// Hash password
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	user := &User{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Email:     email,
+		Role:      role,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Store user
+	if err := s.users.Create(user, hash); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUser returns the user with the given ID
+func (s *UserService) GetUser(id string) (*User, error) {
+	return userResult(s.users.Get(id))
+}
+
+// GetUserByUsername returns the user with the given username
+func (s *UserService) GetUserByUsername(username string) (*User, error) {
+	return userResult(s.users.GetByUsername(username))
+}
+
+// ListUsers returns all users ordered by username
+func (s *UserService) ListUsers() ([]*User, error) {
+	return s.users.List()
+}
+
+// UpdateUser updates the email and role of a user
+func (s *UserService) UpdateUser(id, email, role string) (*User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure at least one admin remains
+	if user.Role == RoleAdmin && role != RoleAdmin {
+		if err := s.checkNotLastAdmin(); err != nil {
+			return nil, err
+		}
+	}
+
+	user.Email = email
+	user.Role = role
+	user.UpdatedAt = time.Now().Unix()
+
+	if _, err := userResult(user, s.users.Update(user)); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SetPassword replaces the password of a user
+func (s *UserService) SetPassword(id, password string) error {
+	// Hash password
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = userResult(nil, s.users.SetPasswordHash(id, hash, time.Now().Unix()))
+	return err
+}
+
+// DeleteUser deletes a user
+func (s *UserService) DeleteUser(id string) error {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	// Make sure at least one admin remains
+	if user.Role == RoleAdmin {
+		if err := s.checkNotLastAdmin(); err != nil {
+			return err
+		}
+	}
+
+	_, err = userResult(nil, s.users.Delete(id))
+	return err
+}
+
+// Authenticate checks a username and password and returns the matching user
+func (s *UserService) Authenticate(username, password string) (*User, error) {
+	user, hash, err := s.users.GetWithPasswordHash(username)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// Compare against a dummy hash so unknown users take as long as known ones
+			_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// SeedAdmin creates the initial admin account when no users exist yet
+func (s *UserService) SeedAdmin(username, password string) error {
+	count, err := s.users.Count()
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := s.CreateUser(username, password, "", RoleAdmin); err != nil {
+		return fmt.Errorf("failed to seed admin user: %w", err)
+	}
+
+	return nil
+}
+
+// checkNotLastAdmin returns ErrLastAdmin if there is only one admin left
+func (s *UserService) checkNotLastAdmin() error {
+	count, err := s.users.CountByRole(RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	if count <= 1 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// userResult maps store.ErrNotFound to ErrUserNotFound
+func userResult(user *User, err error) (*User, error) {
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// dummyHash is compared against when a username does not exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("ha-mi"), bcrypt.DefaultCost)
+
+// hashPassword hashes a password with bcrypt
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
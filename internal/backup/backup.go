@@ -0,0 +1,233 @@
+// Package backup manages snapshots of the database in a backup directory
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+)
+
+// Snapshot file names carry the UTC creation time, so they sort by age
+const (
+	filePrefix = "ha-mi-"
+	fileSuffix = ".db"
+	timeLayout = "20060102-150405.000"
+)
+
+// ErrNotFound is returned for snapshots that do not exist in the backup directory
+var ErrNotFound = errors.New("backup not found")
+
+// Snapshot describes a backup file
+type Snapshot struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Manager creates snapshots in a directory and keeps the newest of them
+type Manager struct {
+	database *db.DB
+
+	// mu serializes snapshots and guards the settings
+	mu        sync.Mutex
+	dir       string
+	retention int
+
+	// schedule guards the scheduled snapshot goroutine
+	schedule sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewManager creates a new Manager. A retention of zero or less keeps all snapshots.
+func NewManager(database *db.DB, dir string, retention int) *Manager {
+	return &Manager{
+		database:  database,
+		dir:       dir,
+		retention: retention,
+	}
+}
+
+// Create writes a new snapshot and removes the snapshots beyond the retention count
+func (m *Manager) Create() (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	name := filePrefix + now.UTC().Format(timeLayout) + fileSuffix
+	path := filepath.Join(m.dir, name)
+
+	// Snapshots taken within the same millisecond get a counter
+	for i := 1; fileExists(path); i++ {
+		name = fmt.Sprintf("%s%s-%d%s", filePrefix, now.UTC().Format(timeLayout), i, fileSuffix)
+		path = filepath.Join(m.dir, name)
+	}
+
+	if err := m.database.Backup(path); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup: %w", err)
+	}
+
+	if err := m.prune(); err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Name: name, Size: info.Size(), CreatedAt: info.ModTime().Unix()}, nil
+}
+
+// List returns the snapshots in the backup directory, newest first
+func (m *Manager) List() ([]*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.list()
+}
+
+// list returns the snapshots, the caller must hold mu
+func (m *Manager) list() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Snapshot{}, nil
+		}
+		return nil, fmt.Errorf("error reading backup directory: %w", err)
+	}
+
+	snapshots := []*Snapshot{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isSnapshotName(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup: %w", err)
+		}
+		snapshots = append(snapshots, &Snapshot{
+			Name:      entry.Name(),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime().Unix(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name > snapshots[j].Name
+	})
+	return snapshots, nil
+}
+
+// Path returns the file path of a snapshot
+func (m *Manager) Path(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Only plain snapshot names, nothing outside the backup directory
+	if name != filepath.Base(name) || !isSnapshotName(name) {
+		return "", ErrNotFound
+	}
+
+	path := filepath.Join(m.dir, name)
+	if !fileExists(path) {
+		return "", ErrNotFound
+	}
+	return path, nil
+}
+
+// Reconfigure replaces the backup directory and retention count and
+// restarts the scheduled snapshots with a new interval, zero disables them
+func (m *Manager) Reconfigure(dir string, retention int, interval time.Duration) {
+	m.Stop()
+
+	m.mu.Lock()
+	m.dir = dir
+	m.retention = retention
+	m.mu.Unlock()
+
+	if interval > 0 {
+		m.Start(interval)
+	}
+}
+
+// Start creates a snapshot at every interval until Stop is called
+func (m *Manager) Start(interval time.Duration) {
+	m.schedule.Lock()
+	defer m.schedule.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	m.stop, m.done = stop, done
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if snapshot, err := m.Create(); err != nil {
+					slog.Error("Scheduled backup failed", "error", err)
+				} else {
+					slog.Info("Scheduled backup written", "name", snapshot.Name, "size", snapshot.Size)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduled snapshots started by Start and waits for a running one to finish
+func (m *Manager) Stop() {
+	m.schedule.Lock()
+	defer m.schedule.Unlock()
+
+	if m.stop == nil {
+		return
+	}
+	close(m.stop)
+	<-m.done
+	m.stop, m.done = nil, nil
+}
+
+// prune removes the oldest snapshots beyond the retention count, the caller must hold mu
+func (m *Manager) prune() error {
+	if m.retention <= 0 {
+		return nil
+	}
+
+	snapshots, err := m.list()
+	if err != nil {
+		return err
+	}
+
+	for i := m.retention; i < len(snapshots); i++ {
+		if err := os.Remove(filepath.Join(m.dir, snapshots[i].Name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing old backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// isSnapshotName reports whether a file name is one of ours
+func isSnapshotName(name string) bool {
+	return strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix)
+}
+
+// fileExists reports whether a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
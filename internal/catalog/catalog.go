@@ -0,0 +1,308 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// ErrInvalidDocument is returned for documents that cannot be imported
+var ErrInvalidDocument = errors.New("invalid configuration document")
+
+// Service exports and imports the configuration document
+type Service struct {
+	store *store.Store
+
+	// mu serializes imports, each plans against the state the previous one left
+	mu sync.Mutex
+}
+
+// NewService creates a new Service
+func NewService(repositories *store.Store) *Service {
+	return &Service{
+		store: repositories,
+	}
+}
+
+// Export returns the stored configuration as a document
+func (s *Service) Export() (*Document, error) {
+	current, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{
+		Zones:       make(map[string]ZoneDocument, len(current.zones)),
+		DeviceTypes: make(map[string]DeviceTypeDocument, len(current.deviceTypes)),
+		Scenes:      []SceneDocument{},
+	}
+
+	zoneNames := make(map[int64]string, len(current.zones))
+	for name, zone := range current.zones {
+		zoneNames[zone.ID] = name
+		doc.Zones[name] = ZoneDocument{
+			Description: zone.Description,
+			Devices:     map[string]DeviceDocument{},
+		}
+	}
+
+	for _, alias := range current.aliasList {
+		name, ok := zoneNames[alias.ZoneID]
+		if !ok {
+			continue
+		}
+		zone := doc.Zones[name]
+		zone.Aliases = append(zone.Aliases, alias.Alias)
+		doc.Zones[name] = zone
+	}
+
+	deviceTypeNames := make(map[int64]string, len(current.deviceTypes))
+	for name, deviceType := range current.deviceTypes {
+		deviceTypeNames[deviceType.ID] = name
+		doc.DeviceTypes[name] = DeviceTypeDocument{
+			Description: deviceType.Description,
+			Operations:  map[string]string{},
+		}
+	}
+
+	operationNames := make(map[int64]string)
+	for deviceTypeID, operations := range current.operations {
+		deviceType, ok := doc.DeviceTypes[deviceTypeNames[deviceTypeID]]
+		if !ok {
+			continue
+		}
+		for name, operation := range operations {
+			operationNames[operation.ID] = name
+			deviceType.Operations[name] = operation.Description
+		}
+	}
+
+	for _, mapping := range current.mappings {
+		params, err := decodeObject(mapping.Params)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d has invalid params: %w", mapping.ID, err)
+		}
+		valueMapping, err := decodeObject(mapping.ValueMapping)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d has invalid value mapping: %w", mapping.ID, err)
+		}
+
+		zoneName, zoneOK := zoneNames[mapping.ZoneID]
+		deviceTypeName, deviceTypeOK := deviceTypeNames[mapping.DeviceTypeID]
+		operationName, operationOK := operationNames[mapping.OperationID]
+		if !zoneOK || !deviceTypeOK || !operationOK {
+			// Left behind by a delete without cascade, unreachable for commands
+			continue
+		}
+
+		zone := doc.Zones[zoneName]
+		device, ok := zone.Devices[deviceTypeName]
+		if !ok {
+			device = DeviceDocument{Operations: map[string]OperationDocument{}}
+			zone.Devices[deviceTypeName] = device
+		}
+		device.Operations[operationName] = OperationDocument{
+			Entity:       mapping.EntityID,
+			Service:      mapping.Service,
+			Params:       params,
+			ValueMapping: valueMapping,
+		}
+	}
+
+	for _, sceneID := range sortedKeys(current.scenes) {
+		scene := current.scenes[sceneID]
+		var actions []map[string]interface{}
+		if err := json.Unmarshal([]byte(scene.Actions), &actions); err != nil {
+			return nil, fmt.Errorf("scene %s has invalid actions: %w", scene.SceneID, err)
+		}
+		doc.Scenes = append(doc.Scenes, SceneDocument{
+			Name:        scene.Name,
+			SceneID:     scene.SceneID,
+			Description: scene.Description,
+			Actions:     actions,
+		})
+	}
+
+	return doc, nil
+}
+
+// Import applies a document in ModeMerge or ModeReplace. With dryRun
+// nothing is stored and the result lists the changes the import would make.
+// The document is checked completely before the first change is applied,
+// and the changes are applied in one transaction.
+func (s *Service) Import(doc *Document, mode string, dryRun bool) (*ImportResult, error) {
+	if mode != ModeMerge && mode != ModeReplace {
+		return nil, fmt.Errorf("%w: unknown mode %q, use %s or %s", ErrInvalidDocument, mode, ModeMerge, ModeReplace)
+	}
+	if err := validate(doc); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &plan{store: s.store, current: current, replace: mode == ModeReplace}
+	if err := p.build(doc); err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		// A failed step rolls back the steps before it
+		err := s.store.WithTx(func(tx *store.Store) error {
+			p.store = tx
+			for _, step := range p.steps() {
+				if err := step.apply(); err != nil {
+					return fmt.Errorf("failed to %s %s %s: %w", step.change.Action, step.change.Kind, step.change.Name, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ImportResult{Mode: mode, DryRun: dryRun, Changes: p.changes()}, nil
+}
+
+// validate checks a document on its own, without the stored configuration
+func validate(doc *Document) error {
+	aliasZones := make(map[string]string)
+	for zoneName, zone := range doc.Zones {
+		if zoneName == "" {
+			return fmt.Errorf("%w: zone without name", ErrInvalidDocument)
+		}
+
+		for _, alias := range zone.Aliases {
+			if alias == "" {
+				return fmt.Errorf("%w: empty alias in zone %s", ErrInvalidDocument, zoneName)
+			}
+			if _, ok := doc.Zones[alias]; ok {
+				return fmt.Errorf("%w: alias %s of zone %s is the name of a zone", ErrInvalidDocument, alias, zoneName)
+			}
+			if other, ok := aliasZones[alias]; ok {
+				return fmt.Errorf("%w: alias %s is used by zones %s and %s", ErrInvalidDocument, alias, other, zoneName)
+			}
+			aliasZones[alias] = zoneName
+		}
+
+		for deviceName, device := range zone.Devices {
+			if deviceName == "" {
+				return fmt.Errorf("%w: device without name in zone %s", ErrInvalidDocument, zoneName)
+			}
+			for operationName, operation := range device.Operations {
+				path := zoneName + "/" + deviceName + "/" + operationName
+				if operationName == "" {
+					return fmt.Errorf("%w: operation without name in %s/%s", ErrInvalidDocument, zoneName, deviceName)
+				}
+				if operation.Entity == "" || operation.Service == "" {
+					return fmt.Errorf("%w: %s needs an entity and a service", ErrInvalidDocument, path)
+				}
+				if _, err := encodeObject(operation.Params); err != nil {
+					return fmt.Errorf("%w: params of %s: %s", ErrInvalidDocument, path, err)
+				}
+				if _, err := encodeObject(operation.ValueMapping); err != nil {
+					return fmt.Errorf("%w: value mapping of %s: %s", ErrInvalidDocument, path, err)
+				}
+			}
+		}
+	}
+
+	for deviceName, deviceType := range doc.DeviceTypes {
+		if deviceName == "" {
+			return fmt.Errorf("%w: device type without name", ErrInvalidDocument)
+		}
+		if _, ok := deviceType.Operations[""]; ok {
+			return fmt.Errorf("%w: operation without name in device type %s", ErrInvalidDocument, deviceName)
+		}
+	}
+
+	sceneIDs := make(map[string]bool)
+	sceneNames := make(map[string]bool)
+	for _, scene := range doc.Scenes {
+		if scene.SceneID == "" || scene.Name == "" {
+			return fmt.Errorf("%w: scenes need a name and a scene_id", ErrInvalidDocument)
+		}
+		if sceneIDs[scene.SceneID] {
+			return fmt.Errorf("%w: duplicate scene_id %s", ErrInvalidDocument, scene.SceneID)
+		}
+		if sceneNames[scene.Name] {
+			return fmt.Errorf("%w: duplicate scene name %s", ErrInvalidDocument, scene.Name)
+		}
+		if _, err := json.Marshal(scene.Actions); err != nil {
+			return fmt.Errorf("%w: actions of scene %s: %s", ErrInvalidDocument, scene.SceneID, err)
+		}
+		sceneIDs[scene.SceneID] = true
+		sceneNames[scene.Name] = true
+	}
+
+	return nil
+}
+
+// encodeObject encodes a params or value mapping object as stored, empty objects as ""
+func encodeObject(object map[string]interface{}) (string, error) {
+	if len(object) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeObject decodes a stored params or value mapping object
+func decodeObject(data string) (map[string]interface{}, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &object); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// sameJSON reports whether two JSON documents are equal regardless of
+// formatting and key order. Empty objects and empty strings are equal.
+func sameJSON(a, b string) bool {
+	return normalizeJSON(a) == normalizeJSON(b)
+}
+
+// normalizeJSON re-encodes a JSON document, which sorts object keys
+func normalizeJSON(data string) string {
+	if data == "" {
+		return ""
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return data
+	}
+	if object, ok := value.(map[string]interface{}); ok && len(object) == 0 {
+		return ""
+	}
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	return string(normalized)
+}
+
+// sortedKeys returns the keys of a map in order, for a stable change list
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+// Package catalog exports the zone, device type, mapping and scene
+// configuration as a single document and imports it back, so a setup can be
+// kept in version control or copied to another installation.
+package catalog
+
+// Import modes
+const (
+	// ModeMerge creates and updates the entries of the document and keeps everything else
+	ModeMerge = "merge"
+	// ModeReplace makes the stored configuration match the document exactly
+	ModeReplace = "replace"
+)
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change kinds
+const (
+	KindZone       = "zone"
+	KindAlias      = "alias"
+	KindDeviceType = "device_type"
+	KindOperation  = "operation"
+	KindMapping    = "mapping"
+	KindScene      = "scene"
+)
+
+// Document is the nested zones → devices → operations mapping table of
+// design.md §5.4, extended with zone aliases, device type descriptions and scenes
+type Document struct {
+	Zones       map[string]ZoneDocument       `json:"zones" yaml:"zones"`
+	DeviceTypes map[string]DeviceTypeDocument `json:"device_types,omitempty" yaml:"device_types,omitempty"`
+	Scenes      []SceneDocument               `json:"scenes,omitempty" yaml:"scenes,omitempty"`
+}
+
+// ZoneDocument holds the devices of a zone
+type ZoneDocument struct {
+	Description string                    `json:"description,omitempty" yaml:"description,omitempty"`
+	Aliases     []string                  `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Devices     map[string]DeviceDocument `json:"devices" yaml:"devices"`
+}
+
+// DeviceDocument holds the operations of a device type within a zone
+type DeviceDocument struct {
+	Operations map[string]OperationDocument `json:"operations" yaml:"operations"`
+}
+
+// OperationDocument maps an operation to a Home Assistant service call
+type OperationDocument struct {
+	Entity       string                 `json:"entity" yaml:"entity"`
+	Service      string                 `json:"service" yaml:"service"`
+	Params       map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+	ValueMapping map[string]interface{} `json:"value_mapping,omitempty" yaml:"value_mapping,omitempty"`
+}
+
+// DeviceTypeDocument describes a device type and its operations. Device
+// types and operations used in zones need no entry here; it carries their
+// descriptions and those not mapped in any zone yet.
+type DeviceTypeDocument struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Operations maps operation names to their descriptions
+	Operations map[string]string `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// SceneDocument is a scene definition as in design.md §3.4.1
+type SceneDocument struct {
+	Name        string                   `json:"name" yaml:"name"`
+	SceneID     string                   `json:"scene_id" yaml:"scene_id"`
+	Description string                   `json:"description,omitempty" yaml:"description,omitempty"`
+	Actions     []map[string]interface{} `json:"actions" yaml:"actions"`
+}
+
+// Change is a single difference applied, or to be applied, by an import
+type Change struct {
+	Action string `json:"action"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+}
+
+// ImportResult lists the changes made by an import, or that a dry run would make
+type ImportResult struct {
+	Mode    string   `json:"mode"`
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
@@ -0,0 +1,537 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// state is the stored configuration an import is planned against
+type state struct {
+	zones       map[string]*store.Zone
+	aliases     map[string]*store.ZoneAlias
+	aliasList   []*store.ZoneAlias
+	deviceTypes map[string]*store.DeviceType
+	// operations are indexed by device type ID, then name
+	operations map[int64]map[string]*store.Operation
+	mappings   []*store.Mapping
+	scenes     map[string]*store.Scene
+}
+
+// mappingKey identifies a mapping by its zone, device type and operation names
+type mappingKey struct {
+	zone, deviceType, operation string
+}
+
+// String returns the key as zone/device type/operation
+func (k mappingKey) String() string {
+	return k.zone + "/" + k.deviceType + "/" + k.operation
+}
+
+// load reads the stored configuration
+func (s *Service) load() (*state, error) {
+	current := &state{
+		zones:       make(map[string]*store.Zone),
+		aliases:     make(map[string]*store.ZoneAlias),
+		deviceTypes: make(map[string]*store.DeviceType),
+		operations:  make(map[int64]map[string]*store.Operation),
+		scenes:      make(map[string]*store.Scene),
+	}
+
+	zones, err := s.store.Zones.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, zone := range zones {
+		current.zones[zone.Name] = zone
+	}
+
+	if current.aliasList, err = s.store.ZoneAliases.List(); err != nil {
+		return nil, err
+	}
+	for _, alias := range current.aliasList {
+		current.aliases[alias.Alias] = alias
+	}
+
+	deviceTypes, err := s.store.DeviceTypes.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, deviceType := range deviceTypes {
+		current.deviceTypes[deviceType.Name] = deviceType
+	}
+
+	operations, err := s.store.Operations.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, operation := range operations {
+		if current.operations[operation.DeviceTypeID] == nil {
+			current.operations[operation.DeviceTypeID] = make(map[string]*store.Operation)
+		}
+		current.operations[operation.DeviceTypeID][operation.Name] = operation
+	}
+
+	if current.mappings, err = s.store.Mappings.List(); err != nil {
+		return nil, err
+	}
+
+	scenes, err := s.store.Scenes.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, scene := range scenes {
+		current.scenes[scene.SceneID] = scene
+	}
+
+	return current, nil
+}
+
+// mappingKeys returns the names of the zone, device type and operation of
+// each stored mapping. Mappings referencing missing rows are left out.
+func (c *state) mappingKeys() map[int64]mappingKey {
+	zoneNames := make(map[int64]string)
+	for name, zone := range c.zones {
+		zoneNames[zone.ID] = name
+	}
+	deviceTypeNames := make(map[int64]string)
+	for name, deviceType := range c.deviceTypes {
+		deviceTypeNames[deviceType.ID] = name
+	}
+	operationNames := make(map[int64]string)
+	for _, operations := range c.operations {
+		for name, operation := range operations {
+			operationNames[operation.ID] = name
+		}
+	}
+
+	keys := make(map[int64]mappingKey, len(c.mappings))
+	for _, mapping := range c.mappings {
+		key := mappingKey{
+			zone:       zoneNames[mapping.ZoneID],
+			deviceType: deviceTypeNames[mapping.DeviceTypeID],
+			operation:  operationNames[mapping.OperationID],
+		}
+		if key.zone != "" && key.deviceType != "" && key.operation != "" {
+			keys[mapping.ID] = key
+		}
+	}
+	return keys
+}
+
+// step is a planned change and the function that applies it
+type step struct {
+	change Change
+	apply  func() error
+}
+
+// plan turns a document into the steps that make the stored configuration
+// match it. Records created by earlier steps are shared by pointer, so later
+// steps see their IDs once they have been applied.
+type plan struct {
+	store   *store.Store
+	current *state
+	replace bool
+	now     int64
+
+	deletes []step
+	upserts []step
+
+	// What the document keeps, for the deletes of replace mode
+	zones       map[string]*store.Zone
+	aliases     map[string]bool
+	deviceTypes map[string]*store.DeviceType
+	operations  map[string]map[string]*store.Operation
+	mappings    map[mappingKey]bool
+	scenes      map[string]bool
+}
+
+// add records a step
+func (p *plan) add(steps *[]step, action, kind, name string, apply func() error) {
+	*steps = append(*steps, step{change: Change{Action: action, Kind: kind, Name: name}, apply: apply})
+}
+
+// steps returns the planned steps in the order they are applied. Deletes
+// come first so that names they free can be reused by the upserts.
+func (p *plan) steps() []step {
+	steps := make([]step, 0, len(p.deletes)+len(p.upserts))
+	steps = append(steps, p.deletes...)
+	return append(steps, p.upserts...)
+}
+
+// changes returns the planned changes in the order they are applied
+func (p *plan) changes() []Change {
+	changes := []Change{}
+	for _, s := range p.steps() {
+		changes = append(changes, s.change)
+	}
+	return changes
+}
+
+// build plans the import of a validated document
+func (p *plan) build(doc *Document) error {
+	p.now = time.Now().Unix()
+	p.zones = make(map[string]*store.Zone)
+	p.aliases = make(map[string]bool)
+	p.deviceTypes = make(map[string]*store.DeviceType)
+	p.operations = make(map[string]map[string]*store.Operation)
+	p.mappings = make(map[mappingKey]bool)
+	p.scenes = make(map[string]bool)
+
+	p.planDeviceTypes(doc)
+	if err := p.planZones(doc); err != nil {
+		return err
+	}
+	if err := p.planMappings(doc); err != nil {
+		return err
+	}
+	if err := p.planScenes(doc); err != nil {
+		return err
+	}
+	if p.replace {
+		p.planDeletes()
+	}
+	return nil
+}
+
+// planDeviceTypes plans the device types and operations of the device_types
+// section and of the zones
+func (p *plan) planDeviceTypes(doc *Document) {
+	wanted := make(map[string]map[string]bool)
+	want := func(deviceType, operation string) {
+		if wanted[deviceType] == nil {
+			wanted[deviceType] = make(map[string]bool)
+		}
+		if operation != "" {
+			wanted[deviceType][operation] = true
+		}
+	}
+	for name, deviceTypeDoc := range doc.DeviceTypes {
+		want(name, "")
+		for operation := range deviceTypeDoc.Operations {
+			want(name, operation)
+		}
+	}
+	for _, zoneDoc := range doc.Zones {
+		for name, deviceDoc := range zoneDoc.Devices {
+			want(name, "")
+			for operation := range deviceDoc.Operations {
+				want(name, operation)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(wanted) {
+		deviceTypeDoc, described := doc.DeviceTypes[name]
+
+		deviceType := p.current.deviceTypes[name]
+		switch {
+		case deviceType == nil:
+			deviceType = &store.DeviceType{Name: name, Description: deviceTypeDoc.Description, CreatedAt: p.now, UpdatedAt: p.now}
+			created := deviceType
+			p.add(&p.upserts, ActionCreate, KindDeviceType, name, func() error {
+				return p.store.DeviceTypes.Create(created)
+			})
+		case described && deviceType.Description != deviceTypeDoc.Description:
+			updated := *deviceType
+			updated.Description = deviceTypeDoc.Description
+			updated.UpdatedAt = p.now
+			p.add(&p.upserts, ActionUpdate, KindDeviceType, name, func() error {
+				return p.store.DeviceTypes.Update(&updated)
+			})
+		}
+		p.deviceTypes[name] = deviceType
+		p.operations[name] = make(map[string]*store.Operation)
+
+		owner := deviceType
+		for _, operationName := range sortedKeys(wanted[name]) {
+			description, operationDescribed := deviceTypeDoc.Operations[operationName]
+
+			operation := p.current.operations[deviceType.ID][operationName]
+			switch {
+			case operation == nil:
+				operation = &store.Operation{Name: operationName, Description: description, CreatedAt: p.now, UpdatedAt: p.now}
+				created := operation
+				p.add(&p.upserts, ActionCreate, KindOperation, name+"/"+operationName, func() error {
+					created.DeviceTypeID = owner.ID
+					return p.store.Operations.Create(created)
+				})
+			case operationDescribed && operation.Description != description:
+				updated := *operation
+				updated.Description = description
+				updated.UpdatedAt = p.now
+				p.add(&p.upserts, ActionUpdate, KindOperation, name+"/"+operationName, func() error {
+					return p.store.Operations.Update(&updated)
+				})
+			}
+			p.operations[name][operationName] = operation
+		}
+	}
+}
+
+// planZones plans the zones and their aliases
+func (p *plan) planZones(doc *Document) error {
+	for _, name := range sortedKeys(doc.Zones) {
+		zoneDoc := doc.Zones[name]
+
+		// Merge mode never deletes aliases, so the name must not be one
+		if _, ok := p.current.aliases[name]; ok && !p.replace {
+			return fmt.Errorf("%w: zone %s is an alias of another zone", ErrInvalidDocument, name)
+		}
+
+		zone := p.current.zones[name]
+		switch {
+		case zone == nil:
+			zone = &store.Zone{Name: name, Description: zoneDoc.Description, CreatedAt: p.now, UpdatedAt: p.now}
+			created := zone
+			p.add(&p.upserts, ActionCreate, KindZone, name, func() error {
+				return p.store.Zones.Create(created)
+			})
+		case zone.Description != zoneDoc.Description:
+			updated := *zone
+			updated.Description = zoneDoc.Description
+			updated.UpdatedAt = p.now
+			p.add(&p.upserts, ActionUpdate, KindZone, name, func() error {
+				return p.store.Zones.Update(&updated)
+			})
+		}
+		p.zones[name] = zone
+
+		aliases := append([]string(nil), zoneDoc.Aliases...)
+		sort.Strings(aliases)
+		owner := zone
+		for _, alias := range aliases {
+			if _, ok := p.current.zones[alias]; ok && !p.replace {
+				return fmt.Errorf("%w: alias %s of zone %s is the name of another zone", ErrInvalidDocument, alias, name)
+			}
+			p.aliases[alias] = true
+
+			created := &store.ZoneAlias{Alias: alias, CreatedAt: p.now}
+			create := func() error {
+				created.ZoneID = owner.ID
+				return p.store.ZoneAliases.Create(created)
+			}
+
+			existing := p.current.aliases[alias]
+			switch {
+			case existing == nil:
+				p.add(&p.upserts, ActionCreate, KindAlias, name+"/"+alias, create)
+			case zone.ID == 0 || existing.ZoneID != zone.ID:
+				// Move the alias from its current zone, which replace mode may already have deleted
+				moved := existing.ID
+				p.add(&p.upserts, ActionUpdate, KindAlias, name+"/"+alias, func() error {
+					if err := p.store.ZoneAliases.Delete(moved); err != nil && err != store.ErrNotFound {
+						return err
+					}
+					return create()
+				})
+			}
+		}
+	}
+	return nil
+}
+
+// planMappings plans the mappings of the zones
+func (p *plan) planMappings(doc *Document) error {
+	keys := p.current.mappingKeys()
+	existing := make(map[mappingKey]*store.Mapping, len(keys))
+	for _, mapping := range p.current.mappings {
+		if key, ok := keys[mapping.ID]; ok {
+			existing[key] = mapping
+		}
+	}
+
+	for _, zoneName := range sortedKeys(doc.Zones) {
+		zoneDoc := doc.Zones[zoneName]
+		for _, deviceName := range sortedKeys(zoneDoc.Devices) {
+			deviceDoc := zoneDoc.Devices[deviceName]
+			for _, operationName := range sortedKeys(deviceDoc.Operations) {
+				operationDoc := deviceDoc.Operations[operationName]
+				key := mappingKey{zone: zoneName, deviceType: deviceName, operation: operationName}
+				p.mappings[key] = true
+
+				params, err := encodeObject(operationDoc.Params)
+				if err != nil {
+					return fmt.Errorf("%w: params of %s: %s", ErrInvalidDocument, key, err)
+				}
+				valueMapping, err := encodeObject(operationDoc.ValueMapping)
+				if err != nil {
+					return fmt.Errorf("%w: value mapping of %s: %s", ErrInvalidDocument, key, err)
+				}
+
+				mapping := existing[key]
+				if mapping == nil {
+					zone := p.zones[zoneName]
+					deviceType := p.deviceTypes[deviceName]
+					operation := p.operations[deviceName][operationName]
+					created := &store.Mapping{
+						EntityID:     operationDoc.Entity,
+						Service:      operationDoc.Service,
+						Params:       params,
+						ValueMapping: valueMapping,
+						CreatedAt:    p.now,
+						UpdatedAt:    p.now,
+					}
+					p.add(&p.upserts, ActionCreate, KindMapping, key.String(), func() error {
+						created.ZoneID = zone.ID
+						created.DeviceTypeID = deviceType.ID
+						created.OperationID = operation.ID
+						return p.store.Mappings.Create(created)
+					})
+					continue
+				}
+
+				if mapping.EntityID != operationDoc.Entity || mapping.Service != operationDoc.Service ||
+					!sameJSON(mapping.Params, params) || !sameJSON(mapping.ValueMapping, valueMapping) {
+					updated := *mapping
+					updated.EntityID = operationDoc.Entity
+					updated.Service = operationDoc.Service
+					updated.Params = params
+					updated.ValueMapping = valueMapping
+					updated.UpdatedAt = p.now
+					p.add(&p.upserts, ActionUpdate, KindMapping, key.String(), func() error {
+						return p.store.Mappings.Update(&updated)
+					})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// planScenes plans the scenes, matched by scene ID
+func (p *plan) planScenes(doc *Document) error {
+	sceneIDs := make(map[string]bool, len(doc.Scenes))
+	for _, sceneDoc := range doc.Scenes {
+		sceneIDs[sceneDoc.SceneID] = true
+	}
+
+	for _, sceneDoc := range doc.Scenes {
+		p.scenes[sceneDoc.SceneID] = true
+
+		// Scene names are unique too, another scene kept by the import must not have it
+		for _, other := range p.current.scenes {
+			if other.Name == sceneDoc.Name && other.SceneID != sceneDoc.SceneID && (!p.replace || sceneIDs[other.SceneID]) {
+				return fmt.Errorf("%w: scene name %s is used by scene %s", ErrInvalidDocument, sceneDoc.Name, other.SceneID)
+			}
+		}
+
+		actions := sceneDoc.Actions
+		if actions == nil {
+			actions = []map[string]interface{}{}
+		}
+		data, err := json.Marshal(actions)
+		if err != nil {
+			return fmt.Errorf("%w: actions of scene %s: %s", ErrInvalidDocument, sceneDoc.SceneID, err)
+		}
+
+		scene := p.current.scenes[sceneDoc.SceneID]
+		switch {
+		case scene == nil:
+			created := &store.Scene{
+				Name:        sceneDoc.Name,
+				SceneID:     sceneDoc.SceneID,
+				Description: sceneDoc.Description,
+				Actions:     string(data),
+				CreatedAt:   p.now,
+				UpdatedAt:   p.now,
+			}
+			p.add(&p.upserts, ActionCreate, KindScene, sceneDoc.SceneID, func() error {
+				return p.store.Scenes.Create(created)
+			})
+		case scene.Name != sceneDoc.Name || scene.Description != sceneDoc.Description || !sameJSON(scene.Actions, string(data)):
+			updated := *scene
+			updated.Name = sceneDoc.Name
+			updated.Description = sceneDoc.Description
+			updated.Actions = string(data)
+			updated.UpdatedAt = p.now
+			p.add(&p.upserts, ActionUpdate, KindScene, sceneDoc.SceneID, func() error {
+				return p.store.Scenes.Update(&updated)
+			})
+		}
+	}
+	return nil
+}
+
+// planDeletes plans the deletes of replace mode: everything the document
+// does not keep. Dependants are deleted before what they reference, so no
+// delete removes more than the change list shows.
+func (p *plan) planDeletes() {
+	keys := p.current.mappingKeys()
+	for _, mapping := range p.current.mappings {
+		key, ok := keys[mapping.ID]
+		if ok && p.mappings[key] {
+			continue
+		}
+
+		name := key.String()
+		if !ok {
+			name = fmt.Sprintf("#%d", mapping.ID)
+		}
+		id := mapping.ID
+		p.add(&p.deletes, ActionDelete, KindMapping, name, func() error {
+			return p.store.Mappings.Delete(id)
+		})
+	}
+
+	zoneNames := make(map[int64]string)
+	for name, zone := range p.current.zones {
+		zoneNames[zone.ID] = name
+	}
+	for _, alias := range p.current.aliasList {
+		if p.aliases[alias.Alias] {
+			continue
+		}
+		id := alias.ID
+		p.add(&p.deletes, ActionDelete, KindAlias, zoneNames[alias.ZoneID]+"/"+alias.Alias, func() error {
+			return p.store.ZoneAliases.Delete(id)
+		})
+	}
+
+	for _, name := range sortedKeys(p.current.zones) {
+		if _, ok := p.zones[name]; ok {
+			continue
+		}
+		id := p.current.zones[name].ID
+		p.add(&p.deletes, ActionDelete, KindZone, name, func() error {
+			return p.store.Zones.Delete(id)
+		})
+	}
+
+	for _, deviceTypeName := range sortedKeys(p.current.deviceTypes) {
+		deviceType := p.current.deviceTypes[deviceTypeName]
+		operations := p.current.operations[deviceType.ID]
+		for _, operationName := range sortedKeys(operations) {
+			if _, ok := p.operations[deviceTypeName][operationName]; ok {
+				continue
+			}
+			id := operations[operationName].ID
+			p.add(&p.deletes, ActionDelete, KindOperation, deviceTypeName+"/"+operationName, func() error {
+				return p.store.Operations.Delete(id)
+			})
+		}
+	}
+
+	for _, name := range sortedKeys(p.current.deviceTypes) {
+		if _, ok := p.deviceTypes[name]; ok {
+			continue
+		}
+		id := p.current.deviceTypes[name].ID
+		p.add(&p.deletes, ActionDelete, KindDeviceType, name, func() error {
+			return p.store.DeviceTypes.Delete(id)
+		})
+	}
+
+	for _, sceneID := range sortedKeys(p.current.scenes) {
+		if p.scenes[sceneID] {
+			continue
+		}
+		id := p.current.scenes[sceneID].ID
+		p.add(&p.deletes, ActionDelete, KindScene, sceneID, func() error {
+			return p.store.Scenes.Delete(id)
+		})
+	}
+}
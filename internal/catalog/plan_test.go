@@ -0,0 +1,190 @@
+package catalog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+	"github.com/boringsoft/ha-mi/internal/store/memstore"
+)
+
+// baseDocument is the configuration the import tests start from
+func baseDocument() *Document {
+	return &Document{
+		Zones: map[string]ZoneDocument{
+			"客厅": {
+				Description: "living room",
+				Aliases:     []string{"大厅"},
+				Devices: map[string]DeviceDocument{
+					"light": {Operations: map[string]OperationDocument{
+						"turn_on": {Entity: "light.living_room", Service: "light.turn_on", Params: map[string]interface{}{"brightness": 255}},
+					}},
+				},
+			},
+			"卧室": {
+				Devices: map[string]DeviceDocument{
+					"light": {Operations: map[string]OperationDocument{
+						"turn_on": {Entity: "light.bedroom", Service: "light.turn_on"},
+					}},
+				},
+			},
+		},
+		Scenes: []SceneDocument{
+			{Name: "回家", SceneID: "home", Actions: []map[string]interface{}{
+				{"zone": "客厅", "device": "light", "operation": "turn_on"},
+			}},
+		},
+	}
+}
+
+// importedDocument changes the description of 客厅, adds 书房 and leaves out 卧室
+func importedDocument() *Document {
+	doc := baseDocument()
+	delete(doc.Zones, "卧室")
+
+	livingRoom := doc.Zones["客厅"]
+	livingRoom.Description = "lounge"
+	doc.Zones["客厅"] = livingRoom
+
+	doc.Zones["书房"] = ZoneDocument{
+		Devices: map[string]DeviceDocument{
+			"light": {Operations: map[string]OperationDocument{
+				"turn_on": {Entity: "light.study", Service: "light.turn_on"},
+			}},
+		},
+	}
+	return doc
+}
+
+func TestImport(t *testing.T) {
+	mergeChanges := []Change{
+		{ActionCreate, KindZone, "书房"},
+		{ActionUpdate, KindZone, "客厅"},
+		{ActionCreate, KindMapping, "书房/light/turn_on"},
+	}
+	replaceChanges := append([]Change{
+		{ActionDelete, KindMapping, "卧室/light/turn_on"},
+		{ActionDelete, KindZone, "卧室"},
+	}, mergeChanges...)
+
+	tests := []struct {
+		name        string
+		mode        string
+		dryRun      bool
+		wantChanges []Change
+		wantZones   []string
+	}{
+		{"merge", ModeMerge, false, mergeChanges, []string{"书房", "卧室", "客厅"}},
+		{"replace", ModeReplace, false, replaceChanges, []string{"书房", "客厅"}},
+		{"merge dry run", ModeMerge, true, mergeChanges, []string{"卧室", "客厅"}},
+		{"replace dry run", ModeReplace, true, replaceChanges, []string{"卧室", "客厅"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, memstore.New())
+			before := mustExport(t, s)
+
+			result, err := s.Import(importedDocument(), tt.mode, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Import() error = %v", err)
+			}
+			if !reflect.DeepEqual(result.Changes, tt.wantChanges) {
+				t.Errorf("changes = %v, want %v", result.Changes, tt.wantChanges)
+			}
+
+			after := mustExport(t, s)
+			if got := sortedKeys(after.Zones); !reflect.DeepEqual(got, tt.wantZones) {
+				t.Errorf("zones = %v, want %v", got, tt.wantZones)
+			}
+			if tt.dryRun {
+				if !reflect.DeepEqual(after, before) {
+					t.Errorf("dry run changed the configuration to %+v", after)
+				}
+				return
+			}
+
+			if got := after.Zones["客厅"].Description; got != "lounge" {
+				t.Errorf("description of 客厅 = %q, want lounge", got)
+			}
+			if len(after.Scenes) != 1 || after.Scenes[0].SceneID != "home" {
+				t.Errorf("scenes = %+v, want the home scene", after.Scenes)
+			}
+
+			// The stored configuration now matches, importing again changes nothing
+			again, err := s.Import(importedDocument(), tt.mode, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(again.Changes) != 0 {
+				t.Errorf("second import changes = %v, want none", again.Changes)
+			}
+		})
+	}
+}
+
+func TestImportRollsBackOnFailure(t *testing.T) {
+	errFailed := errors.New("scene create failed")
+
+	repositories := memstore.New()
+	s := newTestService(t, repositories)
+	before := mustExport(t, s)
+
+	// Fail the scene create, the last step, after the zone and mapping steps succeeded
+	repositories.Transactor = failingTransactor{Transactor: repositories.Transactor, err: errFailed}
+
+	doc := importedDocument()
+	doc.Scenes = append(doc.Scenes, SceneDocument{Name: "晚安", SceneID: "good_night", Actions: []map[string]interface{}{}})
+
+	if _, err := s.Import(doc, ModeReplace, false); !errors.Is(err, errFailed) {
+		t.Fatalf("Import() error = %v, want %v", err, errFailed)
+	}
+	if after := mustExport(t, s); !reflect.DeepEqual(after, before) {
+		t.Errorf("failed import left the configuration at %+v, want %+v", after, before)
+	}
+}
+
+// newTestService returns a Service on repositories holding baseDocument
+func newTestService(t *testing.T, repositories *store.Store) *Service {
+	t.Helper()
+
+	s := NewService(repositories)
+	if _, err := s.Import(baseDocument(), ModeReplace, false); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func mustExport(t *testing.T, s *Service) *Document {
+	t.Helper()
+
+	doc, err := s.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// failingTransactor runs transactions whose scene creates fail with err
+type failingTransactor struct {
+	store.Transactor
+	err error
+}
+
+func (f failingTransactor) WithTx(fn func(tx *store.Store) error) error {
+	return f.Transactor.WithTx(func(tx *store.Store) error {
+		tx.Scenes = failingScenes{SceneRepository: tx.Scenes, err: f.err}
+		return fn(tx)
+	})
+}
+
+// failingScenes is a scene repository whose Create fails
+type failingScenes struct {
+	store.SceneRepository
+	err error
+}
+
+func (f failingScenes) Create(*store.Scene) error {
+	return f.err
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -18,12 +20,16 @@ type Config struct {
 	Auth          AuthConfig     `json:"auth" yaml:"auth"`
 	Database      DatabaseConfig `json:"database" yaml:"database"`
 	HomeAssistant HAConfig       `json:"home_assistant" yaml:"home_assistant"`
+	Log           LogConfig      `json:"log" yaml:"log"`
+	Metrics       MetricsConfig  `json:"metrics" yaml:"metrics"`
 }
 
 // ServerConfig holds server-related configuration
 type ServerConfig struct {
-	Host string `json:"host" yaml:"host"`
-	Port int    `json:"port" yaml:"port"`
+	Host        string `json:"host" yaml:"host"`
+	Port        int    `json:"port" yaml:"port"`
+	Mode        string `json:"mode" yaml:"mode"`
+	MaxBodySize int    `json:"max_body_size" yaml:"max_body_size"`
 }
 
 // AuthConfig holds authentication-related configuration
@@ -31,14 +37,36 @@ type AuthConfig struct {
 	User               string        `json:"user" yaml:"user"`
 	Password           string        `json:"password" yaml:"password"`
 	SecretKey          string        `json:"secret_key" yaml:"secret_key"`
-	AccessTokenExpiry  time.Duration `json:"access_token_expiry" yaml:"access_token_expiry"`
-	RefreshTokenExpiry time.Duration `json:"refresh_token_expiry" yaml:"refresh_token_expiry"`
-	NonceExpiry        time.Duration `json:"nonce_expiry" yaml:"nonce_expiry"`
+	RequestSigningKey  string        `json:"request_signing_key" yaml:"request_signing_key"`
+	AllowSignV1        bool          `json:"allow_sign_v1" yaml:"allow_sign_v1"`
+	JWTAlgorithm       string        `json:"jwt_algorithm" yaml:"jwt_algorithm"`
+	JWTKeyFile         string        `json:"jwt_key_file" yaml:"jwt_key_file"`
+	AccessTokenExpiry  Duration      `json:"access_token_expiry" yaml:"access_token_expiry"`
+	RefreshTokenExpiry Duration      `json:"refresh_token_expiry" yaml:"refresh_token_expiry"`
+	NonceExpiry        Duration      `json:"nonce_expiry" yaml:"nonce_expiry"`
+	Lockout            LockoutConfig `json:"lockout" yaml:"lockout"`
+}
+
+// LockoutConfig holds login brute-force protection configuration
+type LockoutConfig struct {
+	MaxFailures  int      `json:"max_failures" yaml:"max_failures"`
+	Window       Duration `json:"window" yaml:"window"`
+	BaseDuration Duration `json:"base_duration" yaml:"base_duration"`
+	MaxDuration  Duration `json:"max_duration" yaml:"max_duration"`
 }
 
 // DatabaseConfig holds database-related configuration
 type DatabaseConfig struct {
-	Path string `json:"path" yaml:"path"`
+	Driver string       `json:"driver" yaml:"driver"`
+	Path   string       `json:"path" yaml:"path"`
+	Backup BackupConfig `json:"backup" yaml:"backup"`
+}
+
+// BackupConfig holds database snapshot configuration
+type BackupConfig struct {
+	Dir       string   `json:"dir" yaml:"dir"`
+	Interval  Duration `json:"interval" yaml:"interval"`
+	Retention int      `json:"retention" yaml:"retention"`
 }
 
 // HAConfig holds Home Assistant connection configuration
@@ -47,85 +75,165 @@ type HAConfig struct {
 	Token string `json:"token" yaml:"token"`
 }
 
+// SigningKey returns the key requests are signed with. Config files from
+// before request_signing_key do not set it and keep signing with secret_key,
+// a deprecated fallback reported by Config.Warnings.
+func (a AuthConfig) SigningKey() string {
+	if a.RequestSigningKey == "" {
+		return a.SecretKey
+	}
+	return a.RequestSigningKey
+}
+
+// MetricsConfig holds Prometheus metrics configuration
+type MetricsConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+}
+
+// LogConfig holds logging configuration
+type LogConfig struct {
+	Level  string `json:"level" yaml:"level"`
+	Format string `json:"format" yaml:"format"`
+}
+
 var (
 	instance *Config
 	once     sync.Once
 )
 
-// LoadConfig loads configuration from the config file
-func LoadConfig(configPath string) (*Config, error) {
-	var err error
-	once.Do(func() {
-		instance = &Config{
-			Server: ServerConfig{
-				Host: "0.0.0.0",
-				Port: 8080,
-			},
-			Auth: AuthConfig{
-				User:               "admin",
-				Password:           "admin",
-				SecretKey:          "change-me-in-production-please",
-				AccessTokenExpiry:  24 * time.Hour,      // 24 hours
-				RefreshTokenExpiry: 30 * 24 * time.Hour, // 30 days
-				NonceExpiry:        2 * time.Minute,     // 2 minutes
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:        "0.0.0.0",
+			Port:        8080,
+			Mode:        ModeDevelopment,
+			MaxBodySize: 1 << 20,
+		},
+		Auth: AuthConfig{
+			User:               "admin",
+			Password:           defaultPassword,
+			SecretKey:          defaultSecretKey,
+			RequestSigningKey:  "",
+			AllowSignV1:        true,
+			JWTAlgorithm:       "HS256",
+			AccessTokenExpiry:  Duration(24 * time.Hour),
+			RefreshTokenExpiry: Duration(30 * day),
+			NonceExpiry:        Duration(2 * time.Minute),
+			Lockout: LockoutConfig{
+				MaxFailures:  5,
+				Window:       Duration(15 * time.Minute),
+				BaseDuration: Duration(time.Minute),
+				MaxDuration:  Duration(time.Hour),
 			},
-			Database: DatabaseConfig{
-				Path: "ha-mi.db",
+		},
+		Database: DatabaseConfig{
+			Path: "ha-mi.db",
+			Backup: BackupConfig{
+				Dir:       "backups",
+				Retention: 7,
 			},
-			HomeAssistant: HAConfig{
-				URL:   "http://localhost:8123",
-				Token: "",
-			},
-		}
+		},
+		HomeAssistant: HAConfig{
+			URL:   "http://localhost:8123",
+			Token: "",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+		},
+	}
+}
 
-		// If config file exists, load it
+// LoadConfig loads configuration once and returns the same instance on later
+// calls. Settings are layered: the defaults, then the config file, then the
+// HAMI_* environment variables. A missing config file is created with the
+// defaults only, so secrets passed in the environment never end up in it.
+func LoadConfig(configPath string) (*Config, error) {
+	var err error
+	once.Do(func() {
+		instance = Default()
 		if configPath != "" {
-			absPath, err := filepath.Abs(configPath)
-			if err != nil {
-				err = fmt.Errorf("error getting absolute path: %w", err)
-				return
-			}
+			err = decodeFile(configPath, instance)
+			if errors.Is(err, os.ErrNotExist) {
+				// New installs sign requests with their own key from the start
+				instance.Auth.RequestSigningKey = defaultRequestSigningKey
 
-			file, err := os.Open(absPath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					// Create default config file based on file extension
-					saveErr := SaveConfig(configPath, instance)
-					if saveErr != nil {
-						err = fmt.Errorf("error creating default config: %w", saveErr)
-					} else {
-						err = nil
-					}
-					return
+				// Create default config file based on file extension
+				if saveErr := SaveConfig(configPath, instance); saveErr != nil {
+					err = fmt.Errorf("error creating default config: %w", saveErr)
+				} else {
+					err = nil
 				}
-				err = fmt.Errorf("error opening config file: %w", err)
-				return
 			}
-			defer file.Close()
-
-			// Determine file format based on extension
-			ext := strings.ToLower(filepath.Ext(configPath))
-			switch ext {
-			case ".json":
-				decoder := json.NewDecoder(file)
-				err = decoder.Decode(instance)
-			case ".yaml", ".yml":
-				decoder := yaml.NewDecoder(file)
-				err = decoder.Decode(instance)
-			default:
-				err = fmt.Errorf("unsupported config file format: %s, supported formats are: .json, .yaml, .yml", ext)
-			}
-
 			if err != nil {
-				err = fmt.Errorf("error decoding config file: %w", err)
 				return
 			}
 		}
+
+		err = applyEnv(instance, os.LookupEnv)
 	})
 
 	return instance, err
 }
 
+// Load reads a config file and the environment on top of the defaults into a
+// new Config. Unlike LoadConfig it neither caches the result nor creates a missing file.
+func Load(configPath string) (*Config, error) {
+	cfg, err := LoadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile reads a config file on top of the defaults, without the
+// environment overrides. Use it to change and save the file, so secrets
+// from the environment are not written into it.
+func LoadFile(configPath string) (*Config, error) {
+	cfg := Default()
+	if err := decodeFile(configPath, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// decodeFile decodes a YAML or JSON config file, chosen by extension, into cfg
+func decodeFile(configPath string, cfg *Config) error {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("error getting absolute path: %w", err)
+	}
+
+	file, err := os.Open(absPath)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+	defer file.Close()
+
+	// Determine file format based on extension
+	ext := strings.ToLower(filepath.Ext(configPath))
+	switch ext {
+	case ".json":
+		err = json.NewDecoder(file).Decode(cfg)
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(file).Decode(cfg)
+	default:
+		return fmt.Errorf("unsupported config file format: %s, supported formats are: .json, .yaml, .yml", ext)
+	}
+
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("error decoding config file: %w", err)
+	}
+	return nil
+}
+
 // SaveConfig saves the configuration to a file
 func SaveConfig(configPath string, cfg *Config) error {
 	absPath, err := filepath.Abs(configPath)
@@ -139,7 +247,8 @@ func SaveConfig(configPath string, cfg *Config) error {
 		return fmt.Errorf("error creating directory: %w", err)
 	}
 
-	file, err := os.Create(absPath)
+	// The file holds secrets, only the owner may read it
+	file, err := os.OpenFile(absPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return fmt.Errorf("error creating config file: %w", err)
 	}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// day is the length of the d unit, durations in config files ignore DST
+const day = 24 * time.Hour
+
+// durationPattern matches the duration strings accepted in config files:
+// Go durations such as 2m or 1h30m, optionally led by whole days as in 30d or 1d12h
+var durationPattern = regexp.MustCompile(`^(\d+)d(.*)$`)
+
+// Duration is a time.Duration written as a readable string such as 24h, 30d
+// or 2m in config files. Plain integers are read as nanoseconds, the format
+// of older config files.
+type Duration time.Duration
+
+// Duration returns d as a time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String formats d in a short form ParseDuration reads back: whole days
+// beyond the first as 30d, anything else as 24h, 2m or 1h30m
+func (d Duration) String() string {
+	td := time.Duration(d)
+	if td > day && td%day == 0 {
+		return fmt.Sprintf("%dd", td/day)
+	}
+
+	// time.Duration.String pads with zero minutes and seconds, as in 1h0m0s
+	s := td.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// ParseDuration parses a config duration: a Go duration string, optionally
+// led by whole days (30d, 1d12h), or an integer number of nanoseconds
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Duration(n), nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	rest := strings.TrimPrefix(s, "-")
+
+	var d time.Duration
+	if m := durationPattern.FindStringSubmatch(rest); m != nil {
+		days, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		d, rest = time.Duration(days)*day, m[2]
+		if rest == "" {
+			return signed(d, negative), nil
+		}
+	}
+
+	parsed, err := time.ParseDuration(rest)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid duration %q, use e.g. 30d, 24h, 2m or 90s", s)
+	}
+	return signed(d+parsed, negative), nil
+}
+
+// signed negates d for durations written with a leading minus
+func signed(d time.Duration, negative bool) Duration {
+	if negative {
+		return Duration(-d)
+	}
+	return Duration(d)
+}
+
+// MarshalJSON writes d as a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid duration %s, use a string such as \"24h\"", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalYAML writes d as a duration string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML reads a duration string or a number of nanoseconds
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: invalid duration, use a string such as 24h", value.Line)
+	}
+
+	parsed, err := ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Duration
+		wantErr bool
+	}{
+		{"2m", Duration(2 * time.Minute), false},
+		{"90s", Duration(90 * time.Second), false},
+		{"1h30m", Duration(90 * time.Minute), false},
+		{"24h", Duration(24 * time.Hour), false},
+		{"30d", Duration(30 * day), false},
+		{"1d12h", Duration(36 * time.Hour), false},
+		{"-2m", Duration(-2 * time.Minute), false},
+		{"-1d", Duration(-day), false},
+		{" 24h ", Duration(24 * time.Hour), false},
+		// Integers are nanoseconds, as written by older versions
+		{"86400000000000", Duration(24 * time.Hour), false},
+		{"24", Duration(24), false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"   ", 0, true},
+		{"-", 0, true},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"30 days", 0, true},
+		{"1d-1h", 0, true},
+		{"1w", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDuration(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDurationString(t *testing.T) {
+	tests := []struct {
+		d    Duration
+		want string
+	}{
+		{Duration(2 * time.Minute), "2m"},
+		{Duration(90 * time.Second), "1m30s"},
+		{Duration(time.Hour), "1h"},
+		{Duration(90 * time.Minute), "1h30m"},
+		{Duration(day), "24h"},
+		{Duration(36 * time.Hour), "36h"},
+		{Duration(30 * day), "30d"},
+		{0, "0s"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("Duration(%d).String() = %s, want %s", tt.d, got, tt.want)
+		}
+		// Saved files must load back to the same value
+		if parsed, err := ParseDuration(tt.d.String()); err != nil || parsed != tt.d {
+			t.Errorf("ParseDuration(%q) = %d, %v, want %d", tt.d.String(), parsed, err, tt.d)
+		}
+	}
+}
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		json    string
+		want    Duration
+		wantErr bool
+	}{
+		{"string", `24h`, `"24h"`, Duration(24 * time.Hour), false},
+		{"days", `30d`, `"30d"`, Duration(30 * day), false},
+		{"legacy nanoseconds", `86400000000000`, `86400000000000`, Duration(24 * time.Hour), false},
+		{"empty string", `""`, `""`, 0, true},
+		{"invalid", `soon`, `"soon"`, 0, true},
+		{"not a scalar", `[24h]`, `["24h"]`, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromYAML struct {
+				Expiry Duration `yaml:"expiry"`
+			}
+			err := yaml.Unmarshal([]byte("expiry: "+tt.yaml), &fromYAML)
+			if (err != nil) != tt.wantErr || fromYAML.Expiry != tt.want {
+				t.Errorf("YAML %s = %d, %v, want %d, error %v", tt.yaml, fromYAML.Expiry, err, tt.want, tt.wantErr)
+			}
+
+			var fromJSON struct {
+				Expiry Duration `json:"expiry"`
+			}
+			err = json.Unmarshal([]byte(`{"expiry": `+tt.json+`}`), &fromJSON)
+			if (err != nil) != tt.wantErr || fromJSON.Expiry != tt.want {
+				t.Errorf("JSON %s = %d, %v, want %d, error %v", tt.json, fromJSON.Expiry, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
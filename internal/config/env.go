@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// config file settings. The rest of the name is the upper-cased setting path
+// joined with underscores, HAMI_HOME_ASSISTANT_TOKEN sets home_assistant.token.
+// The same name with a _FILE suffix reads the value from a file instead, as
+// with Docker or systemd secrets, and wins over the plain variable.
+const EnvPrefix = "HAMI_"
+
+// fileSuffix marks environment variables naming a file that holds the value
+const fileSuffix = "_FILE"
+
+// applyEnv overrides the settings of cfg from environment variables
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) error {
+	return applyEnvFields(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), lookup)
+}
+
+// applyEnvFields walks the fields of a config struct, naming them by their yaml keys
+func applyEnvFields(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			if err := applyEnvFields(value, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := lookup(name)
+		source := name
+		if path, fileOK := lookup(name + fileSuffix); fileOK {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", name+fileSuffix, err)
+			}
+			// Secret files usually end with a newline that is not part of the value
+			raw, ok, source = strings.TrimRight(string(data), "\r\n"), true, name+fileSuffix
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setValue(value, raw); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses a string into a config field
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(Duration(0)) {
+		d, err := ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
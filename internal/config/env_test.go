@@ -0,0 +1,159 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfigFile is the config file layer of the override tests
+const testConfigFile = `
+server:
+  port: 9000
+auth:
+  access_token_expiry: 1h
+home_assistant:
+  token: file-token
+`
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// files are written to temporary files, the variables are set to their paths
+		files map[string]string
+		// want changes the settings of the config file to the expected ones
+		want    func(cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "config file only",
+			want: func(cfg *Config) {},
+		},
+		{
+			name: "variable overrides the file",
+			env:  map[string]string{"HAMI_HOME_ASSISTANT_TOKEN": "env-token"},
+			want: func(cfg *Config) { cfg.HomeAssistant.Token = "env-token" },
+		},
+		{
+			name:  "secret file overrides the variable",
+			env:   map[string]string{"HAMI_HOME_ASSISTANT_TOKEN": "env-token"},
+			files: map[string]string{"HAMI_HOME_ASSISTANT_TOKEN_FILE": "secret-token\n"},
+			want:  func(cfg *Config) { cfg.HomeAssistant.Token = "secret-token" },
+		},
+		{
+			name:  "secret file alone",
+			files: map[string]string{"HAMI_AUTH_SECRET_KEY_FILE": "secret-key-from-file\r\n"},
+			want:  func(cfg *Config) { cfg.Auth.SecretKey = "secret-key-from-file" },
+		},
+		{
+			name: "variable set to empty",
+			env:  map[string]string{"HAMI_HOME_ASSISTANT_TOKEN": ""},
+			want: func(cfg *Config) { cfg.HomeAssistant.Token = "" },
+		},
+		{
+			name: "nested and typed settings",
+			env: map[string]string{
+				"HAMI_SERVER_PORT":              "9100",
+				"HAMI_AUTH_ACCESS_TOKEN_EXPIRY": "30m",
+				"HAMI_AUTH_LOCKOUT_WINDOW":      "1d",
+				"HAMI_METRICS_ENABLED":          "true",
+			},
+			want: func(cfg *Config) {
+				cfg.Server.Port = 9100
+				cfg.Auth.AccessTokenExpiry = Duration(30 * time.Minute)
+				cfg.Auth.Lockout.Window = Duration(24 * time.Hour)
+				cfg.Metrics.Enabled = true
+			},
+		},
+		{
+			name: "unprefixed variable is ignored",
+			env:  map[string]string{"SERVER_PORT": "9100"},
+			want: func(cfg *Config) {},
+		},
+		{
+			name:    "invalid integer",
+			env:     map[string]string{"HAMI_SERVER_PORT": "eighty"},
+			wantErr: "HAMI_SERVER_PORT",
+		},
+		{
+			name:    "invalid boolean",
+			env:     map[string]string{"HAMI_METRICS_ENABLED": "maybe"},
+			wantErr: "HAMI_METRICS_ENABLED",
+		},
+		{
+			name:    "empty duration",
+			env:     map[string]string{"HAMI_AUTH_NONCE_EXPIRY": ""},
+			wantErr: "HAMI_AUTH_NONCE_EXPIRY",
+		},
+		{
+			name:    "invalid value in a secret file names the file variable",
+			env:     map[string]string{"HAMI_SERVER_PORT": "9100"},
+			files:   map[string]string{"HAMI_SERVER_PORT_FILE": "eighty"},
+			wantErr: "HAMI_SERVER_PORT_FILE",
+		},
+		{
+			name:    "missing secret file",
+			env:     map[string]string{"HAMI_HOME_ASSISTANT_TOKEN_FILE": "/nonexistent/token"},
+			wantErr: "HAMI_HOME_ASSISTANT_TOKEN_FILE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "config.yaml")
+			if err := os.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			env := make(map[string]string)
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			for name, content := range tt.files {
+				secret := filepath.Join(dir, name)
+				if err := os.WriteFile(secret, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+				env[name] = secret
+			}
+			lookup := func(name string) (string, bool) {
+				value, ok := env[name]
+				return value, ok
+			}
+
+			// Layer the environment on the file as Load does
+			cfg, err := LoadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = applyEnv(cfg, lookup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyEnv() error = %v, want an error naming %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyEnv() error = %v", err)
+			}
+
+			want, err := LoadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The file overrides the defaults and keeps those it does not set
+			if want.Server.Port != 9000 || want.HomeAssistant.Token != "file-token" || want.Log.Level != Default().Log.Level {
+				t.Fatalf("config file layer = %+v, want port 9000, token file-token and the default log level", want)
+			}
+			tt.want(want)
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("config = %+v, want %+v", cfg, want)
+			}
+		})
+	}
+}
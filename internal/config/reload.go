@@ -0,0 +1,145 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Manager holds the current configuration and replaces it when the config
+// file changes. Components read Current on every use or Subscribe to changes.
+type Manager struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	// mu serializes reloads and guards the subscribers and the file state
+	mu          sync.Mutex
+	subscribers []func(old, cfg *Config)
+	modTime     time.Time
+	size        int64
+}
+
+// NewManager creates a Manager for a loaded configuration and the file it came from
+func NewManager(configPath string, cfg *Config) *Manager {
+	m := &Manager{path: configPath}
+	m.current.Store(cfg)
+	m.modTime, m.size = m.stat()
+	return m
+}
+
+// Current returns the current configuration. It must not be modified.
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// Subscribe registers a function called with the previous and the new
+// configuration after every successful reload
+func (m *Manager) Subscribe(fn func(old, cfg *Config)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.subscribers = append(m.subscribers, fn)
+}
+
+// Reload re-reads the config file, validates it and makes it current. An
+// invalid file leaves the current configuration in place. It returns the
+// settings that changed but only take effect after a restart.
+func (m *Manager) Reload() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.modTime, m.size = m.stat()
+
+	cfg, err := Load(m.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for _, warning := range cfg.Warnings() {
+		slog.Warn("Deprecated configuration", "setting", warning.Field, "problem", warning.Message)
+	}
+
+	old := m.current.Swap(cfg)
+	for _, fn := range m.subscribers {
+		fn(old, cfg)
+	}
+
+	return restartRequired(old, cfg), nil
+}
+
+// Watch polls the config file and reloads it when it changes, until stop is closed
+func (m *Manager) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			m.report(m.Reload())
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ReloadAndReport reloads the config file and logs the outcome, for SIGHUP handlers
+func (m *Manager) ReloadAndReport() {
+	m.report(m.Reload())
+}
+
+// report logs the outcome of a reload
+func (m *Manager) report(restart []string, err error) {
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current configuration", "path", m.path, "error", err)
+		return
+	}
+	slog.Info("Config reloaded", "path", m.path)
+	for _, field := range restart {
+		slog.Warn("Config change takes effect after a restart", "setting", field)
+	}
+}
+
+// changed reports whether the config file was modified since the last reload
+func (m *Manager) changed() bool {
+	modTime, size := m.stat()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !modTime.Equal(m.modTime) || size != m.size
+}
+
+// stat returns the modification time and size of the config file
+func (m *Manager) stat() (time.Time, int64) {
+	info, err := os.Stat(m.path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// restartRequired lists the settings that differ between two configurations
+// and are only read at startup
+func restartRequired(old, cfg *Config) []string {
+	var fields []string
+	check := func(field string, changed bool) {
+		if changed {
+			fields = append(fields, field)
+		}
+	}
+
+	check("server.host", old.Server.Host != cfg.Server.Host)
+	check("server.port", old.Server.Port != cfg.Server.Port)
+	check("auth.jwt_algorithm", old.Auth.JWTAlgorithm != cfg.Auth.JWTAlgorithm)
+	check("auth.jwt_key_file", old.Auth.JWTKeyFile != cfg.Auth.JWTKeyFile)
+	check("database.driver", old.Database.Driver != cfg.Database.Driver)
+	check("database.path", old.Database.Path != cfg.Database.Path)
+	check("log.format", old.Log.Format != cfg.Log.Format)
+	return fields
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SchemaID identifies the JSON Schema of the config file
+const SchemaID = "https://github.com/boringsoft/ha-mi/config.schema.json"
+
+// durationSchema accepts duration strings and the nanosecond integers of
+// older config files. A string needs days, units or both, as ParseDuration
+// rejects an empty one.
+var durationSchema = map[string]interface{}{
+	"anyOf": []interface{}{
+		map[string]interface{}{
+			"type":    "string",
+			"pattern": `^-?(\d+d(\d+(\.\d+)?(h|m|s|ms|us|µs|ns))*|(\d+(\.\d+)?(h|m|s|ms|us|µs|ns))+)$`,
+		},
+		map[string]interface{}{
+			"type":        "integer",
+			"description": "Nanoseconds, the format of older config files",
+		},
+	},
+}
+
+// fieldSchemas describes the settings, keyed by their path in the config file
+var fieldSchemas = map[string]map[string]interface{}{
+	"server":      {"description": "HTTP server"},
+	"server.host": {"description": "Listen address"},
+	"server.port": {"description": "Listen port", "minimum": 1, "maximum": 65535},
+	"server.mode": {
+		"description": "production refuses to start with the default secret_key, request_signing_key or password",
+		"enum":        []string{ModeDevelopment, ModeProduction},
+	},
+	"server.max_body_size": {"description": "Largest signed request body in bytes, larger ones are rejected with 413", "minimum": 1},
+
+	"auth":                       {"description": "Authentication"},
+	"auth.user":                  {"description": "Initial admin user, created while there are no users"},
+	"auth.password":              {"description": "Password of the initial admin user"},
+	"auth.secret_key":            {"description": "Legacy JWT key, verifies tokens issued before the signing keyring for one access token lifetime", "minLength": minKeyLength},
+	"auth.request_signing_key":   {"description": "Request signing (HMAC) key shared with clients, must differ from secret_key. Empty signs with secret_key, which is deprecated", "anyOf": []interface{}{map[string]interface{}{"const": ""}, map[string]interface{}{"minLength": minKeyLength}}},
+	"auth.allow_sign_v1":         {"description": "Accept version 1 signatures without X-Sign-Version"},
+	"auth.jwt_algorithm":         {"description": "JWT signing algorithm", "enum": []string{"HS256", "EdDSA", "RS256"}},
+	"auth.jwt_key_file":          {"description": "Optional PEM private key (Ed25519 or RSA) to sign tokens with"},
+	"auth.access_token_expiry":   {"description": "Access token lifetime, shorter than refresh_token_expiry"},
+	"auth.refresh_token_expiry":  {"description": "Refresh token lifetime"},
+	"auth.nonce_expiry":          {"description": "Nonce lifetime"},
+	"auth.lockout":               {"description": "Login brute-force protection, counted per IP and per username"},
+	"auth.lockout.max_failures":  {"description": "Failed logins allowed within the window", "minimum": 1},
+	"auth.lockout.window":        {"description": "Sliding window failed logins are counted in"},
+	"auth.lockout.base_duration": {"description": "First lockout duration, doubling with every lockout"},
+	"auth.lockout.max_duration":  {"description": "Upper limit of the lockout duration"},
+
+	"database":                  {"description": "SQLite database"},
+	"database.driver":           {"description": "sqlite3 (CGO) or sqlite (pure Go), empty for the build default", "enum": []string{"", "sqlite3", "sqlite"}},
+	"database.path":             {"description": "Database file"},
+	"database.backup":           {"description": "Database snapshots"},
+	"database.backup.dir":       {"description": "Snapshot directory"},
+	"database.backup.interval":  {"description": "Scheduled snapshot interval, 0 disables scheduled snapshots"},
+	"database.backup.retention": {"description": "Number of snapshots to keep, 0 keeps all", "minimum": 0},
+
+	"home_assistant":       {"description": "Home Assistant connection"},
+	"home_assistant.url":   {"description": "Home Assistant base URL", "format": "uri", "pattern": "^https?://"},
+	"home_assistant.token": {"description": "Long-lived access token"},
+
+	"log":        {"description": "Logging"},
+	"log.level":  {"description": "Lowest level logged, changes apply on reload", "enum": []string{"debug", "info", "warn", "error"}},
+	"log.format": {"description": "text for terminals, json for log collectors", "enum": []string{"text", "json"}},
+
+	"metrics":         {"description": "Prometheus metrics"},
+	"metrics.enabled": {"description": "Serve the metrics at /metrics, without authentication. Off by default, restrict access to the port before enabling"},
+}
+
+// Schema returns the JSON Schema of the config file, with the built-in
+// defaults, so editors can complete and check config files
+func Schema() map[string]interface{} {
+	schema := objectSchema(reflect.ValueOf(Default()).Elem(), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = SchemaID
+	schema["title"] = "ha-mi configuration"
+	return schema
+}
+
+// SchemaJSON returns the indented JSON Schema of the config file
+func SchemaJSON() ([]byte, error) {
+	return json.MarshalIndent(Schema(), "", "  ")
+}
+
+// objectSchema describes a config struct, with the values of v as defaults
+func objectSchema(v reflect.Value, prefix string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		path := prefix + key
+		properties[key] = valueSchema(v.Field(i), path)
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	// The $schema key lets editors find this schema from a JSON config file
+	if prefix == "" {
+		properties["$schema"] = map[string]interface{}{"type": "string"}
+	}
+	return schema
+}
+
+// valueSchema describes a single setting
+func valueSchema(v reflect.Value, path string) map[string]interface{} {
+	var schema map[string]interface{}
+	switch {
+	case v.Type() == reflect.TypeOf(Duration(0)):
+		schema = map[string]interface{}{"default": Duration(v.Int()).String()}
+		for key, value := range durationSchema {
+			schema[key] = value
+		}
+	case v.Kind() == reflect.Struct:
+		schema = objectSchema(v, path+".")
+	case v.Kind() == reflect.String:
+		schema = map[string]interface{}{"type": "string", "default": v.String()}
+	case v.Kind() == reflect.Int:
+		schema = map[string]interface{}{"type": "integer", "default": v.Int()}
+	case v.Kind() == reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean", "default": v.Bool()}
+	default:
+		schema = map[string]interface{}{}
+	}
+
+	for key, value := range fieldSchemas[path] {
+		schema[key] = value
+	}
+	return schema
+}
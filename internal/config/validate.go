@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Server modes
+const (
+	// ModeDevelopment accepts the built-in credentials
+	ModeDevelopment = "development"
+	// ModeProduction refuses to start with the built-in secret keys or admin password
+	ModeProduction = "production"
+)
+
+// Built-in credentials that must be changed before going to production
+const (
+	defaultSecretKey         = "change-me-in-production-please"
+	defaultRequestSigningKey = "change-me-request-signing-key"
+	defaultPassword          = "admin"
+)
+
+// minKeyLength is the shortest secret key accepted, in bytes
+const minKeyLength = 16
+
+// Problem is a setting that fails validation
+type Problem struct {
+	// Field is the setting path as written in the config file, such as server.port
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// String formats the problem as "field: message"
+func (p Problem) String() string {
+	return p.Field + ": " + p.Message
+}
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []Problem
+}
+
+// Error joins the problems into one line
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		problems[i] = p.String()
+	}
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem, or nil when the server can run with it
+func (c *Config) Validate() error {
+	if problems := c.Problems(); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Problems returns every setting that fails validation, in config file order
+func (c *Config) Problems() []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// server
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port", "%d is out of range 1-65535", c.Server.Port)
+	}
+	if c.Server.Mode != ModeDevelopment && c.Server.Mode != ModeProduction {
+		add("server.mode", "must be %s or %s, not %q", ModeDevelopment, ModeProduction, c.Server.Mode)
+	}
+	if c.Server.MaxBodySize < 1 {
+		add("server.max_body_size", "must be positive")
+	}
+	production := c.Server.Mode == ModeProduction
+
+	// auth
+	a := c.Auth
+	if a.User == "" {
+		add("auth.user", "must not be empty")
+	}
+	if a.Password == "" {
+		add("auth.password", "must not be empty")
+	} else if production && a.Password == defaultPassword {
+		add("auth.password", "must be changed from the default in production mode")
+	}
+	if len(a.SecretKey) < minKeyLength {
+		add("auth.secret_key", "must be at least %d bytes long", minKeyLength)
+	} else if production && a.SecretKey == defaultSecretKey {
+		add("auth.secret_key", "must be changed from the default in production mode")
+	}
+	// An empty request_signing_key falls back to secret_key, see Warnings
+	switch {
+	case a.RequestSigningKey == "":
+	case len(a.RequestSigningKey) < minKeyLength:
+		add("auth.request_signing_key", "must be at least %d bytes long", minKeyLength)
+	case a.RequestSigningKey == a.SecretKey:
+		// Clients hold the request signing key, they must not be able to sign tokens
+		add("auth.request_signing_key", "must differ from auth.secret_key")
+	case production && a.RequestSigningKey == defaultRequestSigningKey:
+		add("auth.request_signing_key", "must be changed from the default in production mode")
+	}
+	switch a.JWTAlgorithm {
+	case "", "HS256", "EdDSA", "RS256":
+	default:
+		add("auth.jwt_algorithm", "must be HS256, EdDSA or RS256, not %q", a.JWTAlgorithm)
+	}
+	if a.AccessTokenExpiry <= 0 {
+		add("auth.access_token_expiry", "must be positive")
+	}
+	if a.RefreshTokenExpiry <= 0 {
+		add("auth.refresh_token_expiry", "must be positive")
+	} else if a.AccessTokenExpiry >= a.RefreshTokenExpiry {
+		add("auth.access_token_expiry", "%s must be shorter than auth.refresh_token_expiry %s", a.AccessTokenExpiry, a.RefreshTokenExpiry)
+	}
+	if a.NonceExpiry <= 0 {
+		add("auth.nonce_expiry", "must be positive")
+	}
+	if a.Lockout.MaxFailures < 1 {
+		add("auth.lockout.max_failures", "must be at least 1")
+	}
+	if a.Lockout.Window <= 0 {
+		add("auth.lockout.window", "must be positive")
+	}
+	if a.Lockout.BaseDuration <= 0 {
+		add("auth.lockout.base_duration", "must be positive")
+	}
+	if a.Lockout.MaxDuration < a.Lockout.BaseDuration {
+		add("auth.lockout.max_duration", "must not be shorter than auth.lockout.base_duration")
+	}
+
+	// database
+	if c.Database.Path == "" {
+		add("database.path", "must not be empty")
+	}
+	if c.Database.Backup.Dir == "" {
+		add("database.backup.dir", "must not be empty")
+	}
+	if c.Database.Backup.Interval < 0 {
+		add("database.backup.interval", "must not be negative")
+	}
+	if c.Database.Backup.Retention < 0 {
+		add("database.backup.retention", "must not be negative")
+	}
+
+	// home_assistant
+	if err := checkURL(c.HomeAssistant.URL); err != "" {
+		add("home_assistant.url", "%s", err)
+	}
+
+	// log
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("log.level", "must be debug, info, warn or error, not %q", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("log.format", "must be text or json, not %q", c.Log.Format)
+	}
+
+	return problems
+}
+
+// checkURL describes what is wrong with a Home Assistant base URL, or returns ""
+func checkURL(raw string) string {
+	if raw == "" {
+		return "must not be empty"
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Sprintf("%q is not a valid URL", raw)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Sprintf("%q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Sprintf("%q has no host", raw)
+	}
+	return ""
+}
+
+// Warnings returns the settings that work but are deprecated
+func (c *Config) Warnings() []Problem {
+	var warnings []Problem
+	if c.Auth.RequestSigningKey == "" {
+		warnings = append(warnings, Problem{
+			Field:   "auth.request_signing_key",
+			Message: "not set, requests are signed with auth.secret_key; this fallback is deprecated, set a separate key with ha-mi rotate-secret -target request and update the clients",
+		})
+	}
+	return warnings
+}
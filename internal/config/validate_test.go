@@ -0,0 +1,228 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// productionConfig returns a configuration the server starts with in production mode
+func productionConfig() *Config {
+	cfg := Default()
+	cfg.Server.Mode = ModeProduction
+	cfg.Auth.Password = "a-better-password"
+	cfg.Auth.SecretKey = "token-secret-key-0123456789"
+	cfg.Auth.RequestSigningKey = "request-signing-key-0123456789"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func() *Config
+		// want lists the field paths of the expected problems, in order
+		want []string
+	}{
+		{"defaults in development", Default, nil},
+		{"production", productionConfig, nil},
+		{
+			name: "defaults in production",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Server.Mode = ModeProduction
+				return cfg
+			},
+			want: []string{"auth.password", "auth.secret_key"},
+		},
+		{
+			name: "default request signing key in production",
+			config: func() *Config {
+				cfg := productionConfig()
+				cfg.Auth.RequestSigningKey = defaultRequestSigningKey
+				return cfg
+			},
+			want: []string{"auth.request_signing_key"},
+		},
+		{
+			name: "default request signing key in development",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Auth.RequestSigningKey = defaultRequestSigningKey
+				return cfg
+			},
+		},
+		{
+			name: "request signing key falls back to secret_key in production",
+			config: func() *Config {
+				cfg := productionConfig()
+				cfg.Auth.RequestSigningKey = ""
+				return cfg
+			},
+		},
+		{
+			name: "request signing key equal to secret_key",
+			config: func() *Config {
+				cfg := productionConfig()
+				cfg.Auth.RequestSigningKey = cfg.Auth.SecretKey
+				return cfg
+			},
+			want: []string{"auth.request_signing_key"},
+		},
+		{
+			name: "short keys",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Auth.SecretKey = "short"
+				cfg.Auth.RequestSigningKey = "short"
+				return cfg
+			},
+			want: []string{"auth.secret_key", "auth.request_signing_key"},
+		},
+		{
+			name: "port out of range",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Server.Port = 65536
+				return cfg
+			},
+			want: []string{"server.port"},
+		},
+		{
+			name: "unknown mode and algorithm",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Server.Mode = "staging"
+				cfg.Auth.JWTAlgorithm = "none"
+				return cfg
+			},
+			want: []string{"server.mode", "auth.jwt_algorithm"},
+		},
+		{
+			name: "access token outlives the refresh token",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Auth.AccessTokenExpiry = Duration(60 * day)
+				return cfg
+			},
+			want: []string{"auth.access_token_expiry"},
+		},
+		{
+			name: "zero expiries",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Auth.AccessTokenExpiry = 0
+				cfg.Auth.RefreshTokenExpiry = 0
+				cfg.Auth.NonceExpiry = 0
+				return cfg
+			},
+			want: []string{"auth.access_token_expiry", "auth.refresh_token_expiry", "auth.nonce_expiry"},
+		},
+		{
+			name: "lockout",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Auth.Lockout.MaxFailures = 0
+				cfg.Auth.Lockout.MaxDuration = Duration(time.Second)
+				return cfg
+			},
+			want: []string{"auth.lockout.max_failures", "auth.lockout.max_duration"},
+		},
+		{
+			name: "problems across sections in file order",
+			config: func() *Config {
+				cfg := Default()
+				cfg.Server.Port = 0
+				cfg.Database.Path = ""
+				cfg.HomeAssistant.URL = "ftp://homeassistant.local"
+				cfg.Log.Format = "xml"
+				return cfg
+			},
+			want: []string{"server.port", "database.path", "home_assistant.url", "log.format"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.config()
+
+			var got []string
+			for _, p := range cfg.Problems() {
+				got = append(got, p.Field)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Problems() fields = %v, want %v", got, tt.want)
+			}
+
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || len(validationErr.Problems) != len(tt.want) {
+				t.Errorf("Validate() error = %v, want a *ValidationError with %d problems", err, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:8123", true},
+		{"https://ha.example.com/", true},
+		{"", false},
+		{"localhost:8123", false},
+		{"ftp://localhost", false},
+		{"http://", false},
+		{"http://[::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := checkURL(tt.url) == ""; got != tt.want {
+			t.Errorf("checkURL(%q) valid = %v, want %v (%s)", tt.url, got, tt.want, checkURL(tt.url))
+		}
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Mode = ModeProduction
+
+	want := "invalid configuration: auth.password: must be changed from the default in production mode; " +
+		"auth.secret_key: must be changed from the default in production mode"
+	if err := cfg.Validate(); err == nil || err.Error() != want {
+		t.Errorf("Validate() error = %v, want %s", err, want)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name              string
+		requestSigningKey string
+		want              []string
+	}{
+		{"fallback to secret_key", "", []string{"auth.request_signing_key"}},
+		{"separate key", "request-signing-key-0123456789", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Auth.RequestSigningKey = tt.requestSigningKey
+
+			var got []string
+			for _, p := range cfg.Warnings() {
+				got = append(got, p.Field)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Warnings() fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
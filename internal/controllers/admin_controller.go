@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/audit"
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/backup"
+)
+
+// AdminController handles server administration requests
+type AdminController struct {
+	keyring       *auth.Keyring
+	loginLimiter  *auth.LoginLimiter
+	backupManager *backup.Manager
+	auditLogger   *audit.Logger
+}
+
+// NewAdminController creates a new AdminController
+func NewAdminController(keyring *auth.Keyring, loginLimiter *auth.LoginLimiter, backupManager *backup.Manager, auditLogger *audit.Logger) *AdminController {
+	return &AdminController{
+		keyring:       keyring,
+		loginLimiter:  loginLimiter,
+		backupManager: backupManager,
+		auditLogger:   auditLogger,
+	}
+}
+
+// ListSigningKeys handles the list signing keys request
+func (c *AdminController) ListSigningKeys(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"keys": c.keyring.Keys()})
+}
+
+// RotateSigningKey handles the rotate signing key request
+func (c *AdminController) RotateSigningKey(ctx *gin.Context) {
+	key, err := c.keyring.Rotate()
+	if err != nil {
+		if errors.Is(err, auth.ErrKeyFileManaged) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, key)
+}
+
+// ListLockouts handles the list login lockouts request
+func (c *AdminController) ListLockouts(ctx *gin.Context) {
+	lockouts, err := c.loginLimiter.ListLockouts()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list lockouts: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"lockouts": lockouts})
+}
+
+// Unlock handles the remove login lockout request
+func (c *AdminController) Unlock(ctx *gin.Context) {
+	scope := ctx.Param("scope")
+	key := ctx.Param("key")
+	if scope != auth.LockoutScopeIP && scope != auth.LockoutScopeUsername {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Scope must be ip or username"})
+		return
+	}
+
+	if err := c.loginLimiter.Unlock(scope, key); err != nil {
+		if errors.Is(err, auth.ErrLockoutNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove lockout: " + err.Error()})
+		return
+	}
+
+	err := c.auditLogger.Record(audit.Entry{
+		UserID: ctx.GetString("userId"),
+		Action: audit.ActionLockoutCleared,
+		Detail: scope + ":" + key,
+		IP:     ctx.ClientIP(),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListBackups handles the list database backups request
+func (c *AdminController) ListBackups(ctx *gin.Context) {
+	snapshots, err := c.backupManager.List()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backups: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"backups": snapshots})
+}
+
+// CreateBackup handles the create database backup request
+func (c *AdminController) CreateBackup(ctx *gin.Context) {
+	snapshot, err := c.backupManager.Create()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup: " + err.Error()})
+		return
+	}
+
+	err = c.auditLogger.Record(audit.Entry{
+		UserID: ctx.GetString("userId"),
+		Action: audit.ActionBackupCreated,
+		Detail: snapshot.Name,
+		IP:     ctx.ClientIP(),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, snapshot)
+}
+
+// DownloadBackup handles the download database backup request
+func (c *AdminController) DownloadBackup(ctx *gin.Context) {
+	path, err := c.backupManager.Path(ctx.Param("name"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.FileAttachment(path, ctx.Param("name"))
+}
+
+// RegisterRoutes registers the admin routes
+func (c *AdminController) RegisterRoutes(router *gin.RouterGroup, require PermissionMiddleware) {
+	adminGroup := router.Group("/admin", require(auth.PermSystemManage))
+	{
+		adminGroup.GET("/keys", c.ListSigningKeys)
+		adminGroup.POST("/keys/rotate", c.RotateSigningKey)
+		adminGroup.GET("/lockouts", c.ListLockouts)
+		adminGroup.DELETE("/lockouts/:scope/:key", c.Unlock)
+		adminGroup.GET("/backups", c.ListBackups)
+		adminGroup.POST("/backups", c.CreateBackup)
+		adminGroup.GET("/backups/:name", c.DownloadBackup)
+	}
+}
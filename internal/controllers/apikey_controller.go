@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+// APIKeyController handles API key management requests
+type APIKeyController struct {
+	apiKeyService *auth.APIKeyService
+	roleService   *auth.RoleService
+}
+
+// NewAPIKeyController creates a new APIKeyController
+func NewAPIKeyController(apiKeyService *auth.APIKeyService, roleService *auth.RoleService) *APIKeyController {
+	return &APIKeyController{
+		apiKeyService: apiKeyService,
+		roleService:   roleService,
+	}
+}
+
+// CreateAPIKeyRequest represents the create API key request body
+type CreateAPIKeyRequest struct {
+	Name      string            `json:"name" binding:"required"`
+	Scopes    []auth.Permission `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse represents the create API key response
+type CreateAPIKeyResponse struct {
+	*auth.APIKey
+	Key string `json:"key"`
+}
+
+// ListAPIKeys handles the list API keys request
+func (c *APIKeyController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := c.apiKeyService.ListAPIKeys(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKey handles the create API key request
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	// Reject misspelled scopes before checking them against the role
+	for _, scope := range req.Scopes {
+		if !auth.IsKnownPermission(scope) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %s", auth.ErrUnknownPermission, scope)})
+			return
+		}
+	}
+
+	// A key can only be granted permissions the user already has
+	role := ctx.GetString("role")
+	for _, scope := range req.Scopes {
+		allowed, err := c.roleService.HasPermission(role, scope)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+			return
+		}
+		if !allowed {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant scope not held by your role: " + string(scope)})
+			return
+		}
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		if req.ExpiresAt.Before(time.Now()) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+
+	key, plaintext, err := c.apiKeyService.CreateAPIKey(ctx.GetString("userId"), req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key: " + err.Error()})
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	ctx.JSON(http.StatusCreated, CreateAPIKeyResponse{
+		APIKey: key,
+		Key:    plaintext,
+	})
+}
+
+// DeleteAPIKey handles the delete API key request
+func (c *APIKeyController) DeleteAPIKey(ctx *gin.Context) {
+	if err := c.apiKeyService.DeleteAPIKey(ctx.GetString("userId"), ctx.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RegisterRoutes registers the API key routes
+func (c *APIKeyController) RegisterRoutes(router *gin.RouterGroup) {
+	keyGroup := router.Group("/api-keys")
+	{
+		keyGroup.GET("", c.ListAPIKeys)
+		keyGroup.POST("", c.CreateAPIKey)
+		keyGroup.DELETE("/:id", c.DeleteAPIKey)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+// TestCreateAPIKeyScopes checks that keys only get known scopes the
+// caller's role holds, and that misspelled scopes are reported as such
+func TestCreateAPIKeyScopes(t *testing.T) {
+	database := newTestDatabase(t)
+	userService := auth.NewUserService(sqlstore.New(database.DB).Users)
+	roleService := auth.NewRoleService(database.DB, sqlstore.New(database.DB).Users)
+	apiKeyService := auth.NewAPIKeyService(database.DB, userService)
+
+	viewer, err := userService.CreateUser("viewer", "viewer-password", "", auth.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	group := router.Group("", func(ctx *gin.Context) {
+		ctx.Set("userId", viewer.ID)
+		ctx.Set("role", viewer.Role)
+	})
+	NewAPIKeyController(apiKeyService, roleService).RegisterRoutes(group)
+
+	tests := []struct {
+		name      string
+		scopes    []auth.Permission
+		want      int
+		wantError string
+	}{
+		{"scopes held by the role", []auth.Permission{auth.PermZonesRead, auth.PermScenesRead}, http.StatusCreated, ""},
+		{"unknown scope", []auth.Permission{"zones:reed"}, http.StatusBadRequest, "unknown permission: zones:reed"},
+		{"scope not held by the role", []auth.Permission{auth.PermZonesRead, auth.PermUsersWrite}, http.StatusForbidden, "users:write"},
+		{"unknown scope reported before the role check", []auth.Permission{auth.PermUsersWrite, "users:wirte"}, http.StatusBadRequest, "unknown permission: users:wirte"},
+		{"no scopes", []auth.Permission{}, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(CreateAPIKeyRequest{Name: tt.name, Scopes: tt.scopes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.wantError) {
+				t.Errorf("body = %s, want an error mentioning %q", w.Body.String(), tt.wantError)
+			}
+		})
+	}
+
+	keys, err := apiKeyService.ListAPIKeys(viewer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("created %d keys, want 1", len(keys))
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/audit"
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+// AuditController handles audit log requests
+type AuditController struct {
+	auditLogger *audit.Logger
+}
+
+// NewAuditController creates a new AuditController
+func NewAuditController(auditLogger *audit.Logger) *AuditController {
+	return &AuditController{
+		auditLogger: auditLogger,
+	}
+}
+
+// ListEntries handles the list audit log entries request. Passing the last
+// seen ID as after returns only newer entries, so clients can poll.
+func (c *AuditController) ListEntries(ctx *gin.Context) {
+	filter := audit.Filter{
+		Action: ctx.Query("action"),
+		UserID: ctx.Query("user_id"),
+	}
+
+	if after := ctx.Query("after"); after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after parameter"})
+			return
+		}
+		filter.AfterID = id
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := c.auditLogger.List(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit log: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// RegisterRoutes registers the audit routes
+func (c *AuditController) RegisterRoutes(router *gin.RouterGroup, require PermissionMiddleware) {
+	router.GET("/audit", require(auth.PermAuditRead), c.ListEntries)
+}
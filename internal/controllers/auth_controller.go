@@ -1,13 +1,18 @@
 package controllers
 
 import (
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
+	"github.com/boringsoft/ha-mi/internal/audit"
 	"github.com/boringsoft/ha-mi/internal/auth"
-	"github.com/boringsoft/ha-mi/internal/config"
+	"github.com/boringsoft/ha-mi/internal/metrics"
 )
 
 // AuthController handles authentication-related requests
@@ -15,16 +20,24 @@ type AuthController struct {
 	jwtService      *auth.JWTService
 	nonceService    *auth.NonceService
 	securityService *auth.SecurityService
-	config          *config.Config
+	userService     *auth.UserService
+	sessionService  *auth.SessionService
+	mfaService      *auth.MFAService
+	loginLimiter    *auth.LoginLimiter
+	auditLogger     *audit.Logger
 }
 
 // NewAuthController creates a new AuthController
-func NewAuthController(jwtService *auth.JWTService, nonceService *auth.NonceService, securityService *auth.SecurityService, config *config.Config) *AuthController {
+func NewAuthController(jwtService *auth.JWTService, nonceService *auth.NonceService, securityService *auth.SecurityService, userService *auth.UserService, sessionService *auth.SessionService, mfaService *auth.MFAService, loginLimiter *auth.LoginLimiter, auditLogger *audit.Logger) *AuthController {
 	return &AuthController{
 		jwtService:      jwtService,
 		nonceService:    nonceService,
 		securityService: securityService,
-		config:          config,
+		userService:     userService,
+		sessionService:  sessionService,
+		mfaService:      mfaService,
+		loginLimiter:    loginLimiter,
+		auditLogger:     auditLogger,
 	}
 }
 
@@ -35,6 +48,7 @@ type LoginRequest struct {
 	Nonce     string `json:"nonce" binding:"required"`
 	Timestamp string `json:"timestamp" binding:"required"`
 	Sign      string `json:"sign" binding:"required"`
+	Device    string `json:"device"`
 }
 
 // TokenResponse represents the token response
@@ -45,6 +59,27 @@ type TokenResponse struct {
 	TokenType    string `json:"token_type"`
 }
 
+// MFARequiredResponse is returned by login when a second factor is needed
+type MFARequiredResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// MFALoginRequest represents the second login step request body
+type MFALoginRequest struct {
+	MFAToken  string `json:"mfa_token" binding:"required"`
+	Code      string `json:"code" binding:"required"`
+	Nonce     string `json:"nonce" binding:"required"`
+	Timestamp string `json:"timestamp" binding:"required"`
+	Sign      string `json:"sign" binding:"required"`
+	Device    string `json:"device"`
+}
+
+// MFACodeRequest represents a request confirmed with a verification code
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
 // RefreshRequest represents the refresh token request body
 type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
@@ -58,6 +93,12 @@ type NonceResponse struct {
 	Nonce string `json:"nonce"`
 }
 
+// SessionResponse represents a session in the session list
+type SessionResponse struct {
+	*auth.Session
+	Current bool `json:"current"`
+}
+
 // Login handles the login request
 func (c *AuthController) Login(ctx *gin.Context) {
 	var req LoginRequest
@@ -85,6 +126,9 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		"nonce":     req.Nonce,
 		"timestamp": req.Timestamp,
 	}
+	if req.Device != "" {
+		params["device"] = req.Device
+	}
 
 	// Validate signature
 	if err := c.securityService.ValidateSignature(params, req.Sign); err != nil {
@@ -92,14 +136,132 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
+	// Refuse locked out IP addresses and usernames
+	ip := ctx.ClientIP()
+	if c.rejectLocked(ctx, ip, req.Username) {
+		return
+	}
+
 	// Validate credentials
-	if req.Username != c.config.Auth.User || req.Password != c.config.Auth.Password {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	user, err := c.userService.Authenticate(req.Username, req.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			c.recordAudit(audit.Entry{Username: req.Username, Action: audit.ActionLoginFailure, IP: ip})
+			c.rejectFailure(ctx, ip, req.Username, "Invalid credentials")
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate credentials"})
 		return
 	}
 
-	// Generate tokens
-	accessToken, refreshToken, err := c.jwtService.GenerateTokens(uuid.New().String(), req.Username, "admin")
+	// Ask for the second factor when two-factor authentication is enabled
+	mfaEnabled, err := c.mfaService.IsEnabled(user.ID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor authentication"})
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := c.jwtService.GenerateMFAToken(user)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, MFARequiredResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
+		return
+	}
+
+	c.completeLogin(ctx, user, req.Device)
+}
+
+// LoginMFA handles the second step of a two-factor login
+func (c *AuthController) LoginMFA(ctx *gin.Context) {
+	var req MFALoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	// Validate timestamp
+	if err := c.securityService.ValidateTimestamp(req.Timestamp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate nonce
+	if err := c.nonceService.ValidateNonce(req.Nonce); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Extract parameters for signature validation
+	params := map[string]string{
+		"mfa_token": req.MFAToken,
+		"code":      req.Code,
+		"nonce":     req.Nonce,
+		"timestamp": req.Timestamp,
+	}
+	if req.Device != "" {
+		params["device"] = req.Device
+	}
+
+	// Validate signature
+	if err := c.securityService.ValidateSignature(params, req.Sign); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the token from the password step
+	claims, err := c.jwtService.ValidateToken(req.MFAToken)
+	if err != nil || claims.Type != auth.MFAToken {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := c.userService.GetUser(claims.UserID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	// Refuse locked out IP addresses and usernames
+	ip := ctx.ClientIP()
+	if c.rejectLocked(ctx, ip, user.Username) {
+		return
+	}
+
+	// Validate the TOTP or recovery code
+	if err := c.mfaService.Verify(user.ID, req.Code); err != nil {
+		if errors.Is(err, auth.ErrMFAInvalidCode) {
+			c.recordAudit(audit.Entry{UserID: user.ID, Username: user.Username, Action: audit.ActionMFAFailure, IP: ip})
+			c.rejectFailure(ctx, ip, user.Username, "Invalid verification code")
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+
+	c.completeLogin(ctx, user, req.Device)
+}
+
+// completeLogin clears the login failures of a user and starts a new session
+func (c *AuthController) completeLogin(ctx *gin.Context, user *auth.User, device string) {
+	if err := c.loginLimiter.RecordSuccess(user.Username); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset login failures"})
+		return
+	}
+	c.recordAudit(audit.Entry{UserID: user.ID, Username: user.Username, Action: audit.ActionLoginSuccess, IP: ctx.ClientIP()})
+	metrics.LoginAttempt(metrics.LoginSuccess)
+
+	// Start a new session
+	pair, err := c.sessionService.CreateSession(user, auth.ClientInfo{
+		Device:    device,
+		UserAgent: ctx.Request.UserAgent(),
+		IP:        ctx.ClientIP(),
+	})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
 		return
@@ -107,13 +269,46 @@ func (c *AuthController) Login(ctx *gin.Context) {
 
 	// Return tokens
 	ctx.JSON(http.StatusOK, TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(c.config.Auth.AccessTokenExpiry.Seconds()),
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    int64(c.jwtService.AccessTokenExpiry().Seconds()),
 		TokenType:    "Bearer",
 	})
 }
 
+// rejectLocked responds with 429 and returns true if the IP address or username is locked
+func (c *AuthController) rejectLocked(ctx *gin.Context, ip, username string) bool {
+	retryAfter, err := c.loginLimiter.Check(ip, username)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login lockout"})
+		return true
+	}
+	if retryAfter > 0 {
+		c.recordAudit(audit.Entry{Username: username, Action: audit.ActionLoginLocked, IP: ip})
+		metrics.LoginAttempt(metrics.LoginLocked)
+		respondLocked(ctx, retryAfter)
+		return true
+	}
+	return false
+}
+
+// rejectFailure counts a failed login step, locking the IP address or username when needed
+func (c *AuthController) rejectFailure(ctx *gin.Context, ip, username, message string) {
+	metrics.LoginAttempt(metrics.LoginFailure)
+
+	lockedFor, err := c.loginLimiter.RecordFailure(ip, username)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login failure"})
+		return
+	}
+	if lockedFor > 0 {
+		respondLocked(ctx, lockedFor)
+		return
+	}
+
+	ctx.JSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
 // Refresh handles the refresh token request
 func (c *AuthController) Refresh(ctx *gin.Context) {
 	var req RefreshRequest
@@ -147,8 +342,11 @@ func (c *AuthController) Refresh(ctx *gin.Context) {
 		return
 	}
 
-	// Refresh tokens
-	accessToken, refreshToken, err := c.jwtService.RefreshTokens(req.RefreshToken)
+	// Rotate the refresh token
+	pair, err := c.sessionService.Refresh(req.RefreshToken, auth.ClientInfo{
+		UserAgent: ctx.Request.UserAgent(),
+		IP:        ctx.ClientIP(),
+	})
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token: " + err.Error()})
 		return
@@ -156,9 +354,9 @@ func (c *AuthController) Refresh(ctx *gin.Context) {
 
 	// Return tokens
 	ctx.JSON(http.StatusOK, TokenResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    int64(c.config.Auth.AccessTokenExpiry.Seconds()),
+		AccessToken:  pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    int64(c.jwtService.AccessTokenExpiry().Seconds()),
 		TokenType:    "Bearer",
 	})
 }
@@ -195,12 +393,193 @@ func (c *AuthController) GetNonce(ctx *gin.Context) {
 	})
 }
 
+// Logout revokes the session of the current access token
+func (c *AuthController) Logout(ctx *gin.Context) {
+	if err := c.sessionService.RevokeSession(ctx.GetString("userId"), ctx.GetString("sessionId")); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ListSessions lists the active sessions of the current user
+func (c *AuthController) ListSessions(ctx *gin.Context) {
+	sessions, err := c.sessionService.ListSessions(ctx.GetString("userId"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions: " + err.Error()})
+		return
+	}
+
+	current := ctx.GetString("sessionId")
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.ID == current,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// RevokeSession revokes one session of the current user
+func (c *AuthController) RevokeSession(ctx *gin.Context) {
+	if err := c.sessionService.RevokeSession(ctx.GetString("userId"), ctx.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RevokeAll revokes every session of the current user
+func (c *AuthController) RevokeAll(ctx *gin.Context) {
+	if err := c.sessionService.RevokeAllSessions(ctx.GetString("userId")); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// EnrollMFA generates a new TOTP secret for the current user
+func (c *AuthController) EnrollMFA(ctx *gin.Context) {
+	user, err := c.userService.GetUser(ctx.GetString("userId"))
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	enrollment, err := c.mfaService.Enroll(user)
+	if err != nil {
+		respondMFAError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+// ActivateMFA verifies the first code and enables two-factor authentication
+func (c *AuthController) ActivateMFA(ctx *gin.Context) {
+	var req MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	codes, err := c.mfaService.Activate(ctx.GetString("userId"), req.Code)
+	if err != nil {
+		respondMFAError(ctx, err)
+		return
+	}
+
+	c.recordAudit(audit.Entry{UserID: ctx.GetString("userId"), Action: audit.ActionMFAEnabled, IP: ctx.ClientIP()})
+
+	// Recovery codes are only ever returned here
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableMFA disables two-factor authentication after verifying a code
+func (c *AuthController) DisableMFA(ctx *gin.Context) {
+	var req MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, err := c.userService.GetUser(ctx.GetString("userId"))
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	// Codes are guessed here as easily as at login, so they share its lockout
+	ip := ctx.ClientIP()
+	if c.rejectLocked(ctx, ip, user.Username) {
+		return
+	}
+
+	if err := c.mfaService.Verify(user.ID, req.Code); err != nil {
+		if errors.Is(err, auth.ErrMFAInvalidCode) {
+			c.recordAudit(audit.Entry{UserID: user.ID, Username: user.Username, Action: audit.ActionMFAFailure, IP: ip})
+			c.rejectFailure(ctx, ip, user.Username, "Invalid verification code")
+			return
+		}
+		respondMFAError(ctx, err)
+		return
+	}
+
+	if err := c.mfaService.Disable(user.ID); err != nil {
+		respondMFAError(ctx, err)
+		return
+	}
+
+	c.recordAudit(audit.Entry{UserID: user.ID, Username: user.Username, Action: audit.ActionMFADisabled, IP: ip})
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// recordAudit writes an audit log entry, logging instead of failing the request on error
+func (c *AuthController) recordAudit(entry audit.Entry) {
+	if err := c.auditLogger.Record(entry); err != nil {
+		slog.Error("Error recording audit log", "action", entry.Action, "error", err)
+	}
+}
+
+// respondLocked responds that the login is locked and when it may be retried
+func respondLocked(ctx *gin.Context, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	ctx.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	ctx.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts",
+		"retry_after": seconds,
+	})
+}
+
 // RegisterRoutes registers the auth routes
 func (c *AuthController) RegisterRoutes(router *gin.RouterGroup) {
 	authGroup := router.Group("/auth")
 	{
 		authGroup.POST("/login", c.Login)
+		authGroup.POST("/login/mfa", c.LoginMFA)
 		authGroup.POST("/refresh", c.Refresh)
 		authGroup.GET("/nonce", c.GetNonce)
 	}
 }
+
+// RegisterProtectedRoutes registers the auth routes that require an access token
+func (c *AuthController) RegisterProtectedRoutes(router *gin.RouterGroup) {
+	authGroup := router.Group("/auth")
+	{
+		authGroup.POST("/logout", c.Logout)
+		authGroup.POST("/revoke-all", c.RevokeAll)
+		authGroup.GET("/sessions", c.ListSessions)
+		authGroup.DELETE("/sessions/:id", c.RevokeSession)
+		authGroup.POST("/mfa/enroll", c.EnrollMFA)
+		authGroup.POST("/mfa/activate", c.ActivateMFA)
+		authGroup.POST("/mfa/disable", c.DisableMFA)
+	}
+}
+
+// respondMFAError maps MFA service errors to HTTP responses
+func respondMFAError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMFAInvalidCode):
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrMFAAlreadyEnabled):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
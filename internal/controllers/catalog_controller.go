@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"github.com/boringsoft/ha-mi/internal/audit"
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/catalog"
+)
+
+// CatalogController handles configuration export and import requests
+type CatalogController struct {
+	catalogService *catalog.Service
+	auditLogger    *audit.Logger
+}
+
+// NewCatalogController creates a new CatalogController
+func NewCatalogController(catalogService *catalog.Service, auditLogger *audit.Logger) *CatalogController {
+	return &CatalogController{
+		catalogService: catalogService,
+		auditLogger:    auditLogger,
+	}
+}
+
+// Export handles the export configuration request. The document is YAML
+// with format=yaml or an Accept header asking for YAML, JSON otherwise.
+func (c *CatalogController) Export(ctx *gin.Context) {
+	doc, err := c.catalogService.Export()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configuration: " + err.Error()})
+		return
+	}
+
+	if ctx.Query("format") == "yaml" || strings.Contains(ctx.GetHeader("Accept"), "yaml") {
+		ctx.YAML(http.StatusOK, doc)
+		return
+	}
+	ctx.JSON(http.StatusOK, doc)
+}
+
+// Import handles the import configuration request. The body is a YAML
+// document when its Content-Type mentions yaml, JSON otherwise.
+func (c *CatalogController) Import(ctx *gin.Context) {
+	mode := ctx.DefaultQuery("mode", catalog.ModeMerge)
+	dryRun := ctx.Query("dry_run") == "true"
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	var doc catalog.Document
+	if strings.Contains(ctx.ContentType(), "yaml") {
+		err = yaml.Unmarshal(body, &doc)
+	} else {
+		err = json.Unmarshal(body, &doc)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document: " + err.Error()})
+		return
+	}
+
+	result, err := c.catalogService.Import(&doc, mode, dryRun)
+	if err != nil {
+		if errors.Is(err, catalog.ErrInvalidDocument) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import configuration: " + err.Error()})
+		return
+	}
+
+	if !dryRun {
+		err := c.auditLogger.Record(audit.Entry{
+			UserID: ctx.GetString("userId"),
+			Action: audit.ActionConfigImported,
+			Detail: fmt.Sprintf("mode=%s changes=%d", mode, len(result.Changes)),
+			IP:     ctx.ClientIP(),
+		})
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record audit log: " + err.Error()})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// RegisterRoutes registers the export and import routes. Both cover zones,
+// mappings and scenes and need the matching permissions for all of them.
+func (c *CatalogController) RegisterRoutes(router *gin.RouterGroup, require PermissionMiddleware) {
+	router.GET("/export",
+		require(auth.PermZonesRead), require(auth.PermMappingsRead), require(auth.PermScenesRead),
+		c.Export)
+	router.POST("/import",
+		require(auth.PermZonesWrite), require(auth.PermMappingsWrite), require(auth.PermScenesWrite),
+		c.Import)
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+// PermissionMiddleware builds a handler that requires the given permission
+type PermissionMiddleware func(permission auth.Permission) gin.HandlerFunc
+
+// missingPermission returns the first of the permissions the caller does not
+// hold, or "" when the caller holds them all. Callers authenticated with an
+// API key only hold the scopes of the key.
+func missingPermission(ctx *gin.Context, roleService *auth.RoleService, permissions []auth.Permission) (auth.Permission, error) {
+	role := ctx.GetString("role")
+	scopes, limited := ctx.Get("scopes")
+
+	for _, permission := range permissions {
+		allowed, err := roleService.HasPermission(role, permission)
+		if err != nil {
+			return "", err
+		}
+		if allowed && limited {
+			allowed = false
+			for _, scope := range scopes.([]auth.Permission) {
+				if scope == permission {
+					allowed = true
+					break
+				}
+			}
+		}
+		if !allowed {
+			return permission, nil
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+// RoleController handles role management requests
+type RoleController struct {
+	roleService *auth.RoleService
+}
+
+// NewRoleController creates a new RoleController
+func NewRoleController(roleService *auth.RoleService) *RoleController {
+	return &RoleController{
+		roleService: roleService,
+	}
+}
+
+// RoleRequest represents the create and update role request body
+type RoleRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Permissions []auth.Permission `json:"permissions" binding:"required"`
+}
+
+// ListRoles handles the list roles request
+func (c *RoleController) ListRoles(ctx *gin.Context) {
+	roles, err := c.roleService.ListRoles()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list roles: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"roles":       roles,
+		"permissions": auth.AllPermissions,
+	})
+}
+
+// GetRole handles the get role request
+func (c *RoleController) GetRole(ctx *gin.Context) {
+	role, err := c.roleService.GetRole(ctx.Param("name"))
+	if err != nil {
+		respondRoleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, role)
+}
+
+// CreateRole handles the create role request
+func (c *RoleController) CreateRole(ctx *gin.Context) {
+	var req RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if req.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Role name is required"})
+		return
+	}
+
+	if !c.checkGrantable(ctx, req.Permissions) {
+		return
+	}
+
+	role, err := c.roleService.CreateRole(req.Name, req.Description, req.Permissions)
+	if err != nil {
+		respondRoleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, role)
+}
+
+// UpdateRole handles the update role request
+func (c *RoleController) UpdateRole(ctx *gin.Context) {
+	var req RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	// The role may hold permissions the caller lacks, taking them away is
+	// as much an escalation as granting them
+	current, err := c.roleService.GetRole(ctx.Param("name"))
+	if err != nil {
+		respondRoleError(ctx, err)
+		return
+	}
+	if !c.checkGrantable(ctx, current.Permissions) || !c.checkGrantable(ctx, req.Permissions) {
+		return
+	}
+
+	role, err := c.roleService.UpdateRole(ctx.Param("name"), req.Description, req.Permissions)
+	if err != nil {
+		respondRoleError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, role)
+}
+
+// DeleteRole handles the delete role request
+func (c *RoleController) DeleteRole(ctx *gin.Context) {
+	if err := c.roleService.DeleteRole(ctx.Param("name")); err != nil {
+		respondRoleError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RegisterRoutes registers the role routes
+func (c *RoleController) RegisterRoutes(router *gin.RouterGroup, require PermissionMiddleware) {
+	roleGroup := router.Group("/roles")
+	{
+		roleGroup.GET("", require(auth.PermRolesRead), c.ListRoles)
+		roleGroup.POST("", require(auth.PermRolesWrite), c.CreateRole)
+		roleGroup.GET("/:name", require(auth.PermRolesRead), c.GetRole)
+		roleGroup.PUT("/:name", require(auth.PermRolesWrite), c.UpdateRole)
+		roleGroup.DELETE("/:name", require(auth.PermRolesWrite), c.DeleteRole)
+	}
+}
+
+// checkGrantable makes sure every permission is known and held by the
+// caller, so roles:write cannot be used to hand out more privileges
+func (c *RoleController) checkGrantable(ctx *gin.Context, permissions []auth.Permission) bool {
+	for _, permission := range permissions {
+		if !auth.IsKnownPermission(permission) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %s", auth.ErrUnknownPermission, permission)})
+			return false
+		}
+	}
+
+	missing, err := missingPermission(ctx, c.roleService, permissions)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+		return false
+	}
+	if missing != "" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Cannot grant a permission you do not hold: " + string(missing)})
+		return false
+	}
+	return true
+}
+
+// respondRoleError maps role service errors to HTTP responses
+func respondRoleError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrRoleNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrUnknownPermission):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrRoleExists), errors.Is(err, auth.ErrRoleBuiltIn), errors.Is(err, auth.ErrRoleInUse):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
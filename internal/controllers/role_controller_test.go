@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store/sqlstore"
+)
+
+// TestRoleGrantLimitedToCaller checks that roles:write only hands out
+// permissions the caller already holds
+func TestRoleGrantLimitedToCaller(t *testing.T) {
+	roleService := newTestRoleService(t)
+	if _, err := roleService.CreateRole("role-admin", "", []auth.Permission{auth.PermRolesRead, auth.PermRolesWrite, auth.PermZonesRead}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := roleService.CreateRole("wide", "", []auth.Permission{auth.PermUsersWrite}); err != nil {
+		t.Fatal(err)
+	}
+	router := newRoleRouter(roleService)
+
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		path   string
+		body   RoleRequest
+		want   int
+	}{
+		{"create with held permissions", "role-admin", http.MethodPost, "/roles",
+			RoleRequest{Name: "reader", Permissions: []auth.Permission{auth.PermZonesRead}}, http.StatusCreated},
+		{"create with a permission not held", "role-admin", http.MethodPost, "/roles",
+			RoleRequest{Name: "escalated", Permissions: []auth.Permission{auth.PermZonesRead, auth.PermUsersWrite}}, http.StatusForbidden},
+		{"create with an unknown permission", "role-admin", http.MethodPost, "/roles",
+			RoleRequest{Name: "typo", Permissions: []auth.Permission{"zones:reed"}}, http.StatusBadRequest},
+		{"extend the own role", "role-admin", http.MethodPut, "/roles/role-admin",
+			RoleRequest{Permissions: []auth.Permission{auth.PermRolesRead, auth.PermRolesWrite, auth.PermUsersWrite}}, http.StatusForbidden},
+		{"strip a role with permissions not held", "role-admin", http.MethodPut, "/roles/wide",
+			RoleRequest{Permissions: []auth.Permission{}}, http.StatusForbidden},
+		{"admin grants anything", auth.RoleAdmin, http.MethodPost, "/roles",
+			RoleRequest{Name: "operators", Permissions: []auth.Permission{auth.PermUsersWrite, auth.PermSystemManage}}, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Test-Role", tt.role)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	role, err := roleService.GetRole("role-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range role.Permissions {
+		if p == auth.PermUsersWrite {
+			t.Errorf("role-admin gained %s", p)
+		}
+	}
+}
+
+// newRoleRouter serves the role routes, taking the caller's role from a header
+func newRoleRouter(roleService *auth.RoleService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	group := router.Group("", func(ctx *gin.Context) {
+		ctx.Set("role", ctx.GetHeader("X-Test-Role"))
+	})
+
+	require := func(permission auth.Permission) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			if allowed, _ := roleService.HasPermission(ctx.GetString("role"), permission); !allowed {
+				ctx.AbortWithStatus(http.StatusForbidden)
+			}
+		}
+	}
+	NewRoleController(roleService).RegisterRoutes(group, require)
+	return router
+}
+
+func newTestRoleService(t *testing.T) *auth.RoleService {
+	t.Helper()
+
+	database := newTestDatabase(t)
+	return auth.NewRoleService(database.DB, sqlstore.New(database.DB).Users)
+}
+
+// newTestDatabase opens a migrated pure-Go SQLite database in a temporary directory
+func newTestDatabase(t *testing.T) *db.DB {
+	t.Helper()
+
+	database, err := db.New(db.DriverPureGo, filepath.Join(t.TempDir(), "ha-mi.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
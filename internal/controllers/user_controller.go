@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+// UserController handles user management requests
+type UserController struct {
+	userService    *auth.UserService
+	roleService    *auth.RoleService
+	sessionService *auth.SessionService
+	mfaService     *auth.MFAService
+}
+
+// NewUserController creates a new UserController
+func NewUserController(userService *auth.UserService, roleService *auth.RoleService, sessionService *auth.SessionService, mfaService *auth.MFAService) *UserController {
+	return &UserController{
+		userService:    userService,
+		roleService:    roleService,
+		sessionService: sessionService,
+		mfaService:     mfaService,
+	}
+}
+
+// CreateUserRequest represents the create user request body
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// UpdateUserRequest represents the update user request body
+type UpdateUserRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+// SetPasswordRequest represents the set password request body
+type SetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// ListUsers handles the list users request
+func (c *UserController) ListUsers(ctx *gin.Context) {
+	users, err := c.userService.ListUsers()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users: " + err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// GetUser handles the get user request
+func (c *UserController) GetUser(ctx *gin.Context) {
+	user, err := c.userService.GetUser(ctx.Param("id"))
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+// CreateUser handles the create user request
+func (c *UserController) CreateUser(ctx *gin.Context) {
+	var req CreateUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if req.Role == "" {
+		req.Role = auth.RoleViewer
+	}
+
+	if !c.checkAssignable(ctx, req.Role) {
+		return
+	}
+
+	user, err := c.userService.CreateUser(req.Username, req.Password, req.Email, req.Role)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser handles the update user request
+func (c *UserController) UpdateUser(ctx *gin.Context) {
+	var req UpdateUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if _, ok := c.manageableUser(ctx); !ok {
+		return
+	}
+	if !c.checkAssignable(ctx, req.Role) {
+		return
+	}
+
+	// Requests are authorised with the role stored in the database, so the
+	// new role applies to the user's sessions right away
+	user, err := c.userService.UpdateUser(ctx.Param("id"), req.Email, req.Role)
+	if err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+// SetPassword handles the set password request
+func (c *UserController) SetPassword(ctx *gin.Context) {
+	var req SetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	user, ok := c.manageableUser(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.userService.SetPassword(user.ID, req.Password); err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in
+	if err := c.sessionService.RevokeAllSessions(user.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// DeleteUser handles the delete user request
+func (c *UserController) DeleteUser(ctx *gin.Context) {
+	user, ok := c.manageableUser(ctx)
+	if !ok {
+		return
+	}
+
+	// Revoke first, deleting the user deletes its session rows
+	if err := c.sessionService.RevokeAllSessions(user.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+		return
+	}
+
+	if err := c.userService.DeleteUser(user.ID); err != nil {
+		respondUserError(ctx, err)
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RevokeSessions handles the revoke all sessions of a user request
+func (c *UserController) RevokeSessions(ctx *gin.Context) {
+	user, ok := c.manageableUser(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.sessionService.RevokeAllSessions(user.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// ResetMFA handles the remove two-factor authentication of a user request
+func (c *UserController) ResetMFA(ctx *gin.Context) {
+	user, ok := c.manageableUser(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.mfaService.Disable(user.ID); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset two-factor authentication: " + err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RegisterRoutes registers the user routes
+func (c *UserController) RegisterRoutes(router *gin.RouterGroup, require PermissionMiddleware) {
+	userGroup := router.Group("/users")
+	{
+		userGroup.GET("", require(auth.PermUsersRead), c.ListUsers)
+		userGroup.POST("", require(auth.PermUsersWrite), c.CreateUser)
+		userGroup.GET("/:id", require(auth.PermUsersRead), c.GetUser)
+		userGroup.PUT("/:id", require(auth.PermUsersWrite), c.UpdateUser)
+		userGroup.PUT("/:id/password", require(auth.PermUsersWrite), c.SetPassword)
+		userGroup.DELETE("/:id", require(auth.PermUsersWrite), c.DeleteUser)
+		userGroup.DELETE("/:id/sessions", require(auth.PermUsersWrite), c.RevokeSessions)
+		userGroup.DELETE("/:id/mfa", require(auth.PermUsersWrite), c.ResetMFA)
+	}
+}
+
+// checkAssignable makes sure a role exists and grants nothing the caller
+// lacks, so users:write cannot be used to hand out more privileges
+func (c *UserController) checkAssignable(ctx *gin.Context, roleName string) bool {
+	role, err := c.roleService.GetRole(roleName)
+	if err != nil {
+		respondRoleError(ctx, err)
+		return false
+	}
+
+	missing, err := missingPermission(ctx, c.roleService, role.Permissions)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+		return false
+	}
+	if missing != "" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Cannot assign a role with a permission you do not hold: " + string(missing)})
+		return false
+	}
+	return true
+}
+
+// manageableUser loads the user in the path and makes sure the caller holds
+// every permission of its role, so a less privileged user cannot take over
+// or lock out a more privileged one
+func (c *UserController) manageableUser(ctx *gin.Context) (*auth.User, bool) {
+	user, err := c.userService.GetUser(ctx.Param("id"))
+	if err != nil {
+		respondUserError(ctx, err)
+		return nil, false
+	}
+
+	role, err := c.roleService.GetRole(user.Role)
+	if err != nil && !errors.Is(err, auth.ErrRoleNotFound) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+		return nil, false
+	}
+	if role == nil {
+		// The role no longer exists and grants nothing
+		return user, true
+	}
+
+	missing, err := missingPermission(ctx, c.roleService, role.Permissions)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permission: " + err.Error()})
+		return nil, false
+	}
+	if missing != "" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Cannot manage a user whose role has a permission you do not hold: " + string(missing)})
+		return nil, false
+	}
+	return user, true
+}
+
+// respondUserError maps user service errors to HTTP responses
+func respondUserError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUserNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrUserExists):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrLastAdmin):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Restore errors
+var (
+	ErrInvalidBackup = errors.New("invalid backup")
+	ErrDatabaseInUse = errors.New("database is in use, stop the server before restoring")
+)
+
+// PreRestoreSuffix is appended to the path of the database replaced by Restore
+const PreRestoreSuffix = ".pre-restore"
+
+// Backup writes a consistent snapshot of the database to path while it stays
+// in use. The snapshot is written next to path first and renamed into place,
+// so an interrupted backup never leaves a truncated file behind.
+func (db *DB) Backup(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	// VACUUM INTO refuses to overwrite an existing file
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing stale backup: %w", err)
+	}
+
+	if _, err := db.Exec("VACUUM INTO ?", tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing backup: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error moving backup into place: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the database file at dbPath with a backup and returns the
+// schema version of the backup. The backup must pass an integrity check and
+// must not be newer than this build; older backups are migrated when the
+// database is next opened. The replaced database is kept at dbPath with
+// PreRestoreSuffix appended. Restore fails with ErrDatabaseInUse when it
+// cannot take an exclusive lock on the database, which it holds during the swap.
+func Restore(driver, dbPath, backupPath string) (int, error) {
+	// Check a copy, so neither the backup nor the live database is touched
+	// until the restored file is known to be good
+	tmp := dbPath + ".restore"
+	if err := copyFile(backupPath, tmp); err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+
+	version, err := checkBackup(driver, tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		if err := os.Rename(tmp, dbPath); err != nil {
+			return 0, fmt.Errorf("error moving backup into place: %w", err)
+		}
+		return version, nil
+	}
+
+	unlock, err := lockExclusive(driver, dbPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	previous := dbPath + PreRestoreSuffix
+	if err := os.Rename(dbPath, previous); err != nil {
+		return 0, fmt.Errorf("error moving %s aside: %w", dbPath, err)
+	}
+
+	// Remove the journal of the old database, it must not be replayed onto the backup
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			os.Rename(previous, dbPath)
+			return 0, fmt.Errorf("error removing %s: %w", dbPath+suffix, err)
+		}
+	}
+
+	if err := os.Rename(tmp, dbPath); err != nil {
+		os.Rename(previous, dbPath)
+		return 0, fmt.Errorf("error moving backup into place: %w", err)
+	}
+	return version, nil
+}
+
+// lockExclusive takes an exclusive lock on the database at path, so no other
+// connection reads or writes it until the returned function releases the lock
+func lockExclusive(driver, path string) (func(), error) {
+	live, err := New(driver, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	conn, err := live.Conn(ctx)
+	if err != nil {
+		live.Close()
+		return nil, fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	if _, err := conn.ExecContext(ctx, "BEGIN EXCLUSIVE"); err != nil {
+		conn.Close()
+		live.Close()
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseInUse, err)
+	}
+
+	return func() {
+		conn.ExecContext(ctx, "ROLLBACK")
+		conn.Close()
+		live.Close()
+	}, nil
+}
+
+// checkBackup verifies the integrity and schema version of a database file
+func checkBackup(driver, path string) (int, error) {
+	backup, err := New(driver, path)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+	defer backup.Close()
+
+	var result string
+	if err := backup.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return 0, fmt.Errorf("%w: integrity check failed: %s", ErrInvalidBackup, result)
+	}
+
+	version, err := backup.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("%w: no schema migrations have been applied", ErrInvalidBackup)
+	}
+
+	// Fails with ErrSchemaTooNew for backups of a newer ha-mi
+	if _, err := backup.PendingMigrations(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// copyFile copies the file at src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("error copying backup: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("error copying backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestore(t *testing.T) {
+	for _, driver := range Drivers() {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, "ha-mi.db")
+			backupPath := filepath.Join(dir, "backup.db")
+
+			live := newTestDB(t, driver, dbPath)
+			mustExec(t, live, "INSERT INTO zones (name, description, created_at, updated_at) VALUES ('客厅', '', 0, 0)")
+			if err := live.Backup(backupPath); err != nil {
+				t.Fatal(err)
+			}
+			mustExec(t, live, "INSERT INTO zones (name, description, created_at, updated_at) VALUES ('卧室', '', 0, 0)")
+			live.Close()
+
+			if _, err := Restore(driver, dbPath, backupPath); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := countZones(t, driver, dbPath); n != 1 {
+				t.Errorf("restored database has %d zones, want 1", n)
+			}
+			if n := countZones(t, driver, dbPath+PreRestoreSuffix); n != 2 {
+				t.Errorf("previous database has %d zones, want 2", n)
+			}
+		})
+	}
+}
+
+func TestRestoreInUse(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "ha-mi.db")
+	backupPath := filepath.Join(dir, "backup.db")
+
+	live := newTestDB(t, DriverPureGo, dbPath)
+	if err := live.Backup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// An open write transaction stands in for a running server
+	tx, err := live.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO zones (name, description, created_at, updated_at) VALUES ('客厅', '', 0, 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(DriverPureGo, dbPath, backupPath); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("Restore() error = %v, want %v", err, ErrDatabaseInUse)
+	}
+	if _, err := os.Stat(dbPath + PreRestoreSuffix); !os.IsNotExist(err) {
+		t.Errorf("database was moved aside although it is in use")
+	}
+}
+
+func newTestDB(t *testing.T, driver, path string) *DB {
+	t.Helper()
+
+	database, err := New(driver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func mustExec(t *testing.T, database *DB, query string) {
+	t.Helper()
+
+	if _, err := database.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countZones(t *testing.T, driver, path string) int {
+	t.Helper()
+
+	database, err := New(driver, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	var n int
+	if err := database.QueryRow("SELECT COUNT(*) FROM zones").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Database drivers selectable in the database configuration
+const (
+	// DriverCGO is github.com/mattn/go-sqlite3, which needs CGO
+	DriverCGO = "sqlite3"
+	// DriverPureGo is modernc.org/sqlite, a CGO-free translation of SQLite
+	DriverPureGo = "sqlite"
+)
+
+// defaultDriver is used when no driver is configured. Builds with CGO
+// default to DriverCGO, builds without it to DriverPureGo.
+var defaultDriver = DriverPureGo
+
+// constraintCheckers recognise constraint violations of the compiled-in drivers
+var constraintCheckers []func(err error) bool
+
+// foreignKeyCheckers recognise foreign key violations of the compiled-in drivers
+var foreignKeyCheckers []func(err error) bool
+
+// IsConstraintError reports whether err is a constraint violation, such as
+// a duplicate value in a UNIQUE column, for any of the compiled-in drivers
+func IsConstraintError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, check := range constraintCheckers {
+		if check(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsForeignKeyError reports whether err is a foreign key violation, such as a
+// reference to a missing row. Foreign key violations are constraint errors too.
+func IsForeignKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, check := range foreignKeyCheckers {
+		if check(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Drivers returns the database drivers compiled into this build
+func Drivers() []string {
+	var drivers []string
+	for _, name := range sql.Drivers() {
+		if name == DriverCGO || name == DriverPureGo {
+			drivers = append(drivers, name)
+		}
+	}
+	return drivers
+}
+
+// resolveDriver returns the driver to use, checking that it is compiled in
+func resolveDriver(driver string) (string, error) {
+	if driver == "" {
+		driver = defaultDriver
+	}
+
+	for _, name := range sql.Drivers() {
+		if name == driver {
+			return driver, nil
+		}
+	}
+
+	return "", fmt.Errorf("database driver %q is not available in this build, use one of: %s", driver, strings.Join(sql.Drivers(), ", "))
+}
+
+// dataSourceName builds the connection string of a database file for a driver.
+// Both drivers apply the parameters to every connection they open, so the
+// foreign keys are enforced on all pooled connections.
+func dataSourceName(driver, dbPath string) string {
+	if driver == DriverPureGo {
+		// Wait for locks like go-sqlite3 does by default instead of failing with SQLITE_BUSY
+		return dbPath + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)"
+	}
+	return dbPath + "?_foreign_keys=on"
+}
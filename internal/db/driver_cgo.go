@@ -0,0 +1,22 @@
+//go:build cgo
+
+package db
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func init() {
+	defaultDriver = DriverCGO
+
+	constraintCheckers = append(constraintCheckers, func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint
+	})
+	foreignKeyCheckers = append(foreignKeyCheckers, func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	})
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlitelib "modernc.org/sqlite/lib"
+)
+
+func init() {
+	constraintCheckers = append(constraintCheckers, func(err error) bool {
+		var sqliteErr *sqlite.Error
+		// Extended result codes keep the primary code in the low byte
+		return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlitelib.SQLITE_CONSTRAINT
+	})
+	foreignKeyCheckers = append(foreignKeyCheckers, func(err error) bool {
+		var sqliteErr *sqlite.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlitelib.SQLITE_CONSTRAINT_FOREIGNKEY
+	})
+}
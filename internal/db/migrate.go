@@ -0,0 +1,253 @@
+package db
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer version of ha-mi
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of ha-mi supports")
+
+// Migration is a numbered schema change, loaded from migrations/NNNN_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+
+		prefix, rest, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    rest,
+			SQL:     string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// LatestVersion returns the schema version this build migrates to
+func LatestVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// SchemaVersion returns the highest migration version applied to the database
+func (db *DB) SchemaVersion() (int, error) {
+	if err := db.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations not yet applied to the database.
+// It fails with ErrSchemaTooNew when the database is ahead of this build.
+func (db *DB) PendingMigrations() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := db.appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return nil, fmt.Errorf("%w: unknown migration %d has been applied", ErrSchemaTooNew, version)
+		}
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations, each in its own transaction,
+// and returns the migrations that were applied
+func (db *DB) Migrate() ([]Migration, error) {
+	adopted, err := db.predatesMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range pending {
+		if err := db.applyMigration(m); err != nil {
+			return pending[:i], err
+		}
+	}
+
+	if adopted {
+		if err := db.openLegacyTokenWindow(); err != nil {
+			return pending, err
+		}
+	}
+
+	return pending, nil
+}
+
+// predatesMigrations reports whether the database was created by a version
+// of ha-mi without versioned migrations, which had the nonces table but did
+// not record migrations
+func (db *DB) predatesMigrations() (bool, error) {
+	var tables int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'nonces'").Scan(&tables)
+	if err != nil {
+		return false, fmt.Errorf("error inspecting database schema: %w", err)
+	}
+	if tables == 0 {
+		return false, nil
+	}
+
+	applied, err := db.appliedVersions()
+	if err != nil {
+		return false, err
+	}
+	return len(applied) == 0, nil
+}
+
+// openLegacyTokenWindow starts the window in which access tokens signed with
+// auth.secret_key before the signing keyring are accepted. A database that
+// predates migrations may hold such tokens, even without users: the oldest
+// versions logged in the admin from the config file. Databases that already
+// have signing keys issued tokens with key IDs and get no window.
+func (db *DB) openLegacyTokenWindow() error {
+	_, err := db.Exec(`
+		INSERT INTO legacy_token_window (started_at)
+		SELECT ?
+		WHERE NOT EXISTS (SELECT 1 FROM signing_keys) AND NOT EXISTS (SELECT 1 FROM legacy_token_window)
+	`, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("error opening legacy token window: %w", err)
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records it in one transaction
+func (db *DB) applyMigration(m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return fmt.Errorf("error applying migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("error recording migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migration %04d_%s: %w", m.Version, m.Name, err)
+	}
+
+	return nil
+}
+
+// appliedVersions returns the set of applied migration versions
+func (db *DB) appliedVersions() (map[int]bool, error) {
+	if err := db.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error querying schema migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("error scanning schema migration: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// createMigrationsTable creates the table recording applied migrations
+func (db *DB) createMigrationsTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+-- Baseline schema. The statements use IF NOT EXISTS so databases created
+-- before versioned migrations adopt it without changes.
+
+-- Create nonce table
+CREATE TABLE IF NOT EXISTS nonces (
+	nonce TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
+
+-- Create zones table
+CREATE TABLE IF NOT EXISTS zones (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- Create device types table
+CREATE TABLE IF NOT EXISTS device_types (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- Create operations table
+CREATE TABLE IF NOT EXISTS operations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	device_type_id INTEGER NOT NULL,
+	description TEXT,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	UNIQUE(name, device_type_id),
+	FOREIGN KEY(device_type_id) REFERENCES device_types(id) ON DELETE CASCADE
+);
+
+-- Create mappings table
+CREATE TABLE IF NOT EXISTS mappings (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	zone_id INTEGER NOT NULL,
+	device_type_id INTEGER NOT NULL,
+	operation_id INTEGER NOT NULL,
+	entity_id TEXT NOT NULL,
+	service TEXT NOT NULL,
+	params TEXT,
+	value_mapping TEXT,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	UNIQUE(zone_id, device_type_id, operation_id),
+	FOREIGN KEY(zone_id) REFERENCES zones(id) ON DELETE CASCADE,
+	FOREIGN KEY(device_type_id) REFERENCES device_types(id) ON DELETE CASCADE,
+	FOREIGN KEY(operation_id) REFERENCES operations(id) ON DELETE CASCADE
+);
+
+-- Create scenes table
+CREATE TABLE IF NOT EXISTS scenes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE,
+	scene_id TEXT NOT NULL UNIQUE,
+	description TEXT,
+	actions TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- Create users table
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	email TEXT NOT NULL DEFAULT '',
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- Create roles table
+CREATE TABLE IF NOT EXISTS roles (
+	name TEXT PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT '',
+	permissions TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+-- Create sessions table (one row per refresh token family)
+CREATE TABLE IF NOT EXISTS sessions (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	device TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	last_refreshed_at INTEGER NOT NULL,
+	revoked_at INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create refresh tokens table
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	jti TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at INTEGER,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE
+);
+
+-- Create API keys table
+CREATE TABLE IF NOT EXISTS api_keys (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at INTEGER,
+	last_used_at INTEGER,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create signing keys table
+CREATE TABLE IF NOT EXISTS signing_keys (
+	kid TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	retired_at INTEGER
+);
+
+-- Create login failures table
+CREATE TABLE IF NOT EXISTS login_failures (
+	scope TEXT NOT NULL,
+	key TEXT NOT NULL,
+	failed_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_key ON login_failures(scope, key);
+
+-- Create login lockouts table
+CREATE TABLE IF NOT EXISTS login_lockouts (
+	scope TEXT NOT NULL,
+	key TEXT NOT NULL,
+	level INTEGER NOT NULL,
+	locked_until INTEGER NOT NULL,
+	PRIMARY KEY(scope, key)
+);
+
+-- Create audit log table
+CREATE TABLE IF NOT EXISTS audit_logs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id TEXT NOT NULL DEFAULT '',
+	username TEXT NOT NULL DEFAULT '',
+	action TEXT NOT NULL,
+	detail TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+
+-- Create user MFA table
+CREATE TABLE IF NOT EXISTS user_mfa (
+	user_id TEXT PRIMARY KEY,
+	secret TEXT NOT NULL,
+	enabled INTEGER NOT NULL DEFAULT 0,
+	last_step INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Create recovery codes table
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	user_id TEXT NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at INTEGER,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY(user_id, code_hash),
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
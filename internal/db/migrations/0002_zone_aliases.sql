@@ -0,0 +1,11 @@
+-- Alternative names of a zone, e.g. 大厅 for 客厅. Aliases are unique
+-- across all zones so a spoken zone name resolves to a single zone.
+CREATE TABLE zone_aliases (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	zone_id INTEGER NOT NULL,
+	alias TEXT NOT NULL UNIQUE,
+	created_at INTEGER NOT NULL,
+	FOREIGN KEY(zone_id) REFERENCES zones(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_zone_aliases_zone ON zone_aliases(zone_id);
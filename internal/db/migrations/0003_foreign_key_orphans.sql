@@ -0,0 +1,22 @@
+-- Foreign keys were not enforced before, so deleted rows could leave their
+-- dependants behind. Remove them now that ON DELETE CASCADE takes effect.
+-- Parents are cleaned before the tables referencing them.
+
+DELETE FROM operations WHERE device_type_id NOT IN (SELECT id FROM device_types);
+
+DELETE FROM zone_aliases WHERE zone_id NOT IN (SELECT id FROM zones);
+
+DELETE FROM mappings
+WHERE zone_id NOT IN (SELECT id FROM zones)
+	OR device_type_id NOT IN (SELECT id FROM device_types)
+	OR operation_id NOT IN (SELECT id FROM operations);
+
+DELETE FROM sessions WHERE user_id NOT IN (SELECT id FROM users);
+
+DELETE FROM refresh_tokens WHERE session_id NOT IN (SELECT id FROM sessions);
+
+DELETE FROM api_keys WHERE user_id NOT IN (SELECT id FROM users);
+
+DELETE FROM user_mfa WHERE user_id NOT IN (SELECT id FROM users);
+
+DELETE FROM recovery_codes WHERE user_id NOT IN (SELECT id FROM users);
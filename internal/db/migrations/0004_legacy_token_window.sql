@@ -0,0 +1,13 @@
+-- Access tokens signed before the signing keyring carry no kid and are
+-- verified with auth.secret_key. They are only accepted for one access token
+-- lifetime after the upgrade, starting at the time recorded here. Databases
+-- that already use the keyring get no row. Databases created before
+-- versioned migrations may hold tokens even without users, those get their
+-- row from DB.Migrate, which can tell them from a fresh install.
+CREATE TABLE legacy_token_window (
+	started_at INTEGER NOT NULL
+);
+
+INSERT INTO legacy_token_window (started_at)
+SELECT CAST(strftime('%s', 'now') AS INTEGER)
+WHERE EXISTS (SELECT 1 FROM users) AND NOT EXISTS (SELECT 1 FROM signing_keys);
@@ -0,0 +1,63 @@
+// Package logging sets up the structured logger of the server, carries
+// request IDs in contexts and keeps secrets out of log records.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// level is shared by the handlers created here, so SetLevel applies to the running logger
+var level = new(slog.LevelVar)
+
+// Setup makes a logger writing to stderr in the given format and level the
+// default slog logger
+func Setup(format, levelName string) error {
+	logger, err := New(os.Stderr, format)
+	if err != nil {
+		return err
+	}
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// New creates a logger writing text or JSON records to w. Attributes that
+// may hold secrets are redacted.
+func New(w io.Writer, format string) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	switch format {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, use %s or %s", format, FormatText, FormatJSON)
+}
+
+// SetLevel changes the level of the loggers created by New: debug, info, warn or error
+func SetLevel(name string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(name))); err != nil {
+		return fmt.Errorf("unknown log level %q, use debug, info, warn or error", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the current log level
+func Level() slog.Level {
+	return level.Level()
+}
@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secret values in log records
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute and query parameter names whose values are secret
+var sensitiveKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"authorization",
+	"api_key",
+	"apikey",
+	"signing_key",
+	"cookie",
+}
+
+// sensitiveValues match secrets in free text: bearer credentials and JWTs
+var sensitiveValues = regexp.MustCompile(`(?i)bearer\s+[\w.~+/=-]+|eyJ[\w-]*\.[\w-]+\.[\w-]*`)
+
+// IsSensitive reports whether an attribute or parameter name holds a secret
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString replaces bearer credentials and JWTs within s
+func RedactString(s string) string {
+	return sensitiveValues.ReplaceAllString(s, Redacted)
+}
+
+// RedactQuery replaces the values of secret query parameters, for logging URLs
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return RedactString(rawQuery)
+	}
+	for key := range query {
+		if IsSensitive(key) {
+			query[key] = []string{Redacted}
+		}
+	}
+	// Keep the marker readable instead of percent-encoded
+	encoded := strings.ReplaceAll(query.Encode(), url.QueryEscape(Redacted), Redacted)
+	return RedactString(encoded)
+}
+
+// redactAttr is the ReplaceAttr function of the handlers, it redacts
+// attributes by name and secrets embedded in string values
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	if attr.Value.Kind() == slog.KindString {
+		if value := attr.Value.String(); sensitiveValues.MatchString(value) {
+			return slog.String(attr.Key, RedactString(value))
+		}
+	}
+	return attr
+}
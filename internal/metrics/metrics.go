@@ -0,0 +1,66 @@
+// Package metrics collects the server metrics and writes them in the
+// Prometheus text exposition format for the /metrics endpoint.
+package metrics
+
+import (
+	"strconv"
+	"time"
+)
+
+// DefaultBuckets are the latency histogram buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Nonce validation results
+const (
+	NonceOK      = "ok"
+	NonceInvalid = "invalid"
+	NonceExpired = "expired"
+)
+
+// Login results
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	LoginLocked  = "locked"
+)
+
+// Metrics of the server
+var (
+	httpRequests = NewCounterVec("hami_http_requests_total",
+		"HTTP requests handled, by method, route and status code", "method", "route", "status")
+	httpDuration = NewHistogramVec("hami_http_request_duration_seconds",
+		"Time taken to handle HTTP requests", DefaultBuckets, "method", "route")
+
+	noncesIssued = NewCounterVec("hami_nonces_issued_total",
+		"Nonces issued")
+	nonceValidations = NewCounterVec("hami_nonce_validations_total",
+		"Nonce validations, by result: ok, invalid or expired", "result")
+
+	logins = NewCounterVec("hami_logins_total",
+		"Login attempts, by result: success, failure or locked", "result")
+)
+
+// ObserveHTTPRequest records a handled HTTP request. The route is the
+// registered path pattern, so paths with IDs share a series.
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	httpRequests.Inc(method, route, strconv.Itoa(status))
+	httpDuration.Observe(duration.Seconds(), method, route)
+}
+
+// NonceIssued records an issued nonce
+func NonceIssued() {
+	noncesIssued.Inc()
+}
+
+// NonceValidated records the result of a nonce validation: NonceOK, NonceInvalid or NonceExpired
+func NonceValidated(result string) {
+	nonceValidations.Inc(result)
+}
+
+// LoginAttempt records the result of a login: LoginSuccess, LoginFailure or LoginLocked
+func LoginAttempt(result string) {
+	logins.Inc(result)
+}
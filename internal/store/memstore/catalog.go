@@ -0,0 +1,650 @@
+package memstore
+
+import (
+	"sort"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// zoneRepository implements store.ZoneRepository
+type zoneRepository struct {
+	*memory
+}
+
+// List returns all zones ordered by name
+func (r *zoneRepository) List() ([]*store.Zone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	zones := make([]*store.Zone, 0, len(r.zones))
+	for _, zone := range r.zones {
+		copied := *zone
+		zones = append(zones, &copied)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones, nil
+}
+
+// Get returns the zone with the given ID
+func (r *zoneRepository) Get(id int64) (*store.Zone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	zone, ok := r.zones[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *zone
+	return &copied, nil
+}
+
+// GetByName returns the zone with the given name
+func (r *zoneRepository) GetByName(name string) (*store.Zone, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, zone := range r.zones {
+		if zone.Name == name {
+			copied := *zone
+			return &copied, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Create stores a new zone and sets its ID
+func (r *zoneRepository) Create(zone *store.Zone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.zoneNameTaken(zone.Name, 0) {
+		return store.ErrConflict
+	}
+
+	zone.ID = r.nextID("zones")
+	copied := *zone
+	r.zones[zone.ID] = &copied
+	return nil
+}
+
+// Update stores the name and description of a zone
+func (r *zoneRepository) Update(zone *store.Zone) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.zones[zone.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if r.zoneNameTaken(zone.Name, zone.ID) {
+		return store.ErrConflict
+	}
+
+	stored.Name = zone.Name
+	stored.Description = zone.Description
+	stored.UpdatedAt = zone.UpdatedAt
+	return nil
+}
+
+// Delete deletes a zone, its aliases and its mappings
+func (r *zoneRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.zones[id]; !ok {
+		return store.ErrNotFound
+	}
+
+	for aliasID, alias := range r.zoneAliases {
+		if alias.ZoneID == id {
+			delete(r.zoneAliases, aliasID)
+		}
+	}
+	r.deleteMappings(func(m *store.Mapping) bool { return m.ZoneID == id })
+	delete(r.zones, id)
+	return nil
+}
+
+// zoneNameTaken reports whether another zone has the name
+func (r *zoneRepository) zoneNameTaken(name string, exceptID int64) bool {
+	for id, zone := range r.zones {
+		if id != exceptID && zone.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// zoneAliasRepository implements store.ZoneAliasRepository
+type zoneAliasRepository struct {
+	*memory
+}
+
+// List returns all aliases ordered by alias
+func (r *zoneAliasRepository) List() ([]*store.ZoneAlias, error) {
+	return r.filter(func(*store.ZoneAlias) bool { return true }), nil
+}
+
+// ListByZone returns the aliases of a zone ordered by alias
+func (r *zoneAliasRepository) ListByZone(zoneID int64) ([]*store.ZoneAlias, error) {
+	return r.filter(func(a *store.ZoneAlias) bool { return a.ZoneID == zoneID }), nil
+}
+
+// GetByAlias returns the alias with the given name
+func (r *zoneAliasRepository) GetByAlias(alias string) (*store.ZoneAlias, error) {
+	aliases := r.filter(func(a *store.ZoneAlias) bool { return a.Alias == alias })
+	if len(aliases) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return aliases[0], nil
+}
+
+// Create stores a new alias and sets its ID
+func (r *zoneAliasRepository) Create(alias *store.ZoneAlias) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, other := range r.zoneAliases {
+		if other.Alias == alias.Alias {
+			return store.ErrConflict
+		}
+	}
+	if _, ok := r.zones[alias.ZoneID]; !ok {
+		return store.ErrNotFound
+	}
+
+	alias.ID = r.nextID("zone_aliases")
+	copied := *alias
+	r.zoneAliases[alias.ID] = &copied
+	return nil
+}
+
+// Delete deletes an alias
+func (r *zoneAliasRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.zoneAliases[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.zoneAliases, id)
+	return nil
+}
+
+// filter returns copies of the matching aliases ordered by alias
+func (r *zoneAliasRepository) filter(match func(*store.ZoneAlias) bool) []*store.ZoneAlias {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	aliases := []*store.ZoneAlias{}
+	for _, alias := range r.zoneAliases {
+		if match(alias) {
+			copied := *alias
+			aliases = append(aliases, &copied)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	return aliases
+}
+
+// deviceTypeRepository implements store.DeviceTypeRepository
+type deviceTypeRepository struct {
+	*memory
+}
+
+// List returns all device types ordered by name
+func (r *deviceTypeRepository) List() ([]*store.DeviceType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deviceTypes := make([]*store.DeviceType, 0, len(r.deviceTypes))
+	for _, deviceType := range r.deviceTypes {
+		copied := *deviceType
+		deviceTypes = append(deviceTypes, &copied)
+	}
+	sort.Slice(deviceTypes, func(i, j int) bool { return deviceTypes[i].Name < deviceTypes[j].Name })
+	return deviceTypes, nil
+}
+
+// Get returns the device type with the given ID
+func (r *deviceTypeRepository) Get(id int64) (*store.DeviceType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deviceType, ok := r.deviceTypes[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *deviceType
+	return &copied, nil
+}
+
+// GetByName returns the device type with the given name
+func (r *deviceTypeRepository) GetByName(name string) (*store.DeviceType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, deviceType := range r.deviceTypes {
+		if deviceType.Name == name {
+			copied := *deviceType
+			return &copied, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Create stores a new device type and sets its ID
+func (r *deviceTypeRepository) Create(deviceType *store.DeviceType) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.deviceTypeNameTaken(deviceType.Name, 0) {
+		return store.ErrConflict
+	}
+
+	deviceType.ID = r.nextID("device_types")
+	copied := *deviceType
+	r.deviceTypes[deviceType.ID] = &copied
+	return nil
+}
+
+// Update stores the name and description of a device type
+func (r *deviceTypeRepository) Update(deviceType *store.DeviceType) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.deviceTypes[deviceType.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if r.deviceTypeNameTaken(deviceType.Name, deviceType.ID) {
+		return store.ErrConflict
+	}
+
+	stored.Name = deviceType.Name
+	stored.Description = deviceType.Description
+	stored.UpdatedAt = deviceType.UpdatedAt
+	return nil
+}
+
+// Delete deletes a device type with its operations and mappings
+func (r *deviceTypeRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deviceTypes[id]; !ok {
+		return store.ErrNotFound
+	}
+
+	r.deleteMappings(func(m *store.Mapping) bool { return m.DeviceTypeID == id })
+	for opID, operation := range r.operations {
+		if operation.DeviceTypeID == id {
+			delete(r.operations, opID)
+		}
+	}
+	delete(r.deviceTypes, id)
+	return nil
+}
+
+// deviceTypeNameTaken reports whether another device type has the name
+func (r *deviceTypeRepository) deviceTypeNameTaken(name string, exceptID int64) bool {
+	for id, deviceType := range r.deviceTypes {
+		if id != exceptID && deviceType.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// operationRepository implements store.OperationRepository
+type operationRepository struct {
+	*memory
+}
+
+// List returns all operations ordered by device type and name
+func (r *operationRepository) List() ([]*store.Operation, error) {
+	return r.filter(func(*store.Operation) bool { return true }), nil
+}
+
+// ListByDeviceType returns the operations of a device type ordered by name
+func (r *operationRepository) ListByDeviceType(deviceTypeID int64) ([]*store.Operation, error) {
+	return r.filter(func(o *store.Operation) bool { return o.DeviceTypeID == deviceTypeID }), nil
+}
+
+// Get returns the operation with the given ID
+func (r *operationRepository) Get(id int64) (*store.Operation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operation, ok := r.operations[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *operation
+	return &copied, nil
+}
+
+// GetByName returns the operation of a device type with the given name
+func (r *operationRepository) GetByName(deviceTypeID int64, name string) (*store.Operation, error) {
+	operations := r.filter(func(o *store.Operation) bool {
+		return o.DeviceTypeID == deviceTypeID && o.Name == name
+	})
+	if len(operations) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return operations[0], nil
+}
+
+// Create stores a new operation and sets its ID
+func (r *operationRepository) Create(operation *store.Operation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.operationNameTaken(operation.DeviceTypeID, operation.Name, 0) {
+		return store.ErrConflict
+	}
+	if _, ok := r.deviceTypes[operation.DeviceTypeID]; !ok {
+		return store.ErrNotFound
+	}
+
+	operation.ID = r.nextID("operations")
+	copied := *operation
+	r.operations[operation.ID] = &copied
+	return nil
+}
+
+// Update stores the name, device type and description of an operation
+func (r *operationRepository) Update(operation *store.Operation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.operations[operation.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if r.operationNameTaken(operation.DeviceTypeID, operation.Name, operation.ID) {
+		return store.ErrConflict
+	}
+	if _, ok := r.deviceTypes[operation.DeviceTypeID]; !ok {
+		return store.ErrNotFound
+	}
+
+	stored.Name = operation.Name
+	stored.DeviceTypeID = operation.DeviceTypeID
+	stored.Description = operation.Description
+	stored.UpdatedAt = operation.UpdatedAt
+	return nil
+}
+
+// Delete deletes an operation and its mappings
+func (r *operationRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.operations[id]; !ok {
+		return store.ErrNotFound
+	}
+
+	r.deleteMappings(func(m *store.Mapping) bool { return m.OperationID == id })
+	delete(r.operations, id)
+	return nil
+}
+
+// filter returns copies of the matching operations ordered by device type and name
+func (r *operationRepository) filter(match func(*store.Operation) bool) []*store.Operation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operations := []*store.Operation{}
+	for _, operation := range r.operations {
+		if match(operation) {
+			copied := *operation
+			operations = append(operations, &copied)
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].DeviceTypeID != operations[j].DeviceTypeID {
+			return operations[i].DeviceTypeID < operations[j].DeviceTypeID
+		}
+		return operations[i].Name < operations[j].Name
+	})
+	return operations
+}
+
+// operationNameTaken reports whether another operation of the device type has the name
+func (r *operationRepository) operationNameTaken(deviceTypeID int64, name string, exceptID int64) bool {
+	for id, operation := range r.operations {
+		if id != exceptID && operation.DeviceTypeID == deviceTypeID && operation.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingRepository implements store.MappingRepository
+type mappingRepository struct {
+	*memory
+}
+
+// List returns all mappings ordered by ID
+func (r *mappingRepository) List() ([]*store.Mapping, error) {
+	return r.filter(func(*store.Mapping) bool { return true }), nil
+}
+
+// ListByZone returns the mappings of a zone ordered by ID
+func (r *mappingRepository) ListByZone(zoneID int64) ([]*store.Mapping, error) {
+	return r.filter(func(m *store.Mapping) bool { return m.ZoneID == zoneID }), nil
+}
+
+// Get returns the mapping with the given ID
+func (r *mappingRepository) Get(id int64) (*store.Mapping, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mapping, ok := r.mappings[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *mapping
+	return &copied, nil
+}
+
+// Find returns the mapping of a zone, device type and operation
+func (r *mappingRepository) Find(zoneID, deviceTypeID, operationID int64) (*store.Mapping, error) {
+	mappings := r.filter(func(m *store.Mapping) bool {
+		return m.ZoneID == zoneID && m.DeviceTypeID == deviceTypeID && m.OperationID == operationID
+	})
+	if len(mappings) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return mappings[0], nil
+}
+
+// Create stores a new mapping and sets its ID
+func (r *mappingRepository) Create(mapping *store.Mapping) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mappingTaken(mapping, 0) {
+		return store.ErrConflict
+	}
+	if !r.mappingReferencesExist(mapping) {
+		return store.ErrNotFound
+	}
+
+	mapping.ID = r.nextID("mappings")
+	copied := *mapping
+	r.mappings[mapping.ID] = &copied
+	return nil
+}
+
+// Update stores all fields of a mapping except its creation time
+func (r *mappingRepository) Update(mapping *store.Mapping) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.mappings[mapping.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if r.mappingTaken(mapping, mapping.ID) {
+		return store.ErrConflict
+	}
+	if !r.mappingReferencesExist(mapping) {
+		return store.ErrNotFound
+	}
+
+	createdAt := stored.CreatedAt
+	*stored = *mapping
+	stored.CreatedAt = createdAt
+	return nil
+}
+
+// Delete deletes a mapping
+func (r *mappingRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.mappings[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.mappings, id)
+	return nil
+}
+
+// filter returns copies of the matching mappings ordered by ID
+func (r *mappingRepository) filter(match func(*store.Mapping) bool) []*store.Mapping {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	mappings := []*store.Mapping{}
+	for _, mapping := range r.mappings {
+		if match(mapping) {
+			copied := *mapping
+			mappings = append(mappings, &copied)
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].ID < mappings[j].ID })
+	return mappings
+}
+
+// mappingTaken reports whether another mapping has the same zone, device type and operation
+func (r *mappingRepository) mappingTaken(mapping *store.Mapping, exceptID int64) bool {
+	for id, other := range r.mappings {
+		if id != exceptID && other.ZoneID == mapping.ZoneID &&
+			other.DeviceTypeID == mapping.DeviceTypeID && other.OperationID == mapping.OperationID {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingReferencesExist reports whether the zone, device type and operation of a mapping exist
+func (r *mappingRepository) mappingReferencesExist(mapping *store.Mapping) bool {
+	_, zoneOK := r.zones[mapping.ZoneID]
+	_, deviceTypeOK := r.deviceTypes[mapping.DeviceTypeID]
+	_, operationOK := r.operations[mapping.OperationID]
+	return zoneOK && deviceTypeOK && operationOK
+}
+
+// sceneRepository implements store.SceneRepository
+type sceneRepository struct {
+	*memory
+}
+
+// List returns all scenes ordered by name
+func (r *sceneRepository) List() ([]*store.Scene, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scenes := make([]*store.Scene, 0, len(r.scenes))
+	for _, scene := range r.scenes {
+		copied := *scene
+		scenes = append(scenes, &copied)
+	}
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].Name < scenes[j].Name })
+	return scenes, nil
+}
+
+// Get returns the scene with the given ID
+func (r *sceneRepository) Get(id int64) (*store.Scene, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scene, ok := r.scenes[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	copied := *scene
+	return &copied, nil
+}
+
+// GetBySceneID returns the scene with the given scene ID, e.g. movie_mode
+func (r *sceneRepository) GetBySceneID(sceneID string) (*store.Scene, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, scene := range r.scenes {
+		if scene.SceneID == sceneID {
+			copied := *scene
+			return &copied, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+// Create stores a new scene and sets its ID
+func (r *sceneRepository) Create(scene *store.Scene) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sceneTaken(scene, 0) {
+		return store.ErrConflict
+	}
+
+	scene.ID = r.nextID("scenes")
+	copied := *scene
+	r.scenes[scene.ID] = &copied
+	return nil
+}
+
+// Update stores all fields of a scene except its creation time
+func (r *sceneRepository) Update(scene *store.Scene) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.scenes[scene.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if r.sceneTaken(scene, scene.ID) {
+		return store.ErrConflict
+	}
+
+	createdAt := stored.CreatedAt
+	*stored = *scene
+	stored.CreatedAt = createdAt
+	return nil
+}
+
+// Delete deletes a scene
+func (r *sceneRepository) Delete(id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.scenes[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.scenes, id)
+	return nil
+}
+
+// sceneTaken reports whether another scene has the same name or scene ID
+func (r *sceneRepository) sceneTaken(scene *store.Scene, exceptID int64) bool {
+	for id, other := range r.scenes {
+		if id != exceptID && (other.Name == scene.Name || other.SceneID == scene.SceneID) {
+			return true
+		}
+	}
+	return false
+}
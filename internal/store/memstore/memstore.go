@@ -0,0 +1,145 @@
+// Package memstore implements the store repositories in memory, for tests
+// and setups that do not need persistence
+package memstore
+
+import (
+	"sync"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// memory holds the data of all repositories behind one lock so deletes can
+// cascade across them like the SQLite foreign keys do
+type memory struct {
+	mu sync.RWMutex
+
+	zones       map[int64]*store.Zone
+	zoneAliases map[int64]*store.ZoneAlias
+	deviceTypes map[int64]*store.DeviceType
+	operations  map[int64]*store.Operation
+	mappings    map[int64]*store.Mapping
+	scenes      map[int64]*store.Scene
+	nonces      map[string]int64
+	users       map[string]*userRecord
+
+	// lastID holds the last assigned ID per table, IDs are never reused
+	lastID map[string]int64
+}
+
+// userRecord is a stored user with its password hash
+type userRecord struct {
+	user store.User
+	hash string
+}
+
+// New creates empty in-memory repositories
+func New() *store.Store {
+	m := &memory{
+		zones:       make(map[int64]*store.Zone),
+		zoneAliases: make(map[int64]*store.ZoneAlias),
+		deviceTypes: make(map[int64]*store.DeviceType),
+		operations:  make(map[int64]*store.Operation),
+		mappings:    make(map[int64]*store.Mapping),
+		scenes:      make(map[int64]*store.Scene),
+		nonces:      make(map[string]int64),
+		users:       make(map[string]*userRecord),
+		lastID:      make(map[string]int64),
+	}
+
+	s := m.repositories()
+	s.Transactor = m
+	return s
+}
+
+// repositories returns the repositories on m
+func (m *memory) repositories() *store.Store {
+	return &store.Store{
+		Zones:       &zoneRepository{m},
+		ZoneAliases: &zoneAliasRepository{m},
+		DeviceTypes: &deviceTypeRepository{m},
+		Operations:  &operationRepository{m},
+		Mappings:    &mappingRepository{m},
+		Scenes:      &sceneRepository{m},
+		Nonces:      &nonceRepository{m},
+		Users:       &userRepository{m},
+	}
+}
+
+// WithTx runs fn with repositories on a copy of the data, which replaces the
+// data if fn succeeds. The data stays locked meanwhile, like the database
+// during a write transaction.
+func (m *memory) WithTx(fn func(tx *store.Store) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.clone()
+	s := tx.repositories()
+	s.Transactor = joinedTransactor{store: s}
+	if err := fn(s); err != nil {
+		return err
+	}
+
+	m.zones, m.zoneAliases, m.deviceTypes = tx.zones, tx.zoneAliases, tx.deviceTypes
+	m.operations, m.mappings, m.scenes = tx.operations, tx.mappings, tx.scenes
+	m.nonces, m.users, m.lastID = tx.nonces, tx.users, tx.lastID
+	return nil
+}
+
+// clone returns a deep copy of the data. The caller must hold the lock.
+func (m *memory) clone() *memory {
+	return &memory{
+		zones:       cloneRecords(m.zones),
+		zoneAliases: cloneRecords(m.zoneAliases),
+		deviceTypes: cloneRecords(m.deviceTypes),
+		operations:  cloneRecords(m.operations),
+		mappings:    cloneRecords(m.mappings),
+		scenes:      cloneRecords(m.scenes),
+		nonces:      cloneMap(m.nonces),
+		users:       cloneRecords(m.users),
+		lastID:      cloneMap(m.lastID),
+	}
+}
+
+// cloneRecords copies a map together with the records it points to
+func cloneRecords[K comparable, V any](records map[K]*V) map[K]*V {
+	copied := make(map[K]*V, len(records))
+	for key, record := range records {
+		value := *record
+		copied[key] = &value
+	}
+	return copied
+}
+
+// cloneMap copies a map of values
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	copied := make(map[K]V, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
+}
+
+// joinedTransactor runs nested transactions as part of the outer one
+type joinedTransactor struct {
+	store *store.Store
+}
+
+// WithTx runs fn with the repositories of the outer transaction
+func (t joinedTransactor) WithTx(fn func(tx *store.Store) error) error {
+	return fn(t.store)
+}
+
+// nextID assigns the next ID of a table. The caller must hold the write lock.
+func (m *memory) nextID(table string) int64 {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// deleteMappings removes the mappings matching a predicate. The caller must hold the write lock.
+func (m *memory) deleteMappings(match func(*store.Mapping) bool) {
+	for id, mapping := range m.mappings {
+		if match(mapping) {
+			delete(m.mappings, id)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+package memstore
+
+import (
+	"testing"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+	"github.com/boringsoft/ha-mi/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(*testing.T) *store.Store { return New() })
+}
@@ -0,0 +1,46 @@
+package memstore
+
+import "github.com/boringsoft/ha-mi/internal/store"
+
+// nonceRepository implements store.NonceRepository
+type nonceRepository struct {
+	*memory
+}
+
+// Create stores a nonce
+func (r *nonceRepository) Create(nonce string, expiresAt int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nonces[nonce]; ok {
+		return store.ErrConflict
+	}
+	r.nonces[nonce] = expiresAt
+	return nil
+}
+
+// Take removes a nonce and returns its expiry
+func (r *nonceRepository) Take(nonce string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt, ok := r.nonces[nonce]
+	if !ok {
+		return 0, store.ErrNotFound
+	}
+	delete(r.nonces, nonce)
+	return expiresAt, nil
+}
+
+// DeleteExpired removes the nonces that expired before the given time
+func (r *nonceRepository) DeleteExpired(before int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for nonce, expiresAt := range r.nonces {
+		if expiresAt < before {
+			delete(r.nonces, nonce)
+		}
+	}
+	return nil
+}
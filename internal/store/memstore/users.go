@@ -0,0 +1,142 @@
+package memstore
+
+import (
+	"sort"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// userRepository implements store.UserRepository
+type userRepository struct {
+	*memory
+}
+
+// List returns all users ordered by username
+func (r *userRepository) List() ([]*store.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]*store.User, 0, len(r.users))
+	for _, record := range r.users {
+		user := record.user
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+// Get returns the user with the given ID
+func (r *userRepository) Get(id string) (*store.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	record, ok := r.users[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	user := record.user
+	return &user, nil
+}
+
+// GetByUsername returns the user with the given username
+func (r *userRepository) GetByUsername(username string) (*store.User, error) {
+	user, _, err := r.GetWithPasswordHash(username)
+	return user, err
+}
+
+// GetWithPasswordHash returns a user and its password hash by username
+func (r *userRepository) GetWithPasswordHash(username string) (*store.User, string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, record := range r.users {
+		if record.user.Username == username {
+			user := record.user
+			return &user, record.hash, nil
+		}
+	}
+	return nil, "", store.ErrNotFound
+}
+
+// Create stores a new user
+func (r *userRepository) Create(user *store.User, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.ID]; ok {
+		return store.ErrConflict
+	}
+	for _, record := range r.users {
+		if record.user.Username == user.Username {
+			return store.ErrConflict
+		}
+	}
+
+	r.users[user.ID] = &userRecord{user: *user, hash: passwordHash}
+	return nil
+}
+
+// Update stores the email, role and update time of a user
+func (r *userRepository) Update(user *store.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.users[user.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	record.user.Email = user.Email
+	record.user.Role = user.Role
+	record.user.UpdatedAt = user.UpdatedAt
+	return nil
+}
+
+// SetPasswordHash replaces the password hash of a user
+func (r *userRepository) SetPasswordHash(id, passwordHash string, updatedAt int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.users[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	record.hash = passwordHash
+	record.user.UpdatedAt = updatedAt
+	return nil
+}
+
+// Delete deletes a user
+func (r *userRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(r.users, id)
+	return nil
+}
+
+// Count returns the number of users
+func (r *userRepository) Count() (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.users), nil
+}
+
+// CountByRole returns the number of users with a role
+func (r *userRepository) CountByRole(role string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, record := range r.users {
+		if record.user.Role == role {
+			count++
+		}
+	}
+	return count, nil
+}
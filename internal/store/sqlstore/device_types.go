@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const deviceTypeColumns = "id, name, description, created_at, updated_at"
+
+// deviceTypeRepository implements store.DeviceTypeRepository
+type deviceTypeRepository struct {
+	db querier
+}
+
+// List returns all device types ordered by name
+func (r *deviceTypeRepository) List() ([]*store.DeviceType, error) {
+	rows, err := r.db.Query("SELECT " + deviceTypeColumns + " FROM device_types ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying device types: %w", err)
+	}
+	defer rows.Close()
+
+	deviceTypes := []*store.DeviceType{}
+	for rows.Next() {
+		deviceType, err := scanDeviceType(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning device type: %w", err)
+		}
+		deviceTypes = append(deviceTypes, deviceType)
+	}
+	return deviceTypes, rows.Err()
+}
+
+// Get returns the device type with the given ID
+func (r *deviceTypeRepository) Get(id int64) (*store.DeviceType, error) {
+	return r.queryDeviceType("SELECT "+deviceTypeColumns+" FROM device_types WHERE id = ?", id)
+}
+
+// GetByName returns the device type with the given name
+func (r *deviceTypeRepository) GetByName(name string) (*store.DeviceType, error) {
+	return r.queryDeviceType("SELECT "+deviceTypeColumns+" FROM device_types WHERE name = ?", name)
+}
+
+// Create stores a new device type and sets its ID
+func (r *deviceTypeRepository) Create(deviceType *store.DeviceType) error {
+	result, err := r.db.Exec(
+		"INSERT INTO device_types (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		deviceType.Name, nullString(deviceType.Description), deviceType.CreatedAt, deviceType.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store device type: %w", err)
+	}
+
+	deviceType.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update stores the name and description of a device type
+func (r *deviceTypeRepository) Update(deviceType *store.DeviceType) error {
+	result, err := r.db.Exec(
+		"UPDATE device_types SET name = ?, description = ?, updated_at = ? WHERE id = ?",
+		deviceType.Name, nullString(deviceType.Description), deviceType.UpdatedAt, deviceType.ID,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to update device type: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes a device type with its operations and mappings
+func (r *deviceTypeRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM device_types WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete device type: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryDeviceType runs a single-row device type query
+func (r *deviceTypeRepository) queryDeviceType(query string, args ...interface{}) (*store.DeviceType, error) {
+	deviceType, err := scanDeviceType(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying device type: %w", err)
+	}
+	return deviceType, nil
+}
+
+// scanDeviceType scans a row selected with deviceTypeColumns
+func scanDeviceType(row scanner) (*store.DeviceType, error) {
+	deviceType := &store.DeviceType{}
+	var description sql.NullString
+	if err := row.Scan(&deviceType.ID, &deviceType.Name, &description, &deviceType.CreatedAt, &deviceType.UpdatedAt); err != nil {
+		return nil, err
+	}
+	deviceType.Description = description.String
+	return deviceType, nil
+}
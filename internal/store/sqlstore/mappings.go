@@ -0,0 +1,135 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const mappingColumns = "id, zone_id, device_type_id, operation_id, entity_id, service, params, value_mapping, created_at, updated_at"
+
+// mappingRepository implements store.MappingRepository
+type mappingRepository struct {
+	db querier
+}
+
+// List returns all mappings ordered by ID
+func (r *mappingRepository) List() ([]*store.Mapping, error) {
+	return r.queryMappings("SELECT " + mappingColumns + " FROM mappings ORDER BY id")
+}
+
+// ListByZone returns the mappings of a zone ordered by ID
+func (r *mappingRepository) ListByZone(zoneID int64) ([]*store.Mapping, error) {
+	return r.queryMappings("SELECT "+mappingColumns+" FROM mappings WHERE zone_id = ? ORDER BY id", zoneID)
+}
+
+// Get returns the mapping with the given ID
+func (r *mappingRepository) Get(id int64) (*store.Mapping, error) {
+	return r.queryMapping("SELECT "+mappingColumns+" FROM mappings WHERE id = ?", id)
+}
+
+// Find returns the mapping of a zone, device type and operation
+func (r *mappingRepository) Find(zoneID, deviceTypeID, operationID int64) (*store.Mapping, error) {
+	return r.queryMapping(
+		"SELECT "+mappingColumns+" FROM mappings WHERE zone_id = ? AND device_type_id = ? AND operation_id = ?",
+		zoneID, deviceTypeID, operationID,
+	)
+}
+
+// Create stores a new mapping and sets its ID
+func (r *mappingRepository) Create(mapping *store.Mapping) error {
+	result, err := r.db.Exec(
+		"INSERT INTO mappings (zone_id, device_type_id, operation_id, entity_id, service, params, value_mapping, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		mapping.ZoneID, mapping.DeviceTypeID, mapping.OperationID, mapping.EntityID, mapping.Service,
+		nullString(mapping.Params), nullString(mapping.ValueMapping), mapping.CreatedAt, mapping.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsForeignKeyError(err) {
+			return store.ErrNotFound
+		}
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store mapping: %w", err)
+	}
+
+	mapping.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update stores all fields of a mapping except its creation time
+func (r *mappingRepository) Update(mapping *store.Mapping) error {
+	result, err := r.db.Exec(
+		"UPDATE mappings SET zone_id = ?, device_type_id = ?, operation_id = ?, entity_id = ?, service = ?, params = ?, value_mapping = ?, updated_at = ? WHERE id = ?",
+		mapping.ZoneID, mapping.DeviceTypeID, mapping.OperationID, mapping.EntityID, mapping.Service,
+		nullString(mapping.Params), nullString(mapping.ValueMapping), mapping.UpdatedAt, mapping.ID,
+	)
+	if err != nil {
+		if db.IsForeignKeyError(err) {
+			return store.ErrNotFound
+		}
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to update mapping: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes a mapping
+func (r *mappingRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM mappings WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete mapping: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryMappings runs a multi-row mapping query
+func (r *mappingRepository) queryMappings(query string, args ...interface{}) ([]*store.Mapping, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying mappings: %w", err)
+	}
+	defer rows.Close()
+
+	mappings := []*store.Mapping{}
+	for rows.Next() {
+		mapping, err := scanMapping(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning mapping: %w", err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, rows.Err()
+}
+
+// queryMapping runs a single-row mapping query
+func (r *mappingRepository) queryMapping(query string, args ...interface{}) (*store.Mapping, error) {
+	mapping, err := scanMapping(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying mapping: %w", err)
+	}
+	return mapping, nil
+}
+
+// scanMapping scans a row selected with mappingColumns
+func scanMapping(row scanner) (*store.Mapping, error) {
+	mapping := &store.Mapping{}
+	var params, valueMapping sql.NullString
+	err := row.Scan(
+		&mapping.ID, &mapping.ZoneID, &mapping.DeviceTypeID, &mapping.OperationID, &mapping.EntityID,
+		&mapping.Service, &params, &valueMapping, &mapping.CreatedAt, &mapping.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	mapping.Params = params.String
+	mapping.ValueMapping = valueMapping.String
+	return mapping, nil
+}
@@ -0,0 +1,48 @@
+package sqlstore
+
+import (
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// nonceRepository implements store.NonceRepository
+type nonceRepository struct {
+	db querier
+}
+
+// Create stores a nonce
+func (r *nonceRepository) Create(nonce string, expiresAt int64) error {
+	_, err := r.db.Exec("INSERT INTO nonces (nonce, expires_at) VALUES (?, ?)", nonce, expiresAt)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store nonce: %w", err)
+	}
+	return nil
+}
+
+// Take removes a nonce and returns its expiry. A single DELETE takes the
+// write lock right away, so concurrent callers wait for each other instead
+// of failing with SQLITE_BUSY when upgrading a read transaction.
+func (r *nonceRepository) Take(nonce string) (int64, error) {
+	var expiresAt int64
+	err := r.db.QueryRow("DELETE FROM nonces WHERE nonce = ? RETURNING expires_at", nonce).Scan(&expiresAt)
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return 0, err
+		}
+		return 0, fmt.Errorf("error deleting used nonce: %w", err)
+	}
+	return expiresAt, nil
+}
+
+// DeleteExpired removes the nonces that expired before the given time
+func (r *nonceRepository) DeleteExpired(before int64) error {
+	if _, err := r.db.Exec("DELETE FROM nonces WHERE expires_at < ?", before); err != nil {
+		return fmt.Errorf("error cleaning up expired nonces: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const operationColumns = "id, name, device_type_id, description, created_at, updated_at"
+
+// operationRepository implements store.OperationRepository
+type operationRepository struct {
+	db querier
+}
+
+// List returns all operations ordered by device type and name
+func (r *operationRepository) List() ([]*store.Operation, error) {
+	return r.queryOperations("SELECT " + operationColumns + " FROM operations ORDER BY device_type_id, name")
+}
+
+// ListByDeviceType returns the operations of a device type ordered by name
+func (r *operationRepository) ListByDeviceType(deviceTypeID int64) ([]*store.Operation, error) {
+	return r.queryOperations("SELECT "+operationColumns+" FROM operations WHERE device_type_id = ? ORDER BY name", deviceTypeID)
+}
+
+// Get returns the operation with the given ID
+func (r *operationRepository) Get(id int64) (*store.Operation, error) {
+	return r.queryOperation("SELECT "+operationColumns+" FROM operations WHERE id = ?", id)
+}
+
+// GetByName returns the operation of a device type with the given name
+func (r *operationRepository) GetByName(deviceTypeID int64, name string) (*store.Operation, error) {
+	return r.queryOperation("SELECT "+operationColumns+" FROM operations WHERE device_type_id = ? AND name = ?", deviceTypeID, name)
+}
+
+// Create stores a new operation and sets its ID
+func (r *operationRepository) Create(operation *store.Operation) error {
+	result, err := r.db.Exec(
+		"INSERT INTO operations (name, device_type_id, description, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		operation.Name, operation.DeviceTypeID, nullString(operation.Description), operation.CreatedAt, operation.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsForeignKeyError(err) {
+			return store.ErrNotFound
+		}
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store operation: %w", err)
+	}
+
+	operation.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update stores the name, device type and description of an operation
+func (r *operationRepository) Update(operation *store.Operation) error {
+	result, err := r.db.Exec(
+		"UPDATE operations SET name = ?, device_type_id = ?, description = ?, updated_at = ? WHERE id = ?",
+		operation.Name, operation.DeviceTypeID, nullString(operation.Description), operation.UpdatedAt, operation.ID,
+	)
+	if err != nil {
+		if db.IsForeignKeyError(err) {
+			return store.ErrNotFound
+		}
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to update operation: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes an operation and its mappings
+func (r *operationRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM operations WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete operation: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryOperations runs a multi-row operation query
+func (r *operationRepository) queryOperations(query string, args ...interface{}) ([]*store.Operation, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying operations: %w", err)
+	}
+	defer rows.Close()
+
+	operations := []*store.Operation{}
+	for rows.Next() {
+		operation, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning operation: %w", err)
+		}
+		operations = append(operations, operation)
+	}
+	return operations, rows.Err()
+}
+
+// queryOperation runs a single-row operation query
+func (r *operationRepository) queryOperation(query string, args ...interface{}) (*store.Operation, error) {
+	operation, err := scanOperation(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying operation: %w", err)
+	}
+	return operation, nil
+}
+
+// scanOperation scans a row selected with operationColumns
+func scanOperation(row scanner) (*store.Operation, error) {
+	operation := &store.Operation{}
+	var description sql.NullString
+	if err := row.Scan(&operation.ID, &operation.Name, &operation.DeviceTypeID, &description, &operation.CreatedAt, &operation.UpdatedAt); err != nil {
+		return nil, err
+	}
+	operation.Description = description.String
+	return operation, nil
+}
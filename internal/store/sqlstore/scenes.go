@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const sceneColumns = "id, name, scene_id, description, actions, created_at, updated_at"
+
+// sceneRepository implements store.SceneRepository
+type sceneRepository struct {
+	db querier
+}
+
+// List returns all scenes ordered by name
+func (r *sceneRepository) List() ([]*store.Scene, error) {
+	rows, err := r.db.Query("SELECT " + sceneColumns + " FROM scenes ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying scenes: %w", err)
+	}
+	defer rows.Close()
+
+	scenes := []*store.Scene{}
+	for rows.Next() {
+		scene, err := scanScene(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning scene: %w", err)
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, rows.Err()
+}
+
+// Get returns the scene with the given ID
+func (r *sceneRepository) Get(id int64) (*store.Scene, error) {
+	return r.queryScene("SELECT "+sceneColumns+" FROM scenes WHERE id = ?", id)
+}
+
+// GetBySceneID returns the scene with the given scene ID, e.g. movie_mode
+func (r *sceneRepository) GetBySceneID(sceneID string) (*store.Scene, error) {
+	return r.queryScene("SELECT "+sceneColumns+" FROM scenes WHERE scene_id = ?", sceneID)
+}
+
+// Create stores a new scene and sets its ID
+func (r *sceneRepository) Create(scene *store.Scene) error {
+	result, err := r.db.Exec(
+		"INSERT INTO scenes (name, scene_id, description, actions, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		scene.Name, scene.SceneID, nullString(scene.Description), scene.Actions, scene.CreatedAt, scene.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store scene: %w", err)
+	}
+
+	scene.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update stores all fields of a scene except its creation time
+func (r *sceneRepository) Update(scene *store.Scene) error {
+	result, err := r.db.Exec(
+		"UPDATE scenes SET name = ?, scene_id = ?, description = ?, actions = ?, updated_at = ? WHERE id = ?",
+		scene.Name, scene.SceneID, nullString(scene.Description), scene.Actions, scene.UpdatedAt, scene.ID,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to update scene: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes a scene
+func (r *sceneRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM scenes WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete scene: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryScene runs a single-row scene query
+func (r *sceneRepository) queryScene(query string, args ...interface{}) (*store.Scene, error) {
+	scene, err := scanScene(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying scene: %w", err)
+	}
+	return scene, nil
+}
+
+// scanScene scans a row selected with sceneColumns
+func scanScene(row scanner) (*store.Scene, error) {
+	scene := &store.Scene{}
+	var description sql.NullString
+	if err := row.Scan(&scene.ID, &scene.Name, &scene.SceneID, &description, &scene.Actions, &scene.CreatedAt, &scene.UpdatedAt); err != nil {
+		return nil, err
+	}
+	scene.Description = description.String
+	return scene, nil
+}
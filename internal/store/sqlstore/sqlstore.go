@@ -0,0 +1,104 @@
+// Package sqlstore implements the store repositories on SQLite, with either
+// of the database drivers
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// New creates the SQLite repositories on an open, migrated database
+func New(db *sql.DB) *store.Store {
+	s := newStore(db)
+	s.Transactor = transactor{db: db}
+	return s
+}
+
+// newStore creates the repositories on a database or a transaction
+func newStore(db querier) *store.Store {
+	return &store.Store{
+		Zones:       &zoneRepository{db: db},
+		ZoneAliases: &zoneAliasRepository{db: db},
+		DeviceTypes: &deviceTypeRepository{db: db},
+		Operations:  &operationRepository{db: db},
+		Mappings:    &mappingRepository{db: db},
+		Scenes:      &sceneRepository{db: db},
+		Nonces:      &nonceRepository{db: db},
+		Users:       &userRepository{db: db},
+	}
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// transactor implements store.Transactor with database transactions
+type transactor struct {
+	db *sql.DB
+}
+
+// WithTx runs fn with repositories on a new transaction
+func (t transactor) WithTx(fn func(tx *store.Store) error) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	s := newStore(tx)
+	s.Transactor = joinedTransactor{store: s}
+	if err := fn(s); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// joinedTransactor runs nested transactions as part of the outer one
+type joinedTransactor struct {
+	store *store.Store
+}
+
+// WithTx runs fn with the repositories of the outer transaction
+func (t joinedTransactor) WithTx(fn func(tx *store.Store) error) error {
+	return fn(t.store)
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// wrapNotFound turns sql.ErrNoRows into store.ErrNotFound
+func wrapNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// checkAffected returns store.ErrNotFound when a statement changed no rows
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// nullString stores empty strings as NULL in nullable columns
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,32 @@
+package sqlstore
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+	"github.com/boringsoft/ha-mi/internal/store/storetest"
+)
+
+// TestConformance runs the suite on every compiled-in driver: both drivers
+// with CGO_ENABLED=1, only the pure Go driver with CGO_ENABLED=0
+func TestConformance(t *testing.T) {
+	for _, driver := range db.Drivers() {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) *store.Store {
+				database, err := db.New(driver, filepath.Join(t.TempDir(), "ha-mi.db"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { database.Close() })
+
+				if err := database.Initialize(); err != nil {
+					t.Fatal(err)
+				}
+				return New(database.DB)
+			})
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package sqlstore
+
+import (
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const userColumns = "id, username, email, role, created_at, updated_at"
+
+// userRepository implements store.UserRepository
+type userRepository struct {
+	db querier
+}
+
+// List returns all users ordered by username
+func (r *userRepository) List() ([]*store.User, error) {
+	rows, err := r.db.Query("SELECT " + userColumns + " FROM users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("error querying users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*store.User{}
+	for rows.Next() {
+		user := &store.User{}
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Get returns the user with the given ID
+func (r *userRepository) Get(id string) (*store.User, error) {
+	return r.queryUser("SELECT "+userColumns+" FROM users WHERE id = ?", id)
+}
+
+// GetByUsername returns the user with the given username
+func (r *userRepository) GetByUsername(username string) (*store.User, error) {
+	return r.queryUser("SELECT "+userColumns+" FROM users WHERE username = ?", username)
+}
+
+// GetWithPasswordHash returns a user and its password hash by username
+func (r *userRepository) GetWithPasswordHash(username string) (*store.User, string, error) {
+	var hash string
+	user := &store.User{}
+	err := r.db.QueryRow(
+		"SELECT "+userColumns+", password_hash FROM users WHERE username = ?", username,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt, &hash)
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("error querying user: %w", err)
+	}
+	return user, hash, nil
+}
+
+// Create stores a new user
+func (r *userRepository) Create(user *store.User, passwordHash string) error {
+	_, err := r.db.Exec(
+		"INSERT INTO users (id, username, email, password_hash, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.ID, user.Username, user.Email, passwordHash, user.Role, user.CreatedAt, user.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+// Update stores the email, role and update time of a user
+func (r *userRepository) Update(user *store.User) error {
+	result, err := r.db.Exec("UPDATE users SET email = ?, role = ?, updated_at = ? WHERE id = ?", user.Email, user.Role, user.UpdatedAt, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// SetPasswordHash replaces the password hash of a user
+func (r *userRepository) SetPasswordHash(id, passwordHash string, updatedAt int64) error {
+	result, err := r.db.Exec("UPDATE users SET password_hash = ?, updated_at = ? WHERE id = ?", passwordHash, updatedAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes a user, the foreign keys remove its sessions, API keys and MFA settings
+func (r *userRepository) Delete(id string) error {
+	result, err := r.db.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Count returns the number of users
+func (r *userRepository) Count() (int, error) {
+	var count int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting users: %w", err)
+	}
+	return count, nil
+}
+
+// CountByRole returns the number of users with a role
+func (r *userRepository) CountByRole(role string) (int, error) {
+	var count int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting users: %w", err)
+	}
+	return count, nil
+}
+
+// queryUser runs a single-row user query
+func (r *userRepository) queryUser(query string, args ...interface{}) (*store.User, error) {
+	user := &store.User{}
+	err := r.db.QueryRow(query, args...).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying user: %w", err)
+	}
+	return user, nil
+}
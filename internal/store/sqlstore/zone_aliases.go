@@ -0,0 +1,94 @@
+package sqlstore
+
+import (
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const zoneAliasColumns = "id, zone_id, alias, created_at"
+
+// zoneAliasRepository implements store.ZoneAliasRepository
+type zoneAliasRepository struct {
+	db querier
+}
+
+// List returns all aliases ordered by alias
+func (r *zoneAliasRepository) List() ([]*store.ZoneAlias, error) {
+	return r.queryAliases("SELECT " + zoneAliasColumns + " FROM zone_aliases ORDER BY alias")
+}
+
+// ListByZone returns the aliases of a zone ordered by alias
+func (r *zoneAliasRepository) ListByZone(zoneID int64) ([]*store.ZoneAlias, error) {
+	return r.queryAliases("SELECT "+zoneAliasColumns+" FROM zone_aliases WHERE zone_id = ? ORDER BY alias", zoneID)
+}
+
+// GetByAlias returns the alias with the given name
+func (r *zoneAliasRepository) GetByAlias(alias string) (*store.ZoneAlias, error) {
+	zoneAlias, err := scanZoneAlias(r.db.QueryRow("SELECT "+zoneAliasColumns+" FROM zone_aliases WHERE alias = ?", alias))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying zone alias: %w", err)
+	}
+	return zoneAlias, nil
+}
+
+// Create stores a new alias and sets its ID
+func (r *zoneAliasRepository) Create(alias *store.ZoneAlias) error {
+	result, err := r.db.Exec(
+		"INSERT INTO zone_aliases (zone_id, alias, created_at) VALUES (?, ?, ?)",
+		alias.ZoneID, alias.Alias, alias.CreatedAt,
+	)
+	if err != nil {
+		if db.IsForeignKeyError(err) {
+			return store.ErrNotFound
+		}
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store zone alias: %w", err)
+	}
+
+	alias.ID, err = result.LastInsertId()
+	return err
+}
+
+// Delete deletes an alias
+func (r *zoneAliasRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM zone_aliases WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone alias: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryAliases runs a multi-row alias query
+func (r *zoneAliasRepository) queryAliases(query string, args ...interface{}) ([]*store.ZoneAlias, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying zone aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := []*store.ZoneAlias{}
+	for rows.Next() {
+		alias, err := scanZoneAlias(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning zone alias: %w", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// scanZoneAlias scans a row selected with zoneAliasColumns
+func scanZoneAlias(row scanner) (*store.ZoneAlias, error) {
+	alias := &store.ZoneAlias{}
+	if err := row.Scan(&alias.ID, &alias.ZoneID, &alias.Alias, &alias.CreatedAt); err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
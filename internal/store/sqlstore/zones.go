@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/boringsoft/ha-mi/internal/db"
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+const zoneColumns = "id, name, description, created_at, updated_at"
+
+// zoneRepository implements store.ZoneRepository
+type zoneRepository struct {
+	db querier
+}
+
+// List returns all zones ordered by name
+func (r *zoneRepository) List() ([]*store.Zone, error) {
+	rows, err := r.db.Query("SELECT " + zoneColumns + " FROM zones ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying zones: %w", err)
+	}
+	defer rows.Close()
+
+	zones := []*store.Zone{}
+	for rows.Next() {
+		zone, err := scanZone(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning zone: %w", err)
+		}
+		zones = append(zones, zone)
+	}
+	return zones, rows.Err()
+}
+
+// Get returns the zone with the given ID
+func (r *zoneRepository) Get(id int64) (*store.Zone, error) {
+	return r.queryZone("SELECT "+zoneColumns+" FROM zones WHERE id = ?", id)
+}
+
+// GetByName returns the zone with the given name
+func (r *zoneRepository) GetByName(name string) (*store.Zone, error) {
+	return r.queryZone("SELECT "+zoneColumns+" FROM zones WHERE name = ?", name)
+}
+
+// Create stores a new zone and sets its ID
+func (r *zoneRepository) Create(zone *store.Zone) error {
+	result, err := r.db.Exec(
+		"INSERT INTO zones (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)",
+		zone.Name, nullString(zone.Description), zone.CreatedAt, zone.UpdatedAt,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to store zone: %w", err)
+	}
+
+	zone.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update stores the name and description of a zone
+func (r *zoneRepository) Update(zone *store.Zone) error {
+	result, err := r.db.Exec(
+		"UPDATE zones SET name = ?, description = ?, updated_at = ? WHERE id = ?",
+		zone.Name, nullString(zone.Description), zone.UpdatedAt, zone.ID,
+	)
+	if err != nil {
+		if db.IsConstraintError(err) {
+			return store.ErrConflict
+		}
+		return fmt.Errorf("failed to update zone: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// Delete deletes a zone, its aliases and its mappings
+func (r *zoneRepository) Delete(id int64) error {
+	result, err := r.db.Exec("DELETE FROM zones WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete zone: %w", err)
+	}
+	return checkAffected(result)
+}
+
+// queryZone runs a single-row zone query
+func (r *zoneRepository) queryZone(query string, args ...interface{}) (*store.Zone, error) {
+	zone, err := scanZone(r.db.QueryRow(query, args...))
+	if err != nil {
+		if err = wrapNotFound(err); err == store.ErrNotFound {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error querying zone: %w", err)
+	}
+	return zone, nil
+}
+
+// scanZone scans a row selected with zoneColumns
+func scanZone(row scanner) (*store.Zone, error) {
+	zone := &store.Zone{}
+	var description sql.NullString
+	if err := row.Scan(&zone.ID, &zone.Name, &description, &zone.CreatedAt, &zone.UpdatedAt); err != nil {
+		return nil, err
+	}
+	zone.Description = description.String
+	return zone, nil
+}
@@ -0,0 +1,212 @@
+// Package store defines the repositories the services persist their data
+// through. Implementations live in the sqlstore (SQLite) and memstore
+// (in-memory) packages, and the storetest package checks that they behave
+// the same.
+//
+// The catalog and the user accounts are stored through these repositories.
+// Roles, sessions, API keys, MFA, lockouts, the audit log and the signing
+// keys are not yet, their services still use the SQLite database directly.
+package store
+
+import "errors"
+
+// Repository errors
+var (
+	// ErrNotFound is returned when the requested record does not exist, or
+	// when a record refers to a missing zone, device type or operation
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a record violates a uniqueness constraint
+	ErrConflict = errors.New("record already exists")
+)
+
+// Store groups the repositories of one storage backend
+type Store struct {
+	Zones       ZoneRepository
+	ZoneAliases ZoneAliasRepository
+	DeviceTypes DeviceTypeRepository
+	Operations  OperationRepository
+	Mappings    MappingRepository
+	Scenes      SceneRepository
+	Nonces      NonceRepository
+	Users       UserRepository
+
+	Transactor Transactor
+}
+
+// Transactor runs a function with repositories bound to one transaction
+type Transactor interface {
+	// WithTx runs fn with repositories whose changes are committed together
+	// if fn returns nil and discarded otherwise. Other writers wait until
+	// the transaction ends, so fn must only use the repositories it is given.
+	// Calling WithTx on those repositories joins the running transaction.
+	WithTx(fn func(tx *Store) error) error
+}
+
+// WithTx runs fn in a transaction, see Transactor
+func (s *Store) WithTx(fn func(tx *Store) error) error {
+	return s.Transactor.WithTx(fn)
+}
+
+// Zone is an area of the house such as 客厅
+type Zone struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// ZoneAlias is an alternative name of a zone, such as 大厅 for 客厅
+type ZoneAlias struct {
+	ID        int64  `json:"id"`
+	ZoneID    int64  `json:"zone_id"`
+	Alias     string `json:"alias"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// DeviceType is a kind of device such as 灯 or 窗帘
+type DeviceType struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// Operation is an action supported by a device type such as 开 or 亮度
+type Operation struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	DeviceTypeID int64  `json:"device_type_id"`
+	Description  string `json:"description"`
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// Mapping maps a zone, device type and operation to a Home Assistant service call.
+// Params and ValueMapping hold JSON documents.
+type Mapping struct {
+	ID           int64  `json:"id"`
+	ZoneID       int64  `json:"zone_id"`
+	DeviceTypeID int64  `json:"device_type_id"`
+	OperationID  int64  `json:"operation_id"`
+	EntityID     string `json:"entity_id"`
+	Service      string `json:"service"`
+	Params       string `json:"params"`
+	ValueMapping string `json:"value_mapping"`
+	CreatedAt    int64  `json:"created_at"`
+	UpdatedAt    int64  `json:"updated_at"`
+}
+
+// Scene is a named sequence of actions. Actions holds a JSON document.
+type Scene struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	SceneID     string `json:"scene_id"`
+	Description string `json:"description"`
+	Actions     string `json:"actions"`
+	CreatedAt   int64  `json:"created_at"`
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// User represents a user account
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// ZoneRepository persists zones. Deleting a zone deletes its aliases and mappings.
+type ZoneRepository interface {
+	List() ([]*Zone, error)
+	Get(id int64) (*Zone, error)
+	GetByName(name string) (*Zone, error)
+	Create(zone *Zone) error
+	Update(zone *Zone) error
+	Delete(id int64) error
+}
+
+// ZoneAliasRepository persists zone aliases, unique across all zones
+type ZoneAliasRepository interface {
+	// List returns all aliases ordered by alias
+	List() ([]*ZoneAlias, error)
+	ListByZone(zoneID int64) ([]*ZoneAlias, error)
+	GetByAlias(alias string) (*ZoneAlias, error)
+	Create(alias *ZoneAlias) error
+	Delete(id int64) error
+}
+
+// DeviceTypeRepository persists device types. Deleting a device type
+// deletes its operations and mappings.
+type DeviceTypeRepository interface {
+	List() ([]*DeviceType, error)
+	Get(id int64) (*DeviceType, error)
+	GetByName(name string) (*DeviceType, error)
+	Create(deviceType *DeviceType) error
+	Update(deviceType *DeviceType) error
+	Delete(id int64) error
+}
+
+// OperationRepository persists operations. Operation names are unique per
+// device type. Deleting an operation deletes its mappings.
+type OperationRepository interface {
+	List() ([]*Operation, error)
+	ListByDeviceType(deviceTypeID int64) ([]*Operation, error)
+	Get(id int64) (*Operation, error)
+	GetByName(deviceTypeID int64, name string) (*Operation, error)
+	Create(operation *Operation) error
+	Update(operation *Operation) error
+	Delete(id int64) error
+}
+
+// MappingRepository persists mappings, unique per zone, device type and operation
+type MappingRepository interface {
+	List() ([]*Mapping, error)
+	ListByZone(zoneID int64) ([]*Mapping, error)
+	Get(id int64) (*Mapping, error)
+	Find(zoneID, deviceTypeID, operationID int64) (*Mapping, error)
+	Create(mapping *Mapping) error
+	Update(mapping *Mapping) error
+	Delete(id int64) error
+}
+
+// SceneRepository persists scenes, unique by name and scene ID
+type SceneRepository interface {
+	List() ([]*Scene, error)
+	Get(id int64) (*Scene, error)
+	GetBySceneID(sceneID string) (*Scene, error)
+	Create(scene *Scene) error
+	Update(scene *Scene) error
+	Delete(id int64) error
+}
+
+// NonceRepository persists single-use nonces
+type NonceRepository interface {
+	// Create stores a nonce, failing with ErrConflict if it already exists
+	Create(nonce string, expiresAt int64) error
+	// Take removes a nonce and returns its expiry. Of concurrent callers
+	// only one succeeds, the others get ErrNotFound.
+	Take(nonce string) (int64, error)
+	// DeleteExpired removes the nonces that expired before the given time
+	DeleteExpired(before int64) error
+}
+
+// UserRepository persists user accounts and their password hashes
+type UserRepository interface {
+	// List returns all users ordered by username
+	List() ([]*User, error)
+	Get(id string) (*User, error)
+	GetByUsername(username string) (*User, error)
+	// GetWithPasswordHash returns a user and its password hash by username
+	GetWithPasswordHash(username string) (*User, string, error)
+	Create(user *User, passwordHash string) error
+	// Update stores the email, role and update time of a user
+	Update(user *User) error
+	SetPasswordHash(id, passwordHash string, updatedAt int64) error
+	Delete(id string) error
+	Count() (int, error)
+	CountByRole(role string) (int, error)
+}
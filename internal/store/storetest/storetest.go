@@ -0,0 +1,650 @@
+// Package storetest is a conformance suite for the store repositories. Every
+// implementation runs it, so the services see the same behaviour whichever
+// backend they are given.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/boringsoft/ha-mi/internal/store"
+)
+
+// Run runs the conformance suite. newStore must return empty repositories,
+// it is called once per test.
+func Run(t *testing.T, newStore func(t *testing.T) *store.Store) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *store.Store)
+	}{
+		{"Zones", testZones},
+		{"ZoneAliases", testZoneAliases},
+		{"DeviceTypes", testDeviceTypes},
+		{"Operations", testOperations},
+		{"Mappings", testMappings},
+		{"Scenes", testScenes},
+		{"CascadingDeletes", testCascadingDeletes},
+		{"Nonces", testNonces},
+		{"ConcurrentNonceTake", testConcurrentNonceTake},
+		{"ConcurrentWrites", testConcurrentWrites},
+		{"Transactions", testTransactions},
+		{"Users", testUsers},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+func testZones(t *testing.T, s *store.Store) {
+	living := &store.Zone{Name: "客厅", Description: "living room", CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Zones.Create(living))
+	bedroom := &store.Zone{Name: "卧室", CreatedAt: 2, UpdatedAt: 2}
+	must(t, s.Zones.Create(bedroom))
+	if living.ID == 0 || bedroom.ID == 0 || living.ID == bedroom.ID {
+		t.Fatalf("Create assigned IDs %d and %d, want distinct non-zero IDs", living.ID, bedroom.ID)
+	}
+
+	got, err := s.Zones.Get(living.ID)
+	must(t, err)
+	if *got != *living {
+		t.Errorf("Get = %+v, want %+v", got, living)
+	}
+	got, err = s.Zones.GetByName("卧室")
+	must(t, err)
+	if *got != *bedroom {
+		t.Errorf("GetByName = %+v, want %+v", got, bedroom)
+	}
+
+	zones, err := s.Zones.List()
+	must(t, err)
+	if len(zones) != 2 || zones[0].Name != "卧室" || zones[1].Name != "客厅" {
+		t.Errorf("List = %+v, want 卧室 and 客厅 ordered by name", zones)
+	}
+
+	// Returned records are copies
+	got.Name = "changed"
+	if again, _ := s.Zones.Get(bedroom.ID); again.Name != "卧室" {
+		t.Errorf("changing a returned zone changed the stored zone to %q", again.Name)
+	}
+
+	wantErr(t, s.Zones.Create(&store.Zone{Name: "客厅"}), store.ErrConflict)
+	wantErr(t, s.Zones.Update(&store.Zone{ID: bedroom.ID, Name: "客厅"}), store.ErrConflict)
+
+	living.Name = "大客厅"
+	living.Description = ""
+	living.UpdatedAt = 3
+	must(t, s.Zones.Update(living))
+	got, err = s.Zones.Get(living.ID)
+	must(t, err)
+	if got.Name != "大客厅" || got.Description != "" || got.UpdatedAt != 3 || got.CreatedAt != 1 {
+		t.Errorf("after Update, Get = %+v", got)
+	}
+
+	wantErr(t, s.Zones.Update(&store.Zone{ID: 999, Name: "x"}), store.ErrNotFound)
+	must(t, s.Zones.Delete(bedroom.ID))
+	wantErr(t, s.Zones.Delete(bedroom.ID), store.ErrNotFound)
+	_, err = s.Zones.Get(bedroom.ID)
+	wantErr(t, err, store.ErrNotFound)
+	_, err = s.Zones.GetByName("卧室")
+	wantErr(t, err, store.ErrNotFound)
+}
+
+func testZoneAliases(t *testing.T, s *store.Store) {
+	living := createZone(t, s, "客厅")
+	bedroom := createZone(t, s, "卧室")
+
+	hall := &store.ZoneAlias{ZoneID: living.ID, Alias: "大厅", CreatedAt: 1}
+	must(t, s.ZoneAliases.Create(hall))
+	must(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: living.ID, Alias: "客厅区", CreatedAt: 1}))
+	must(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: bedroom.ID, Alias: "主卧", CreatedAt: 1}))
+	if hall.ID == 0 {
+		t.Fatal("Create did not assign an ID")
+	}
+
+	// Aliases are unique across zones
+	wantErr(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: bedroom.ID, Alias: "大厅"}), store.ErrConflict)
+	wantErr(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: 999, Alias: "无"}), store.ErrNotFound)
+
+	got, err := s.ZoneAliases.GetByAlias("大厅")
+	must(t, err)
+	if *got != *hall {
+		t.Errorf("GetByAlias = %+v, want %+v", got, hall)
+	}
+
+	aliases, err := s.ZoneAliases.ListByZone(living.ID)
+	must(t, err)
+	if len(aliases) != 2 || aliases[0].Alias > aliases[1].Alias {
+		t.Errorf("ListByZone = %+v, want the 2 aliases of 客厅 ordered by alias", aliases)
+	}
+	aliases, err = s.ZoneAliases.List()
+	must(t, err)
+	if len(aliases) != 3 {
+		t.Errorf("List returned %d aliases, want 3", len(aliases))
+	}
+	aliases, err = s.ZoneAliases.ListByZone(999)
+	must(t, err)
+	if aliases == nil || len(aliases) != 0 {
+		t.Errorf("ListByZone of a missing zone = %#v, want an empty slice", aliases)
+	}
+
+	must(t, s.ZoneAliases.Delete(hall.ID))
+	wantErr(t, s.ZoneAliases.Delete(hall.ID), store.ErrNotFound)
+	_, err = s.ZoneAliases.GetByAlias("大厅")
+	wantErr(t, err, store.ErrNotFound)
+}
+
+func testDeviceTypes(t *testing.T, s *store.Store) {
+	light := &store.DeviceType{Name: "灯", Description: "lights", CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.DeviceTypes.Create(light))
+	curtain := &store.DeviceType{Name: "窗帘", CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.DeviceTypes.Create(curtain))
+
+	got, err := s.DeviceTypes.Get(light.ID)
+	must(t, err)
+	if *got != *light {
+		t.Errorf("Get = %+v, want %+v", got, light)
+	}
+	got, err = s.DeviceTypes.GetByName("窗帘")
+	must(t, err)
+	if *got != *curtain {
+		t.Errorf("GetByName = %+v, want %+v", got, curtain)
+	}
+
+	deviceTypes, err := s.DeviceTypes.List()
+	must(t, err)
+	if len(deviceTypes) != 2 || deviceTypes[0].Name > deviceTypes[1].Name {
+		t.Errorf("List = %+v, want 2 device types ordered by name", deviceTypes)
+	}
+
+	wantErr(t, s.DeviceTypes.Create(&store.DeviceType{Name: "灯"}), store.ErrConflict)
+	wantErr(t, s.DeviceTypes.Update(&store.DeviceType{ID: curtain.ID, Name: "灯"}), store.ErrConflict)
+	wantErr(t, s.DeviceTypes.Update(&store.DeviceType{ID: 999, Name: "x"}), store.ErrNotFound)
+
+	curtain.Description = "curtains"
+	curtain.UpdatedAt = 2
+	must(t, s.DeviceTypes.Update(curtain))
+	got, err = s.DeviceTypes.Get(curtain.ID)
+	must(t, err)
+	if *got != *curtain {
+		t.Errorf("after Update, Get = %+v, want %+v", got, curtain)
+	}
+
+	must(t, s.DeviceTypes.Delete(curtain.ID))
+	wantErr(t, s.DeviceTypes.Delete(curtain.ID), store.ErrNotFound)
+}
+
+func testOperations(t *testing.T, s *store.Store) {
+	light := createDeviceType(t, s, "灯")
+	curtain := createDeviceType(t, s, "窗帘")
+
+	on := &store.Operation{Name: "开", DeviceTypeID: light.ID, Description: "turn on", CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Operations.Create(on))
+	must(t, s.Operations.Create(&store.Operation{Name: "关", DeviceTypeID: light.ID, CreatedAt: 1, UpdatedAt: 1}))
+	// Names are unique per device type only
+	curtainOn := &store.Operation{Name: "开", DeviceTypeID: curtain.ID, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Operations.Create(curtainOn))
+
+	wantErr(t, s.Operations.Create(&store.Operation{Name: "开", DeviceTypeID: light.ID}), store.ErrConflict)
+	wantErr(t, s.Operations.Create(&store.Operation{Name: "开", DeviceTypeID: 999}), store.ErrNotFound)
+
+	got, err := s.Operations.Get(on.ID)
+	must(t, err)
+	if *got != *on {
+		t.Errorf("Get = %+v, want %+v", got, on)
+	}
+	got, err = s.Operations.GetByName(curtain.ID, "开")
+	must(t, err)
+	if *got != *curtainOn {
+		t.Errorf("GetByName = %+v, want %+v", got, curtainOn)
+	}
+	_, err = s.Operations.GetByName(curtain.ID, "关")
+	wantErr(t, err, store.ErrNotFound)
+
+	operations, err := s.Operations.ListByDeviceType(light.ID)
+	must(t, err)
+	if len(operations) != 2 || operations[0].Name > operations[1].Name {
+		t.Errorf("ListByDeviceType = %+v, want the 2 operations of 灯 ordered by name", operations)
+	}
+	operations, err = s.Operations.List()
+	must(t, err)
+	if len(operations) != 3 || operations[0].DeviceTypeID != light.ID || operations[2].DeviceTypeID != curtain.ID {
+		t.Errorf("List = %+v, want 3 operations ordered by device type", operations)
+	}
+
+	wantErr(t, s.Operations.Update(&store.Operation{ID: curtainOn.ID, Name: "关", DeviceTypeID: light.ID}), store.ErrConflict)
+	wantErr(t, s.Operations.Update(&store.Operation{ID: curtainOn.ID, Name: "开", DeviceTypeID: 999}), store.ErrNotFound)
+	wantErr(t, s.Operations.Update(&store.Operation{ID: 999, Name: "x", DeviceTypeID: light.ID}), store.ErrNotFound)
+
+	curtainOn.Name = "打开"
+	curtainOn.UpdatedAt = 2
+	must(t, s.Operations.Update(curtainOn))
+	got, err = s.Operations.Get(curtainOn.ID)
+	must(t, err)
+	if *got != *curtainOn {
+		t.Errorf("after Update, Get = %+v, want %+v", got, curtainOn)
+	}
+
+	must(t, s.Operations.Delete(on.ID))
+	wantErr(t, s.Operations.Delete(on.ID), store.ErrNotFound)
+}
+
+func testMappings(t *testing.T, s *store.Store) {
+	living := createZone(t, s, "客厅")
+	bedroom := createZone(t, s, "卧室")
+	light := createDeviceType(t, s, "灯")
+	on := createOperation(t, s, light.ID, "开")
+
+	mapping := &store.Mapping{
+		ZoneID: living.ID, DeviceTypeID: light.ID, OperationID: on.ID,
+		EntityID: "light.living_room", Service: "light.turn_on",
+		Params: `{"brightness":255}`, CreatedAt: 1, UpdatedAt: 1,
+	}
+	must(t, s.Mappings.Create(mapping))
+	// Empty JSON documents are stored as empty strings
+	other := &store.Mapping{
+		ZoneID: bedroom.ID, DeviceTypeID: light.ID, OperationID: on.ID,
+		EntityID: "light.bedroom", Service: "light.turn_on", CreatedAt: 2, UpdatedAt: 2,
+	}
+	must(t, s.Mappings.Create(other))
+
+	got, err := s.Mappings.Get(mapping.ID)
+	must(t, err)
+	if *got != *mapping {
+		t.Errorf("Get = %+v, want %+v", got, mapping)
+	}
+	got, err = s.Mappings.Find(bedroom.ID, light.ID, on.ID)
+	must(t, err)
+	if *got != *other {
+		t.Errorf("Find = %+v, want %+v", got, other)
+	}
+	_, err = s.Mappings.Find(bedroom.ID, light.ID, 999)
+	wantErr(t, err, store.ErrNotFound)
+
+	mappings, err := s.Mappings.List()
+	must(t, err)
+	if len(mappings) != 2 || mappings[0].ID != mapping.ID || mappings[1].ID != other.ID {
+		t.Errorf("List = %+v, want both mappings ordered by ID", mappings)
+	}
+	mappings, err = s.Mappings.ListByZone(bedroom.ID)
+	must(t, err)
+	if len(mappings) != 1 || mappings[0].ID != other.ID {
+		t.Errorf("ListByZone = %+v, want the mapping of 卧室", mappings)
+	}
+
+	duplicate := *mapping
+	duplicate.ID = 0
+	wantErr(t, s.Mappings.Create(&duplicate), store.ErrConflict)
+	for _, missing := range []store.Mapping{
+		{ZoneID: 999, DeviceTypeID: light.ID, OperationID: on.ID},
+		{ZoneID: living.ID, DeviceTypeID: 999, OperationID: on.ID},
+		{ZoneID: living.ID, DeviceTypeID: light.ID, OperationID: 999},
+	} {
+		missing.EntityID, missing.Service = "light.x", "light.turn_on"
+		wantErr(t, s.Mappings.Create(&missing), store.ErrNotFound)
+	}
+
+	moved := *other
+	moved.ZoneID = living.ID
+	wantErr(t, s.Mappings.Update(&moved), store.ErrConflict)
+	moved.ZoneID = 999
+	wantErr(t, s.Mappings.Update(&moved), store.ErrNotFound)
+
+	// Update keeps the creation time
+	other.Service = "light.toggle"
+	other.ValueMapping = `{"on":"turn_on"}`
+	other.UpdatedAt = 3
+	changed := *other
+	changed.CreatedAt = 100
+	must(t, s.Mappings.Update(&changed))
+	got, err = s.Mappings.Get(other.ID)
+	must(t, err)
+	if *got != *other {
+		t.Errorf("after Update, Get = %+v, want %+v", got, other)
+	}
+
+	must(t, s.Mappings.Delete(other.ID))
+	wantErr(t, s.Mappings.Delete(other.ID), store.ErrNotFound)
+	wantErr(t, s.Mappings.Update(other), store.ErrNotFound)
+}
+
+func testScenes(t *testing.T, s *store.Store) {
+	movie := &store.Scene{Name: "观影", SceneID: "movie_mode", Actions: `[]`, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Scenes.Create(movie))
+	sleep := &store.Scene{Name: "睡眠", SceneID: "sleep_mode", Description: "night", Actions: `[]`, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Scenes.Create(sleep))
+
+	got, err := s.Scenes.Get(movie.ID)
+	must(t, err)
+	if *got != *movie {
+		t.Errorf("Get = %+v, want %+v", got, movie)
+	}
+	got, err = s.Scenes.GetBySceneID("sleep_mode")
+	must(t, err)
+	if *got != *sleep {
+		t.Errorf("GetBySceneID = %+v, want %+v", got, sleep)
+	}
+
+	scenes, err := s.Scenes.List()
+	must(t, err)
+	if len(scenes) != 2 || scenes[0].Name > scenes[1].Name {
+		t.Errorf("List = %+v, want 2 scenes ordered by name", scenes)
+	}
+
+	// Both the name and the scene ID are unique
+	wantErr(t, s.Scenes.Create(&store.Scene{Name: "观影", SceneID: "other", Actions: `[]`}), store.ErrConflict)
+	wantErr(t, s.Scenes.Create(&store.Scene{Name: "其他", SceneID: "movie_mode", Actions: `[]`}), store.ErrConflict)
+	wantErr(t, s.Scenes.Update(&store.Scene{ID: sleep.ID, Name: "睡眠", SceneID: "movie_mode", Actions: `[]`}), store.ErrConflict)
+	wantErr(t, s.Scenes.Update(&store.Scene{ID: 999, Name: "x", SceneID: "x", Actions: `[]`}), store.ErrNotFound)
+
+	sleep.Actions = `[{"service":"light.turn_off"}]`
+	sleep.UpdatedAt = 2
+	changed := *sleep
+	changed.CreatedAt = 100
+	must(t, s.Scenes.Update(&changed))
+	got, err = s.Scenes.Get(sleep.ID)
+	must(t, err)
+	if *got != *sleep {
+		t.Errorf("after Update, Get = %+v, want %+v", got, sleep)
+	}
+
+	must(t, s.Scenes.Delete(sleep.ID))
+	wantErr(t, s.Scenes.Delete(sleep.ID), store.ErrNotFound)
+	_, err = s.Scenes.GetBySceneID("sleep_mode")
+	wantErr(t, err, store.ErrNotFound)
+}
+
+func testCascadingDeletes(t *testing.T, s *store.Store) {
+	living := createZone(t, s, "客厅")
+	bedroom := createZone(t, s, "卧室")
+	light := createDeviceType(t, s, "灯")
+	curtain := createDeviceType(t, s, "窗帘")
+	lightOn := createOperation(t, s, light.ID, "开")
+	lightOff := createOperation(t, s, light.ID, "关")
+	curtainOpen := createOperation(t, s, curtain.ID, "开")
+
+	must(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: living.ID, Alias: "大厅"}))
+	must(t, s.ZoneAliases.Create(&store.ZoneAlias{ZoneID: bedroom.ID, Alias: "主卧"}))
+	for _, m := range []struct {
+		zone      *store.Zone
+		operation *store.Operation
+	}{
+		{living, lightOn}, {living, lightOff}, {living, curtainOpen},
+		{bedroom, lightOn}, {bedroom, curtainOpen},
+	} {
+		must(t, s.Mappings.Create(&store.Mapping{
+			ZoneID: m.zone.ID, DeviceTypeID: m.operation.DeviceTypeID, OperationID: m.operation.ID,
+			EntityID: "switch.x", Service: "switch.turn_on",
+		}))
+	}
+
+	// Deleting a zone deletes its aliases and mappings
+	must(t, s.Zones.Delete(bedroom.ID))
+	aliases, err := s.ZoneAliases.List()
+	must(t, err)
+	if len(aliases) != 1 || aliases[0].Alias != "大厅" {
+		t.Errorf("aliases after deleting 卧室 = %+v, want only 大厅", aliases)
+	}
+	wantMappings(t, s, 3)
+
+	// Deleting an operation deletes its mappings
+	must(t, s.Operations.Delete(lightOff.ID))
+	wantMappings(t, s, 2)
+
+	// Deleting a device type deletes its operations and their mappings
+	must(t, s.DeviceTypes.Delete(curtain.ID))
+	wantMappings(t, s, 1)
+	operations, err := s.Operations.List()
+	must(t, err)
+	if len(operations) != 1 || operations[0].ID != lightOn.ID {
+		t.Errorf("operations after deleting 窗帘 = %+v, want only 灯/开", operations)
+	}
+}
+
+func testNonces(t *testing.T, s *store.Store) {
+	must(t, s.Nonces.Create("a", 100))
+	must(t, s.Nonces.Create("b", 200))
+	wantErr(t, s.Nonces.Create("a", 300), store.ErrConflict)
+
+	expiresAt, err := s.Nonces.Take("a")
+	must(t, err)
+	if expiresAt != 100 {
+		t.Errorf("Take = %d, want 100", expiresAt)
+	}
+	_, err = s.Nonces.Take("a")
+	wantErr(t, err, store.ErrNotFound)
+
+	// Nonces expiring at the given time are kept
+	must(t, s.Nonces.Create("c", 150))
+	must(t, s.Nonces.DeleteExpired(150))
+	_, err = s.Nonces.Take("c")
+	must(t, err)
+	must(t, s.Nonces.DeleteExpired(201))
+	_, err = s.Nonces.Take("b")
+	wantErr(t, err, store.ErrNotFound)
+}
+
+func testConcurrentNonceTake(t *testing.T, s *store.Store) {
+	const callers = 8
+	must(t, s.Nonces.Create("shared", 100))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Nonces.Take("shared")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	taken := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			taken++
+		case !errors.Is(err, store.ErrNotFound):
+			t.Errorf("concurrent Take failed: %v", err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("%d concurrent callers took the nonce, want 1", taken)
+	}
+}
+
+// testConcurrentWrites checks that writers wait for each other instead of
+// failing while the database is busy
+func testConcurrentWrites(t *testing.T, s *store.Store) {
+	const writers, zonesPerWriter = 8, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*zonesPerWriter)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < zonesPerWriter; j++ {
+				errs <- s.Zones.Create(&store.Zone{Name: fmt.Sprintf("zone-%d-%d", i, j)})
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent Create failed: %v", err)
+		}
+	}
+	zones, err := s.Zones.List()
+	must(t, err)
+	if len(zones) != writers*zonesPerWriter {
+		t.Errorf("%d zones stored, want %d", len(zones), writers*zonesPerWriter)
+	}
+}
+
+func testTransactions(t *testing.T, s *store.Store) {
+	living := createZone(t, s, "客厅")
+
+	// A failing transaction leaves nothing behind
+	errFailed := errors.New("failed")
+	err := s.WithTx(func(tx *store.Store) error {
+		must(t, tx.Zones.Create(&store.Zone{Name: "卧室"}))
+		must(t, tx.Zones.Delete(living.ID))
+		return errFailed
+	})
+	wantErr(t, err, errFailed)
+
+	zones, err := s.Zones.List()
+	must(t, err)
+	if len(zones) != 1 || zones[0].ID != living.ID {
+		t.Errorf("after rollback, List = %+v, want only 客厅", zones)
+	}
+
+	// A nested transaction is part of the outer one
+	kitchen := &store.Zone{Name: "厨房"}
+	must(t, s.WithTx(func(tx *store.Store) error {
+		if _, err := tx.Zones.GetByName("客厅"); err != nil {
+			return err
+		}
+		if err := tx.Zones.Create(&store.Zone{Name: "卧室"}); err != nil {
+			return err
+		}
+		return tx.WithTx(func(nested *store.Store) error {
+			return nested.Zones.Create(kitchen)
+		})
+	}))
+
+	zones, err = s.Zones.List()
+	must(t, err)
+	if len(zones) != 3 {
+		t.Errorf("after commit, List = %+v, want 3 zones", zones)
+	}
+	got, err := s.Zones.GetByName("厨房")
+	must(t, err)
+	if got.ID != kitchen.ID {
+		t.Errorf("committed zone has ID %d, Create set %d", got.ID, kitchen.ID)
+	}
+}
+
+func testUsers(t *testing.T, s *store.Store) {
+	alice := &store.User{ID: "u1", Username: "alice", Email: "alice@example.com", Role: "admin", CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Users.Create(alice, "hash1"))
+	bob := &store.User{ID: "u2", Username: "bob", Role: "viewer", CreatedAt: 2, UpdatedAt: 2}
+	must(t, s.Users.Create(bob, "hash2"))
+
+	wantErr(t, s.Users.Create(&store.User{ID: "u3", Username: "alice", Role: "viewer"}, "x"), store.ErrConflict)
+	wantErr(t, s.Users.Create(&store.User{ID: "u1", Username: "carol", Role: "viewer"}, "x"), store.ErrConflict)
+
+	got, err := s.Users.Get("u1")
+	must(t, err)
+	if *got != *alice {
+		t.Errorf("Get = %+v, want %+v", got, alice)
+	}
+	got, err = s.Users.GetByUsername("bob")
+	must(t, err)
+	if *got != *bob {
+		t.Errorf("GetByUsername = %+v, want %+v", got, bob)
+	}
+	got, hash, err := s.Users.GetWithPasswordHash("alice")
+	must(t, err)
+	if *got != *alice || hash != "hash1" {
+		t.Errorf("GetWithPasswordHash = %+v, %q, want %+v, hash1", got, hash, alice)
+	}
+	_, _, err = s.Users.GetWithPasswordHash("carol")
+	wantErr(t, err, store.ErrNotFound)
+
+	users, err := s.Users.List()
+	must(t, err)
+	if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Errorf("List = %+v, want alice and bob ordered by username", users)
+	}
+
+	bob.Email = "bob@example.com"
+	bob.Role = "admin"
+	bob.UpdatedAt = 3
+	must(t, s.Users.Update(bob))
+	got, err = s.Users.Get("u2")
+	must(t, err)
+	if *got != *bob {
+		t.Errorf("after Update, Get = %+v, want %+v", got, bob)
+	}
+	wantErr(t, s.Users.Update(&store.User{ID: "u9", Username: "x"}), store.ErrNotFound)
+
+	must(t, s.Users.SetPasswordHash("u2", "hash3", 4))
+	got, hash, err = s.Users.GetWithPasswordHash("bob")
+	must(t, err)
+	if hash != "hash3" || got.UpdatedAt != 4 {
+		t.Errorf("after SetPasswordHash, hash = %q and updated_at = %d", hash, got.UpdatedAt)
+	}
+	wantErr(t, s.Users.SetPasswordHash("u9", "x", 4), store.ErrNotFound)
+
+	count, err := s.Users.Count()
+	must(t, err)
+	admins, err := s.Users.CountByRole("admin")
+	must(t, err)
+	viewers, err := s.Users.CountByRole("viewer")
+	must(t, err)
+	if count != 2 || admins != 2 || viewers != 0 {
+		t.Errorf("Count = %d, CountByRole(admin) = %d, CountByRole(viewer) = %d, want 2, 2, 0", count, admins, viewers)
+	}
+
+	must(t, s.Users.Delete("u1"))
+	wantErr(t, s.Users.Delete("u1"), store.ErrNotFound)
+	_, err = s.Users.Get("u1")
+	wantErr(t, err, store.ErrNotFound)
+	if count, _ := s.Users.Count(); count != 1 {
+		t.Errorf("Count after Delete = %d, want 1", count)
+	}
+}
+
+func createZone(t *testing.T, s *store.Store, name string) *store.Zone {
+	t.Helper()
+	zone := &store.Zone{Name: name, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Zones.Create(zone))
+	return zone
+}
+
+func createDeviceType(t *testing.T, s *store.Store, name string) *store.DeviceType {
+	t.Helper()
+	deviceType := &store.DeviceType{Name: name, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.DeviceTypes.Create(deviceType))
+	return deviceType
+}
+
+func createOperation(t *testing.T, s *store.Store, deviceTypeID int64, name string) *store.Operation {
+	t.Helper()
+	operation := &store.Operation{Name: name, DeviceTypeID: deviceTypeID, CreatedAt: 1, UpdatedAt: 1}
+	must(t, s.Operations.Create(operation))
+	return operation
+}
+
+func wantMappings(t *testing.T, s *store.Store, want int) {
+	t.Helper()
+	mappings, err := s.Mappings.List()
+	must(t, err)
+	if len(mappings) != want {
+		t.Errorf("%d mappings left, want %d", len(mappings), want)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func wantErr(t *testing.T, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
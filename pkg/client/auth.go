@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Tokens is the token pair held by a logged in client
+type Tokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// tokenResponse is the response of the login and refresh endpoints
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	MFARequired  bool   `json:"mfa_required"`
+	MFAToken     string `json:"mfa_token"`
+}
+
+// Login logs in with a username and password. If the account has two-factor
+// authentication enabled it returns ErrMFARequired, and LoginMFA must be
+// called with a code to finish logging in.
+func (c *Client) Login(ctx context.Context, username, password string) error {
+	params := map[string]string{
+		"username": username,
+		"password": password,
+	}
+	if c.device != "" {
+		params["device"] = c.device
+	}
+
+	resp, err := c.signedPost(ctx, "/auth/login", params)
+	if err != nil {
+		return err
+	}
+
+	if resp.MFARequired {
+		c.mu.Lock()
+		c.mfaToken = resp.MFAToken
+		c.mu.Unlock()
+		return ErrMFARequired
+	}
+
+	c.setTokens(resp)
+	return nil
+}
+
+// LoginMFA finishes a login that returned ErrMFARequired using a TOTP or recovery code
+func (c *Client) LoginMFA(ctx context.Context, code string) error {
+	c.mu.Lock()
+	mfaToken := c.mfaToken
+	c.mu.Unlock()
+
+	if mfaToken == "" {
+		return ErrNotLoggedIn
+	}
+
+	params := map[string]string{
+		"mfa_token": mfaToken,
+		"code":      code,
+	}
+	if c.device != "" {
+		params["device"] = c.device
+	}
+
+	resp, err := c.signedPost(ctx, "/auth/login/mfa", params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.mfaToken = ""
+	c.mu.Unlock()
+
+	c.setTokens(resp)
+	return nil
+}
+
+// Refresh exchanges the refresh token for a new token pair
+func (c *Client) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, nil)
+}
+
+// refresh exchanges the refresh token for a new token pair. Concurrent
+// callers wait for each other, and a caller whose stale check no longer
+// holds once it is its turn uses the tokens the previous refresh got.
+func (c *Client) refresh(ctx context.Context, stale func() bool) error {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	if stale != nil && !stale() {
+		return nil
+	}
+
+	c.mu.Lock()
+	refreshToken := c.refreshToken
+	c.mu.Unlock()
+
+	if refreshToken == "" {
+		return ErrNotLoggedIn
+	}
+
+	resp, err := c.signedPost(ctx, "/auth/refresh", map[string]string{
+		"refresh_token": refreshToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.setTokens(resp)
+	return nil
+}
+
+// Logout revokes the current session and forgets the tokens
+func (c *Client) Logout(ctx context.Context) error {
+	if err := c.do(ctx, http.MethodPost, "/auth/logout", nil, nil, nil); err != nil {
+		return err
+	}
+
+	c.SetTokens(Tokens{})
+	return nil
+}
+
+// Tokens returns the current token pair, e.g. to persist it between runs
+func (c *Client) Tokens() Tokens {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return Tokens{
+		AccessToken:  c.accessToken,
+		RefreshToken: c.refreshToken,
+		ExpiresAt:    c.expiresAt,
+	}
+}
+
+// SetTokens restores a token pair saved from Tokens
+func (c *Client) SetTokens(tokens Tokens) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.accessToken = tokens.AccessToken
+	c.refreshToken = tokens.RefreshToken
+	c.expiresAt = tokens.ExpiresAt
+}
+
+// token returns a valid access token, refreshing it when it is about to expire
+func (c *Client) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	accessToken := c.accessToken
+	refreshToken := c.refreshToken
+	expiresAt := c.expiresAt
+	c.mu.Unlock()
+
+	if accessToken == "" && refreshToken == "" {
+		return "", ErrNotLoggedIn
+	}
+
+	if expiring(accessToken, expiresAt) {
+		stale := func() bool {
+			tokens := c.Tokens()
+			return expiring(tokens.AccessToken, tokens.ExpiresAt)
+		}
+		if err := c.refresh(ctx, stale); err != nil {
+			return "", err
+		}
+
+		c.mu.Lock()
+		accessToken = c.accessToken
+		c.mu.Unlock()
+	}
+
+	return accessToken, nil
+}
+
+// expiring reports whether an access token is missing or about to expire
+func expiring(accessToken string, expiresAt time.Time) bool {
+	return accessToken == "" || time.Until(expiresAt) < refreshSkew
+}
+
+// setTokens stores the tokens of a login or refresh response
+func (c *Client) setTokens(resp *tokenResponse) {
+	c.SetTokens(Tokens{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+		ExpiresAt:    time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+	})
+}
+
+// signedPost posts to an endpoint that carries its nonce, timestamp and
+// version 1 signature in the JSON body, retrying when the nonce is rejected
+func (c *Client) signedPost(ctx context.Context, path string, params map[string]string) (*tokenResponse, error) {
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.Nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		body := make(map[string]string, len(params)+3)
+		for k, v := range params {
+			body[k] = v
+		}
+		body["nonce"] = nonce
+		body["timestamp"] = timestamp()
+		body["sign"] = Sign(c.signingKey, body)
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		var resp tokenResponse
+		err = c.send(ctx, http.MethodPost, apiPrefix+path, nil, data, nil, &resp)
+		if err == nil {
+			return &resp, nil
+		}
+		if !isNonceError(err) || attempt >= maxNonceRetries {
+			return nil, err
+		}
+	}
+}
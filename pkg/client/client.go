@@ -0,0 +1,253 @@
+// Package client is a Go client for the ha-mi API. It fetches nonces, signs
+// requests, logs in and keeps the access token fresh so callers only deal with
+// typed requests and responses.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1"
+
+	// refreshSkew is how long before expiry the access token is refreshed
+	refreshSkew = 30 * time.Second
+
+	// maxNonceRetries is how many times a request is retried with a new nonce
+	maxNonceRetries = 2
+)
+
+var (
+	// ErrNotLoggedIn is returned when a request needs a token but Login was not called
+	ErrNotLoggedIn = errors.New("client is not logged in")
+	// ErrMFARequired is returned by Login when the account has two-factor authentication enabled
+	ErrMFARequired = errors.New("two-factor authentication code required")
+)
+
+// APIError is an error response returned by the server
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ha-mi: %d %s", e.StatusCode, e.Message)
+}
+
+// isNonceError reports whether the server rejected the nonce of a request
+func isNonceError(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	return apiErr.Message == "expired nonce" || apiErr.Message == "invalid nonce"
+}
+
+// Client is a ha-mi API client. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	signingKey string
+	apiKey     string
+	device     string
+	httpClient *http.Client
+
+	// refreshMu serializes refreshes. Refresh tokens are single use and the
+	// server revokes the session when one is presented twice.
+	refreshMu sync.Mutex
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expiresAt    time.Time
+	mfaToken     string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAPIKey authenticates with an API key instead of logging in.
+// API key requests are not signed.
+func WithAPIKey(apiKey string) Option {
+	return func(c *Client) {
+		c.apiKey = apiKey
+	}
+}
+
+// WithDevice sets the device name recorded on the session created by Login
+func WithDevice(device string) Option {
+	return func(c *Client) {
+		c.device = device
+	}
+}
+
+// New creates a new Client for the server at baseURL, e.g. http://localhost:8080.
+// signingKey is the server's request signing key (auth.request_signing_key).
+func New(baseURL, signingKey string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		signingKey: signingKey,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Nonce fetches a single-use nonce from the server
+func (c *Client) Nonce(ctx context.Context) (string, error) {
+	query := url.Values{"timestamp": {timestamp()}}
+
+	var resp struct {
+		Nonce string `json:"nonce"`
+	}
+	if err := c.send(ctx, http.MethodGet, apiPrefix+"/auth/nonce", query, nil, nil, &resp); err != nil {
+		return "", err
+	}
+	return resp.Nonce, nil
+}
+
+// do performs an authenticated, signed API request and decodes the JSON
+// response into out. A request rejected because of its nonce is retried with
+// a new one, and a rejected access token is refreshed once.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.doOnce(ctx, method, apiPrefix+path, query, body, out)
+		if err == nil {
+			return nil
+		}
+
+		if isNonceError(err) && attempt < maxNonceRetries {
+			continue
+		}
+
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && c.apiKey == "" && !refreshed {
+			// Only refresh if no other request replaced the rejected token meanwhile
+			stale := func() bool { return c.Tokens().AccessToken == token }
+			if refreshErr := c.refresh(ctx, stale); refreshErr != nil {
+				return err
+			}
+			refreshed = true
+			continue
+		}
+
+		return err
+	}
+}
+
+// doOnce sends a single authenticated request and returns the access token it used
+func (c *Client) doOnce(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) (string, error) {
+	header := http.Header{}
+
+	if c.apiKey != "" {
+		header.Set("X-API-Key", c.apiKey)
+		return "", c.send(ctx, method, path, query, body, header, out)
+	}
+
+	token, err := c.token(ctx)
+	if err != nil {
+		return "", err
+	}
+	header.Set("Authorization", "Bearer "+token)
+
+	nonce, err := c.Nonce(ctx)
+	if err != nil {
+		return token, err
+	}
+	ts := timestamp()
+
+	header.Set("X-Timestamp", ts)
+	header.Set("X-Nonce", nonce)
+	header.Set("X-Sign-Version", "2")
+	header.Set("X-Sign", SignV2(c.signingKey, method, path, query, body, ts, nonce))
+
+	return token, c.send(ctx, method, path, query, body, header, out)
+}
+
+// send performs an HTTP request and decodes the JSON response into out
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body []byte, header http.Header, out interface{}) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    http.StatusText(resp.StatusCode),
+			RetryAfter: resp.Header.Get("Retry-After"),
+		}
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error != "" {
+			apiErr.Message = errResp.Error
+		}
+		return apiErr
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
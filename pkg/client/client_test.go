@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuthServer issues single-use refresh tokens and, like the real server,
+// revokes the session when a refresh token is presented twice
+type fakeAuthServer struct {
+	mu         sync.Mutex
+	generation int
+	refreshes  int
+	revoked    bool
+	// rejected is an access token the audit endpoint answers with 401
+	rejected string
+}
+
+func (s *fakeAuthServer) tokens() (string, string) {
+	return fmt.Sprintf("access-%d", s.generation), fmt.Sprintf("refresh-%d", s.generation)
+}
+
+func (s *fakeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON := func(status int, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch r.URL.Path {
+	case apiPrefix + "/auth/nonce":
+		writeJSON(http.StatusOK, map[string]string{"nonce": "n"})
+
+	case apiPrefix + "/auth/refresh":
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		_, current := s.tokens()
+		if s.revoked || body["refresh_token"] != current {
+			s.revoked = true
+			writeJSON(http.StatusUnauthorized, map[string]string{"error": "refresh token reused"})
+			return
+		}
+
+		s.generation++
+		s.refreshes++
+		access, refresh := s.tokens()
+		writeJSON(http.StatusOK, map[string]interface{}{
+			"access_token":  access,
+			"refresh_token": refresh,
+			"expires_in":    3600,
+		})
+
+	case apiPrefix + "/audit":
+		access, _ := s.tokens()
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.revoked || token != access || token == s.rejected {
+			writeJSON(http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+			return
+		}
+		writeJSON(http.StatusOK, map[string]interface{}{"entries": []AuditEntry{}})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	const workers = 16
+
+	tests := []struct {
+		name string
+		// expiresIn is how long the client believes its access token is valid
+		expiresIn time.Duration
+		// rejected makes the server refuse the current access token with 401
+		rejected bool
+	}{
+		{"token about to expire", refreshSkew / 2, false},
+		{"token already expired", -time.Minute, false},
+		{"token rejected by the server", time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &fakeAuthServer{}
+			if tt.rejected {
+				srv.rejected, _ = srv.tokens()
+			}
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+
+			c := New(ts.URL, "key")
+			access, refresh := srv.tokens()
+			c.SetTokens(Tokens{
+				AccessToken:  access,
+				RefreshToken: refresh,
+				ExpiresAt:    time.Now().Add(tt.expiresIn),
+			})
+
+			var wg sync.WaitGroup
+			errs := make(chan error, workers)
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.AuditLogs(context.Background(), AuditFilter{}); err != nil {
+						errs <- err
+					}
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Errorf("AuditLogs() error = %v", err)
+			}
+			if srv.revoked {
+				t.Error("session was revoked by a reused refresh token")
+			}
+			if srv.refreshes != 1 {
+				t.Errorf("refreshes = %d, want 1", srv.refreshes)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Import modes
+const (
+	// ImportMerge creates and updates the entries of the document and keeps everything else
+	ImportMerge = "merge"
+	// ImportReplace makes the stored configuration match the document exactly
+	ImportReplace = "replace"
+)
+
+// Document is the nested zones → devices → operations configuration
+// document described in design.md §5.4, plus device types and scenes
+type Document struct {
+	Zones       map[string]ZoneDocument       `json:"zones" yaml:"zones"`
+	DeviceTypes map[string]DeviceTypeDocument `json:"device_types,omitempty" yaml:"device_types,omitempty"`
+	Scenes      []SceneDocument               `json:"scenes,omitempty" yaml:"scenes,omitempty"`
+}
+
+// ZoneDocument holds the devices of a zone
+type ZoneDocument struct {
+	Description string                    `json:"description,omitempty" yaml:"description,omitempty"`
+	Aliases     []string                  `json:"aliases,omitempty" yaml:"aliases,omitempty"`
+	Devices     map[string]DeviceDocument `json:"devices" yaml:"devices"`
+}
+
+// DeviceDocument holds the operations of a device type within a zone
+type DeviceDocument struct {
+	Operations map[string]OperationDocument `json:"operations" yaml:"operations"`
+}
+
+// OperationDocument maps an operation to a Home Assistant service call
+type OperationDocument struct {
+	Entity       string                 `json:"entity" yaml:"entity"`
+	Service      string                 `json:"service" yaml:"service"`
+	Params       map[string]interface{} `json:"params,omitempty" yaml:"params,omitempty"`
+	ValueMapping map[string]interface{} `json:"value_mapping,omitempty" yaml:"value_mapping,omitempty"`
+}
+
+// DeviceTypeDocument holds the descriptions of a device type and its
+// operations, including those not mapped in any zone
+type DeviceTypeDocument struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Operations maps operation names to their descriptions
+	Operations map[string]string `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// SceneDocument is a scene definition as in design.md §3.4.1
+type SceneDocument struct {
+	Name        string        `json:"name" yaml:"name"`
+	SceneID     string        `json:"scene_id" yaml:"scene_id"`
+	Description string        `json:"description,omitempty" yaml:"description,omitempty"`
+	Actions     []SceneAction `json:"actions" yaml:"actions"`
+}
+
+// Change is a single difference applied, or to be applied, by an import
+type Change struct {
+	Action string `json:"action"` // create, update or delete
+	Kind   string `json:"kind"`   // zone, alias, device_type, operation, mapping or scene
+	Name   string `json:"name"`
+}
+
+// ImportResult lists the changes made by an import
+type ImportResult struct {
+	Mode    string   `json:"mode"`
+	DryRun  bool     `json:"dry_run"`
+	Changes []Change `json:"changes"`
+}
+
+// Export downloads the full configuration document
+func (c *Client) Export(ctx context.Context) (*Document, error) {
+	var doc Document
+	if err := c.do(ctx, http.MethodGet, "/export", nil, nil, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Import uploads a configuration document. mode is ImportMerge or
+// ImportReplace; with dryRun the server only reports the changes.
+func (c *Client) Import(ctx context.Context, doc *Document, mode string, dryRun bool) (*ImportResult, error) {
+	query := url.Values{"mode": {mode}}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	var result ImportResult
+	if err := c.do(ctx, http.MethodPost, "/import", query, doc, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SceneAction is a single step of a scene, either a device operation or a delay in seconds
+type SceneAction struct {
+	Zone       string      `json:"zone,omitempty" yaml:"zone,omitempty"`
+	DeviceType string      `json:"device_type,omitempty" yaml:"device_type,omitempty"`
+	Operation  string      `json:"operation,omitempty" yaml:"operation,omitempty"`
+	Value      interface{} `json:"value,omitempty" yaml:"value,omitempty"`
+	Delay      float64     `json:"delay,omitempty" yaml:"delay,omitempty"`
+}
+
+// AuditEntry is a single audit log record
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Action    string `json:"action"`
+	Detail    string `json:"detail"`
+	IP        string `json:"ip"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// AuditFilter narrows down the audit log entries returned by AuditLogs
+type AuditFilter struct {
+	AfterID int64
+	Action  string
+	UserID  string
+	Limit   int
+}
+
+// AuditLogs lists audit log entries, oldest first
+func (c *Client) AuditLogs(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := url.Values{}
+	if filter.AfterID > 0 {
+		query.Set("after", strconv.FormatInt(filter.AfterID, 10))
+	}
+	if filter.Action != "" {
+		query.Set("action", filter.Action)
+	}
+	if filter.UserID != "" {
+		query.Set("user_id", filter.UserID)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	var resp struct {
+		Entries []AuditEntry `json:"entries"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/audit", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Entries, nil
+}
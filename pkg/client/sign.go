@@ -0,0 +1,70 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sign generates a version 1 signature: HMAC-SHA256 over the parameters
+// sorted by key and joined as key1=value1&key2=value2, excluding sign itself.
+// It matches auth.SecurityService.GenerateSignature on the server.
+func Sign(key string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "sign" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteString("&")
+		}
+		sb.WriteString(k)
+		sb.WriteString("=")
+		sb.WriteString(params[k])
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(sb.String()))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SignV2 generates a version 2 signature over the canonical request:
+// METHOD\nPATH\nsorted-query\nsha256(body)\ntimestamp\nnonce
+// It matches auth.SecurityService.GenerateSignatureV2 on the server.
+func SignV2(key, method, path string, query url.Values, body []byte, timestamp, nonce string) string {
+	q := url.Values{}
+	for k, v := range query {
+		if k != "sign" {
+			q[k] = v
+		}
+	}
+
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		q.Encode(),
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+	}, "\n")
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(canonical))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// timestamp returns the current UNIX time in milliseconds
+func timestamp() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
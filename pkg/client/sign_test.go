@@ -0,0 +1,115 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/boringsoft/ha-mi/internal/auth"
+)
+
+const testSigningKey = "test-signing-key"
+
+func TestSignMatchesServer(t *testing.T) {
+	security := auth.NewSecurityService(testSigningKey, 300)
+
+	tests := []struct {
+		name   string
+		params map[string]string
+	}{
+		{"login", map[string]string{"username": "admin", "password": "secret", "nonce": "n1", "timestamp": "1700000000000"}},
+		{"empty value", map[string]string{"username": "admin", "password": "", "nonce": "n2", "timestamp": "1700000000000"}},
+		{"non-ASCII", map[string]string{"username": "管理员", "device": "客厅平板", "nonce": "n3", "timestamp": "1700000000000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sign := Sign(testSigningKey, tt.params)
+			if err := security.ValidateSignature(tt.params, sign); err != nil {
+				t.Errorf("ValidateSignature() error = %v", err)
+			}
+
+			// The sign parameter itself is never part of the signature
+			withSign := map[string]string{"sign": sign}
+			for k, v := range tt.params {
+				withSign[k] = v
+			}
+			if got := Sign(testSigningKey, withSign); got != sign {
+				t.Errorf("Sign() with sign param = %s, want %s", got, sign)
+			}
+		})
+	}
+}
+
+// TestSignV2MatchesServer signs requests with the SDK, sends them over HTTP and
+// verifies them with the server implementation on what the server receives
+func TestSignV2MatchesServer(t *testing.T) {
+	security := auth.NewSecurityService(testSigningKey, 300)
+
+	const (
+		timestamp = "1700000000000"
+		nonce     = "nonce"
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = security.ValidateSignatureV2(r.Method, r.URL.Path, r.URL.Query(), body, timestamp, nonce, r.Header.Get("X-Sign"))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		// rawQuery is sent as is, so it may be in any order
+		rawQuery string
+		body     []byte
+	}{
+		{"empty body", http.MethodGet, "/api/v1/users", "", nil},
+		{"empty JSON body", http.MethodPost, "/api/v1/auth/logout", "", []byte{}},
+		{"lowercase method", "delete", "/api/v1/users/42", "", nil},
+		{"unsorted query", http.MethodGet, "/api/v1/audit", "limit=10&action=login&after=5", nil},
+		{"repeated query keys", http.MethodGet, "/api/v1/audit", "user_id=b&action=login&user_id=a", nil},
+		{"escaped query", http.MethodGet, "/api/v1/audit", "action=a%26b&detail=x+y%3Dz", nil},
+		{"JSON body", http.MethodPut, "/api/v1/roles/viewer", "", []byte(`{"permissions":["users:read"]}`)},
+		{"non-ASCII", http.MethodPost, "/api/v1/zones/客厅", "name=卧室&mode=自动", []byte(`{"name":"书房灯"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.rawQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			target := srv.URL + tt.path
+			if tt.rawQuery != "" {
+				target += "?" + tt.rawQuery
+			}
+			req, err := http.NewRequest(tt.method, target, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("X-Sign", SignV2(testSigningKey, tt.method, tt.path, query, tt.body, timestamp, nonce))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				msg, _ := io.ReadAll(resp.Body)
+				t.Errorf("ValidateSignatureV2() error = %s", bytes.TrimSpace(msg))
+			}
+		})
+	}
+}